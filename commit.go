@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/charmbracelet/huh"
@@ -80,79 +81,251 @@ func showGitHubRepoForm(token string) (string, string, bool, string, error) {
 	return repoName, repoDesc, repoPrivate, defaultBranch, err
 }
 
-// display a huh form for comprehensive commit message input
-func showCommitForm() (string, error) {
-	var commitType string
-	var commitScope string
-	var commitSubject string
-	var commitBody string
+// what the commit form collected
+type CommitInput struct {
+	Message   string
+	SkipHooks bool
+	Editor    bool // finish the message in the editor
+	Edited    bool // the message was finished in the editor
+}
+
+// what the commit form offers besides the message being amended
+type CommitFormContext struct {
+	Identity Identity
+	Rules    *CommitRules
+	Hooks    bool     // hooks are installed, so skipping them is offered
+	Authors  []string // previous authors offered as co-authors
+	Only     []string // paths committed instead of the staged changes, nil for the index
+}
+
+// display a huh form for comprehensive commit message input following the
+// commit rules, with a second page for the trailers, pre-filled from
+// previous when amending
+func showCommitForm(previous string, ctx *CommitFormContext) (*CommitInput, error) {
+	rules := ctx.Rules
+	types := make([]huh.Option[string], len(rules.Types))
+	for i, t := range rules.Types {
+		types[i] = huh.NewOption(t.Name+": "+t.Description, t.Name)
+	}
+
+	parsed := parseCommitMessage(previous)
+	commitType, commitScope, commitSubject := parsed.Type, parsed.Scope, parsed.Subject
+	// a type the form does not offer stays part of the subject
+	if commitType != "" && !rules.hasType(commitType) {
+		commitSubject, _, _ = strings.Cut(strings.TrimSpace(previous), "\n")
+		commitType, commitScope = "", ""
+	}
 
-	// predefined commit types
-	types := []huh.Option[string]{
-		huh.NewOption("feat: a new feature", "feat"),
-		huh.NewOption("fix: a bug fix", "fix"),
-		huh.NewOption("docs: documentation only changes", "docs"),
-		huh.NewOption("style: changes that do not affect the meaning of the code", "style"),
-		huh.NewOption("refactor: a code change that neither fixes a bug nor adds a feature", "refactor"),
-		huh.NewOption("test: adding missing tests or correcting existing tests", "test"),
-		huh.NewOption("chore: changes to the build process or auxiliary tools", "chore"),
-		huh.NewOption("perf: a code change that improves performance", "perf"),
-		huh.NewOption("ci: changes to CI configuration files and scripts", "ci"),
-		huh.NewOption("build: changes that affect the build system or external dependencies", "build"),
-		huh.NewOption("revert: reverts a previous commit", "revert"),
+	signOff := ctx.Identity.String()
+	commitBody, trailers := splitTrailers(parsed.Body, signOff)
+	breaking := parsed.Breaking || trailers.Breaking != ""
+	closes := strings.Join(trailers.Closes, ", ")
+	refs := strings.Join(trailers.Refs, ", ")
+	coAuthors := trailers.CoAuthors
+	signedOff := trailers.SignOff != "" || (previous == "" && rules.signOff())
+
+	title := "commit type"
+	if previous != "" {
+		title = "amend commit type"
+	}
+
+	scopeTitle := "scope (optional)"
+	if rules.scopeRequired() {
+		scopeTitle = "scope"
+	}
+
+	// a fixed set of scopes is picked from, otherwise listed scopes are
+	// suggestions
+	var scopeField huh.Field
+	if rules.scopesRestricted() {
+		var scopes []huh.Option[string]
+		if !rules.scopeRequired() {
+			scopes = append(scopes, huh.NewOption("(none)", ""))
+		}
+		for _, scope := range rules.Scopes {
+			scopes = append(scopes, huh.NewOption(scope, scope))
+		}
+		scopeField = huh.NewSelect[string]().
+			Title(scopeTitle).
+			Description("the part of the project the change affects").
+			Options(scopes...).
+			Value(&commitScope).
+			Validate(rules.validateScope)
+	} else {
+		placeholder := "auth, api, ui"
+		if len(rules.Scopes) > 0 {
+			placeholder = strings.Join(rules.Scopes, ", ")
+		}
+		scopeField = huh.NewInput().
+			Title(scopeTitle).
+			Description("the scope of the change (e.g. component name)").
+			Placeholder(placeholder).
+			Suggestions(rules.Scopes).
+			Value(&commitScope).
+			Validate(rules.validateScope)
+	}
+
+	validateIssues := func(s string) error {
+		_, err := parseIssueRefs(s)
+		return err
+	}
+
+	footer := []huh.Field{
+		huh.NewConfirm().
+			Title("breaking change?").
+			Description("marks the type with ! for changes that break compatibility").
+			Value(&breaking),
+		huh.NewInput().
+			Title("breaking change description (optional)").
+			Description("what breaks and how to migrate, added as BREAKING CHANGE:").
+			Value(&trailers.Breaking),
+		huh.NewInput().
+			Title("closes issues (optional)").
+			Description("issues this commit resolves").
+			Placeholder("#12, owner/repo#34").
+			Value(&closes).
+			Validate(validateIssues),
+		huh.NewInput().
+			Title("related issues (optional)").
+			Description("issues this commit refers to").
+			Placeholder("#7").
+			Value(&refs).
+			Validate(validateIssues),
+	}
+
+	// co-authors already on the commit stay offered even if they are not
+	// in the history scanned
+	authors := ctx.Authors
+	for _, author := range coAuthors {
+		if !slices.Contains(authors, author) {
+			authors = append(authors, author)
+		}
+	}
+	if len(authors) > 0 {
+		footer = append(footer, huh.NewMultiSelect[string]().
+			Title("co-authors (optional)").
+			Description("people who worked on this commit with you, / to filter").
+			Options(huh.NewOptions(authors...)...).
+			Value(&coAuthors).
+			Height(8))
+	}
+
+	footer = append(footer, huh.NewConfirm().
+		Title("sign off?").
+		Description("add Signed-off-by: "+signOff+" certifying the change (DCO)").
+		Value(&signedOff))
+
+	input := &CommitInput{}
+	if ctx.Hooks {
+		footer = append(footer, huh.NewConfirm().
+			Title("skip hooks?").
+			Description("commit without running the pre-commit and commit-msg hooks").
+			Affirmative("skip").
+			Negative("run").
+			Value(&input.SkipHooks))
+	}
+	footer = append(footer, huh.NewConfirm().
+		Title("finish in editor?").
+		Description("open the message in "+gitEditor()+" for a longer body").
+		Affirmative("editor").
+		Negative("commit").
+		Value(&input.Editor))
+
+	committing := "committing"
+	if ctx.Only != nil {
+		committing = fmt.Sprintf("committing %d selected file(s)", len(ctx.Only))
 	}
 
 	form := huh.NewForm(
 		huh.NewGroup(
+			huh.NewNote().
+				Title(committing+" as "+ctx.Identity.String()).
+				Description("press I in the main view to switch identity profile"),
 			huh.NewSelect[string]().
-				Title("commit type").
+				Title(title).
 				Description("select the type of change you're committing (esc to cancel)").
 				Options(types...).
 				Value(&commitType),
-			huh.NewInput().
-				Title("scope (optional)").
-				Description("the scope of the change (e.g. component name)").
-				Placeholder("auth, api, ui").
-				Value(&commitScope),
+			scopeField,
 			huh.NewInput().
 				Title("subject").
-				Description("brief description of the change").
+				Description(fmt.Sprintf("brief description of the change, up to %d characters", rules.SubjectMaxLength)).
 				Placeholder("add user auth route").
 				Value(&commitSubject).
-				Validate(func(s string) error {
-					if s == "" {
-						return fmt.Errorf("commit subject cannot be empty")
-					}
-					if len(s) > 72 {
-						return fmt.Errorf("commit subject should be 72 characters or less")
-					}
-					return nil
-				}),
+				Validate(rules.validateSubject),
 			huh.NewText().
 				Title("body (optional)").
 				Description("detailed description of the change").
 				Placeholder("this change implements user authentication with...").
 				Value(&commitBody),
 		),
+		huh.NewGroup(footer...),
 	).WithTheme(huh.ThemeCharm())
 
 	err := form.Run()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var commitMessage string
+	header := commitType
 	if commitScope != "" {
-		commitMessage = fmt.Sprintf("%s(%s): %s", commitType, commitScope, commitSubject)
-	} else {
-		commitMessage = fmt.Sprintf("%s: %s", commitType, commitSubject)
+		header += "(" + commitScope + ")"
+	}
+	if breaking || trailers.Breaking != "" {
+		header += "!"
 	}
+	commitMessage := header + ": " + commitSubject
 
-	if commitBody != "" {
+	if commitBody = strings.TrimSpace(commitBody); commitBody != "" {
 		commitMessage += "\n\n" + commitBody
 	}
 
-	return commitMessage, nil
+	// validated by the inputs, so the errors are already ruled out
+	trailers.Closes, _ = parseIssueRefs(closes)
+	trailers.Refs, _ = parseIssueRefs(refs)
+	trailers.CoAuthors = coAuthors
+	trailers.SignOff = ""
+	if signedOff {
+		trailers.SignOff = signOff
+	}
+
+	input.Message = trailers.apply(commitMessage)
+	return input, nil
+}
+
+// the parts of a conventional commit message
+type ConventionalMessage struct {
+	Type     string
+	Scope    string
+	Subject  string
+	Body     string
+	Breaking bool // the type is marked with !
+}
+
+// splits a "type(scope)!: subject" message with an optional body back into
+// its parts; a subject that does not follow the convention is kept whole
+func parseCommitMessage(message string) ConventionalMessage {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return ConventionalMessage{}
+	}
+
+	subject, body, _ := strings.Cut(message, "\n")
+	body = strings.TrimSpace(body)
+
+	prefix, rest, ok := strings.Cut(subject, ": ")
+	if !ok || strings.ContainsAny(prefix, " \t") {
+		return ConventionalMessage{Subject: subject, Body: body}
+	}
+
+	parsed := ConventionalMessage{Type: prefix, Subject: rest, Body: body}
+	if trimmed, ok := strings.CutSuffix(parsed.Type, "!"); ok {
+		parsed.Type, parsed.Breaking = trimmed, true
+	}
+	if open := strings.Index(parsed.Type, "("); open > 0 && strings.HasSuffix(parsed.Type, ")") {
+		parsed.Type, parsed.Scope = parsed.Type[:open], parsed.Type[open+1:len(parsed.Type)-1]
+	}
+	return parsed
 }
 
 // display a huh form for branch creation
@@ -182,6 +355,112 @@ func showCreateBranchForm() (string, error) {
 	return branchName, err
 }
 
+// display a huh form for a new tag; an empty message makes it lightweight
+func showTagForm(target string) (string, string, error) {
+	var name string
+	var message string
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title("new tag at "+target).
+				Description("enter the name for the new tag (esc to cancel)").
+				Placeholder("v1.0.0").
+				Value(&name).
+				Validate(func(s string) error {
+					if s == "" {
+						return fmt.Errorf("tag name cannot be empty")
+					}
+					if strings.Contains(s, " ") {
+						return fmt.Errorf("tag name cannot contain spaces")
+					}
+					return nil
+				}),
+			huh.NewText().
+				Title("tag message").
+				Description("leave empty for a lightweight tag").
+				Value(&message),
+		),
+	).WithTheme(huh.ThemeCharm())
+
+	err := form.Run()
+	return name, strings.TrimSpace(message), err
+}
+
+// display a huh form confirming a cherry-pick, asking whether to note the
+// original commit in the message
+func showCherryPickForm(hash, subject, branch string) (bool, bool, error) {
+	var confirmed bool
+	var recordOrigin bool
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title("cherry-pick "+hash+" onto "+branch+"?").
+				Description(subject).
+				Affirmative("yes").
+				Negative("no").
+				Value(&confirmed),
+			huh.NewConfirm().
+				Title("add a \"(cherry picked from commit ...)\" line?").
+				Affirmative("yes").
+				Negative("no").
+				Value(&recordOrigin),
+		),
+	).WithTheme(huh.ThemeCharm())
+
+	err := form.Run()
+	return confirmed, recordOrigin, err
+}
+
+// display a huh form for choosing how to reset HEAD to a commit
+func showResetModeForm(target string) (string, error) {
+	mode := "mixed"
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title("reset HEAD to "+target).
+				Description("choose what happens to the index and worktree (esc to cancel)").
+				Options(
+					huh.NewOption("soft: keep the index and worktree", "soft"),
+					huh.NewOption("mixed: reset the index, keep the worktree", "mixed"),
+					huh.NewOption("hard: reset the index and worktree", "hard"),
+				).
+				Value(&mode),
+		),
+	).WithTheme(huh.ThemeCharm())
+
+	err := form.Run()
+	return mode, err
+}
+
+// display a huh form for picking the identity profile of this repository
+func showProfileForm(profiles []Profile, current string) (string, error) {
+	if len(profiles) == 0 {
+		return "", fmt.Errorf("no identity profiles, add them under profiles in the got config")
+	}
+
+	selected := current
+	options := make([]huh.Option[string], len(profiles))
+	for i, p := range profiles {
+		options[i] = huh.NewOption(p.Name+": "+p.UserName+" <"+p.Email+">", p.Name)
+	}
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title("identity profile").
+				Description("written to this repository's git config (esc to cancel)").
+				Options(options...).
+				Value(&selected),
+		),
+	).WithTheme(huh.ThemeCharm())
+
+	err := form.Run()
+	return selected, err
+}
+
 // display a huh form for branch selection
 func showBranchSelectionForm(branches []string) (string, error) {
 	if len(branches) == 0 {
@@ -209,6 +488,219 @@ func showBranchSelectionForm(branches []string) (string, error) {
 	return selectedBranch, err
 }
 
+// display a huh form for renaming a branch
+func showRenameBranchForm(oldName string) (string, error) {
+	newName := oldName
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title("rename branch " + oldName).
+				Description("enter the new name for the branch (esc to cancel)").
+				Value(&newName).
+				Validate(func(s string) error {
+					if s == "" {
+						return fmt.Errorf("branch name cannot be empty")
+					}
+					if strings.Contains(s, " ") {
+						return fmt.Errorf("branch name cannot contain spaces")
+					}
+					return nil
+				}),
+		),
+	).WithTheme(huh.ThemeCharm())
+
+	err := form.Run()
+	return newName, err
+}
+
+// display a huh form for choosing the commit to reset a branch to
+func showResetBranchForm(branch string) (string, error) {
+	var target string
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title("reset branch " + branch).
+				Description("commit, branch or tag to point the branch at (esc to cancel)").
+				Placeholder("origin/main, v1.0, a1b2c3d, HEAD~2").
+				Value(&target).
+				Validate(func(s string) error {
+					if s == "" {
+						return fmt.Errorf("target cannot be empty")
+					}
+					return nil
+				}),
+		),
+	).WithTheme(huh.ThemeCharm())
+
+	err := form.Run()
+	return target, err
+}
+
+// display a huh form asking to confirm a destructive action
+func showConfirmForm(title, description string) (bool, error) {
+	var confirmed bool
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title(title).
+				Description(description).
+				Affirmative("yes").
+				Negative("no").
+				Value(&confirmed),
+		),
+	).WithTheme(huh.ThemeCharm())
+
+	err := form.Run()
+	return confirmed, err
+}
+
+// display a huh form asking what to do with local changes before switching
+// branches; returns "" to cancel
+func showSwitchChangesForm(branch string, check *SwitchCheck) (string, error) {
+	var mode string
+
+	description := fmt.Sprintf("%d changed file(s).", len(check.Dirty)+len(check.Untracked))
+	blocking := append(append([]string(nil), check.Blocking...), check.Untracked...)
+	if len(blocking) > 0 {
+		description += " the checkout would overwrite: " + strings.Join(blocking, ", ")
+	}
+
+	options := []huh.Option[string]{
+		huh.NewOption("stash them and reapply after the switch", switchStash),
+	}
+	if len(blocking) == 0 {
+		options = append(options, huh.NewOption("carry them over to "+branch, switchCarry))
+	}
+	options = append(options,
+		huh.NewOption("discard them", switchDiscard),
+		huh.NewOption("cancel", ""),
+	)
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title("switch to " + branch + " with local changes").
+				Description(description).
+				Options(options...).
+				Value(&mode),
+		),
+	).WithTheme(huh.ThemeCharm())
+
+	err := form.Run()
+	return mode, err
+}
+
+// display a huh form for choosing the upstream of a branch
+func showUpstreamForm(branch string, remoteBranches []string) (string, error) {
+	if len(remoteBranches) == 0 {
+		return "", fmt.Errorf("no remote branches available")
+	}
+
+	var upstream string
+
+	options := make([]huh.Option[string], len(remoteBranches))
+	for i, name := range remoteBranches {
+		options[i] = huh.NewOption(name, name)
+	}
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title("set upstream of " + branch).
+				Description("choose the remote branch to track (esc to cancel)").
+				Options(options...).
+				Value(&upstream),
+		),
+	).WithTheme(huh.ThemeCharm())
+
+	err := form.Run()
+	return upstream, err
+}
+
+// display a huh form for choosing the upstream of an interactive rebase
+func showRebaseUpstreamForm(branch string, candidates []string, current string) (string, error) {
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no other branches to rebase onto")
+	}
+
+	upstream := current
+
+	options := make([]huh.Option[string], len(candidates))
+	for i, name := range candidates {
+		options[i] = huh.NewOption(name, name)
+	}
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title("rebase " + branch + " onto").
+				Description("commits of " + branch + " missing from this branch are replayed on it (esc to cancel)").
+				Options(options...).
+				Value(&upstream),
+		),
+	).WithTheme(huh.ThemeCharm())
+
+	err := form.Run()
+	return upstream, err
+}
+
+// display a huh form for the new message of a reworded commit
+func showRewordForm(message string) (string, error) {
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewText().
+				Title("reword commit").
+				Description("new commit message (esc to cancel)").
+				Value(&message).
+				Validate(func(s string) error {
+					if strings.TrimSpace(s) == "" {
+						return fmt.Errorf("commit message cannot be empty")
+					}
+					return nil
+				}),
+		),
+	).WithTheme(huh.ThemeCharm())
+
+	err := form.Run()
+	return strings.TrimSpace(message) + "\n", err
+}
+
+// display a huh form for saving a stash; the selected files option is only
+// offered when files are selected
+func showStashForm(selected int) (string, bool, bool, error) {
+	var message string
+	var includeUntracked bool
+	var onlySelected bool
+
+	fields := []huh.Field{
+		huh.NewInput().
+			Title("stash message (optional)").
+			Description("describe the changes being stashed (esc to cancel)").
+			Placeholder("half-done refactor").
+			Value(&message),
+		huh.NewConfirm().
+			Title("include untracked files?").
+			Affirmative("yes").
+			Negative("no").
+			Value(&includeUntracked),
+	}
+	if selected > 0 {
+		fields = append(fields, huh.NewConfirm().
+			Title(fmt.Sprintf("stash only the %d selected file(s)?", selected)).
+			Affirmative("yes").
+			Negative("no").
+			Value(&onlySelected))
+	}
+
+	form := huh.NewForm(huh.NewGroup(fields...)).WithTheme(huh.ThemeCharm())
+
+	err := form.Run()
+	return strings.TrimSpace(message), includeUntracked, onlySelected, err
+}
+
 // display a huh form for local git repository initialization
 func showLocalRepoForm() (string, error) {
 	var defaultBranch string
@@ -9,7 +9,18 @@ import (
 )
 
 type Config struct {
-	GitHubToken string `yaml:"github_token"`
+	GitHubToken string      `yaml:"github_token"`
+	GPGKeyring  string      `yaml:"gpg_keyring,omitempty"` // keyring file holding the openpgp signing key
+	Profiles    []Profile   `yaml:"profiles,omitempty"`
+	Commit      CommitRules `yaml:"commit,omitempty"` // overridden by a repository's .got.yaml
+}
+
+// a named author identity that can be applied to a repository
+type Profile struct {
+	Name       string `yaml:"name"`
+	UserName   string `yaml:"user_name"`
+	Email      string `yaml:"email"`
+	SigningKey string `yaml:"signing_key,omitempty"` // written to user.signingkey
 }
 
 // load configuration from the config file
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+// how a conflicted file is resolved: keep one side, or both one after the
+// other
+const (
+	resolveOurs   = "ours"
+	resolveTheirs = "theirs"
+	resolveBoth   = "both"
+)
+
+type Conflict struct {
+	Path string
+	Kind string // "both modified", "deleted by them", ... as git status says
+}
+
+type ConflictFile struct {
+	Conflict
+	Binary bool
+	// the worktree file split into clean text and conflicts; the Lines of a
+	// conflict region hold its raw text, markers included
+	Regions []mergeRegion
+	Hunks   []int // indexes of the conflict regions
+}
+
+// returns the unmerged paths of the index with the kind of each conflict
+func getConflicts() ([]Conflict, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	stages := map[string][4]bool{}
+	for _, e := range idx.Entries {
+		if e.Stage != 0 {
+			s := stages[e.Name]
+			s[e.Stage] = true
+			stages[e.Name] = s
+		}
+	}
+
+	var conflicts []Conflict
+	for path, s := range stages {
+		conflicts = append(conflicts, Conflict{Path: path, Kind: conflictKind(s[index.AncestorMode], s[index.OurMode], s[index.TheirMode])})
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Path < conflicts[j].Path
+	})
+
+	return conflicts, nil
+}
+
+// describes a conflict by which of base, ours and theirs have the file
+func conflictKind(base, ours, theirs bool) string {
+	switch {
+	case ours && theirs && base:
+		return "both modified"
+	case ours && theirs:
+		return "both added"
+	case base && theirs:
+		return "deleted by us"
+	case base && ours:
+		return "deleted by them"
+	case ours:
+		return "added by us"
+	case theirs:
+		return "added by them"
+	}
+	return "both deleted"
+}
+
+// loads the conflict hunks of a conflicted file from its worktree content
+func getConflictFile(c Conflict) (*ConflictFile, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := readWorktreeFile(w, c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &ConflictFile{Conflict: c}
+	if isBinary(content) {
+		f.Binary = true
+		return f, nil
+	}
+
+	f.Regions = parseConflictMarkers(content)
+	for i, region := range f.Regions {
+		if region.Conflict {
+			f.Hunks = append(f.Hunks, i)
+		}
+	}
+
+	// markers without a base section, fill it in from the merge base when
+	// the file still lines up with a fresh merge of the stages
+	base, err := readStageFile(r, c.Path, index.AncestorMode)
+	if err != nil {
+		return nil, err
+	}
+	ours, err := readStageFile(r, c.Path, index.OurMode)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := readStageFile(r, c.Path, index.TheirMode)
+	if err != nil {
+		return nil, err
+	}
+
+	var merged []mergeRegion
+	for _, region := range mergeLines(base, ours, theirs) {
+		if region.Conflict {
+			merged = append(merged, region)
+		}
+	}
+	if len(merged) == len(f.Hunks) {
+		for i, hunk := range f.Hunks {
+			if f.Regions[hunk].Base == nil {
+				f.Regions[hunk].Base = merged[i].Base
+			}
+		}
+	}
+
+	return f, nil
+}
+
+// reads one stage of an unmerged index entry, empty if the stage is missing
+func readStageFile(r *git.Repository, path string, stage index.Stage) (string, error) {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return "", err
+	}
+
+	for _, e := range idx.Entries {
+		if e.Name == path && e.Stage == stage {
+			return readBlob(r, e.Hash)
+		}
+	}
+	return "", nil
+}
+
+// splits file content on git conflict markers, with or without a diff3
+// base section
+func parseConflictMarkers(content string) []mergeRegion {
+	var regions []mergeRegion
+	var clean []string
+	var current *mergeRegion
+	section := ""
+
+	for _, line := range splitLines(content) {
+		switch {
+		case current == nil && isMarker(line, '<'):
+			if len(clean) > 0 {
+				regions = append(regions, mergeRegion{Lines: clean})
+				clean = nil
+			}
+			current = &mergeRegion{Conflict: true, Lines: []string{line}}
+			section = "ours"
+			continue
+		case current == nil:
+			clean = append(clean, line)
+			continue
+		case isMarker(line, '|') && section == "ours":
+			section = "base"
+			current.Base = []string{}
+		case isMarker(line, '=') && section != "theirs":
+			section = "theirs"
+		case isMarker(line, '>') && section == "theirs":
+			current.Lines = append(current.Lines, line)
+			regions = append(regions, *current)
+			current = nil
+			continue
+		case section == "ours":
+			current.Ours = append(current.Ours, line)
+		case section == "base":
+			current.Base = append(current.Base, line)
+		default:
+			current.Theirs = append(current.Theirs, line)
+		}
+		current.Lines = append(current.Lines, line)
+	}
+
+	// an unterminated conflict is left as plain text, joining the clean
+	// lines before it
+	if current != nil {
+		if n := len(regions); n > 0 && !regions[n-1].Conflict {
+			clean = regions[n-1].Lines
+			regions = regions[:n-1]
+		}
+		clean = append(clean, current.Lines...)
+	}
+	if len(clean) > 0 {
+		regions = append(regions, mergeRegion{Lines: clean})
+	}
+
+	return regions
+}
+
+// true for a line made of seven marker characters, optionally followed by a
+// label
+func isMarker(line string, char byte) bool {
+	if len(line) < 7 || strings.Count(line[:7], string(char)) != 7 {
+		return false
+	}
+	rest := strings.TrimRight(line[7:], "\r\n")
+	return rest == "" || rest[0] == ' '
+}
+
+// resolves one conflict hunk of a file in the worktree, staging the file
+// once no conflicts are left; returns whether it was staged
+func resolveConflictHunk(f *ConflictFile, hunk int, choice string) (bool, error) {
+	if hunk < 0 || hunk >= len(f.Hunks) {
+		return false, fmt.Errorf("no such conflict")
+	}
+
+	var b strings.Builder
+	for i, region := range f.Regions {
+		lines := region.Lines
+		if i == f.Hunks[hunk] {
+			lines = chooseLines(region, choice)
+		}
+		for _, line := range lines {
+			b.WriteString(line)
+		}
+	}
+
+	_, w, err := openRepo()
+	if err != nil {
+		return false, err
+	}
+	if err := writeWorktreeFile(w, f.Path, b.String(), worktreeMode(f.Path)); err != nil {
+		return false, err
+	}
+
+	if len(f.Hunks) > 1 {
+		return false, nil
+	}
+	return true, markResolved(f.Path)
+}
+
+// resolves a whole conflicted file with one side, or both sides of every
+// hunk, and stages it
+func resolveConflictFile(f *ConflictFile, choice string) error {
+	r, w, err := openRepo()
+	if err != nil {
+		return err
+	}
+
+	// content conflicts keep the merged text around the hunks
+	if !f.Binary && len(f.Hunks) > 0 {
+		var b strings.Builder
+		for _, region := range f.Regions {
+			lines := region.Lines
+			if region.Conflict {
+				lines = chooseLines(region, choice)
+			}
+			for _, line := range lines {
+				b.WriteString(line)
+			}
+		}
+		if err := writeWorktreeFile(w, f.Path, b.String(), worktreeMode(f.Path)); err != nil {
+			return err
+		}
+		return markResolved(f.Path)
+	}
+
+	// otherwise take the chosen side's version of the file, or its deletion
+	stage := index.OurMode
+	switch choice {
+	case resolveTheirs:
+		stage = index.TheirMode
+	case resolveBoth:
+		return fmt.Errorf("%s conflicts can only be resolved with one side", f.Kind)
+	}
+
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+	var entry *index.Entry
+	for _, e := range idx.Entries {
+		if e.Name == f.Path && e.Stage == stage {
+			entry = e
+		}
+	}
+
+	if entry == nil {
+		if err := w.Filesystem.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return markResolved(f.Path)
+	}
+
+	content, err := readBlob(r, entry.Hash)
+	if err != nil {
+		return err
+	}
+	if err := writeWorktreeFile(w, f.Path, content, entry.Mode); err != nil {
+		return err
+	}
+	return markResolved(f.Path)
+}
+
+// the lines that replace a conflict for a choice
+func chooseLines(region mergeRegion, choice string) []string {
+	switch choice {
+	case resolveOurs:
+		return region.Ours
+	case resolveTheirs:
+		return region.Theirs
+	}
+	return append(append([]string(nil), region.Ours...), region.Theirs...)
+}
+
+// file mode of a worktree file as the index would record it
+func worktreeMode(path string) filemode.FileMode {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&0111 != 0 {
+		return filemode.Executable
+	}
+	return filemode.Regular
+}
+
+// drops the conflict stages of a path and stages its worktree version, or
+// its deletion when the file is gone
+func markResolved(path string) error {
+	r, w, err := openRepo()
+	if err != nil {
+		return err
+	}
+
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+	removeIndexPath(idx, path)
+	if err := r.Storer.SetIndex(idx); err != nil {
+		return err
+	}
+
+	if _, err := w.Filesystem.Lstat(path); os.IsNotExist(err) {
+		return nil
+	}
+	_, err = w.Add(path)
+	return err
+}
+
+// true if the content still has a conflict marker at the start of a line
+func hasConflictMarkers(content string) bool {
+	for _, region := range parseConflictMarkers(content) {
+		if region.Conflict {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConflictMarkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []mergeRegion
+	}{
+		{
+			name:    "no conflicts",
+			content: "a\nb\n",
+			want:    []mergeRegion{{Lines: []string{"a\n", "b\n"}}},
+		},
+		{
+			name:    "a conflict between clean lines",
+			content: "a\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> feature\nb\n",
+			want: []mergeRegion{
+				{Lines: []string{"a\n"}},
+				{
+					Conflict: true,
+					Lines:    []string{"<<<<<<< HEAD\n", "ours\n", "=======\n", "theirs\n", ">>>>>>> feature\n"},
+					Ours:     []string{"ours\n"},
+					Theirs:   []string{"theirs\n"},
+				},
+				{Lines: []string{"b\n"}},
+			},
+		},
+		{
+			name:    "a diff3 base section",
+			content: "<<<<<<< HEAD\nours\n||||||| base\nbase\n=======\ntheirs\n>>>>>>> feature\n",
+			want: []mergeRegion{{
+				Conflict: true,
+				Lines:    []string{"<<<<<<< HEAD\n", "ours\n", "||||||| base\n", "base\n", "=======\n", "theirs\n", ">>>>>>> feature\n"},
+				Base:     []string{"base\n"},
+				Ours:     []string{"ours\n"},
+				Theirs:   []string{"theirs\n"},
+			}},
+		},
+		{
+			name:    "an empty diff3 base",
+			content: "<<<<<<< HEAD\nours\n|||||||\n=======\ntheirs\n>>>>>>>\n",
+			want: []mergeRegion{{
+				Conflict: true,
+				Lines:    []string{"<<<<<<< HEAD\n", "ours\n", "|||||||\n", "=======\n", "theirs\n", ">>>>>>>\n"},
+				Base:     []string{},
+				Ours:     []string{"ours\n"},
+				Theirs:   []string{"theirs\n"},
+			}},
+		},
+		{
+			name:    "an empty side",
+			content: "<<<<<<< HEAD\n=======\ntheirs\n>>>>>>> feature\n",
+			want: []mergeRegion{{
+				Conflict: true,
+				Lines:    []string{"<<<<<<< HEAD\n", "=======\n", "theirs\n", ">>>>>>> feature\n"},
+				Theirs:   []string{"theirs\n"},
+			}},
+		},
+		{
+			name:    "two conflicts",
+			content: "<<<<<<< HEAD\n1\n=======\n2\n>>>>>>> feature\nmid\n<<<<<<< HEAD\n3\n=======\n4\n>>>>>>> feature\n",
+			want: []mergeRegion{
+				{
+					Conflict: true,
+					Lines:    []string{"<<<<<<< HEAD\n", "1\n", "=======\n", "2\n", ">>>>>>> feature\n"},
+					Ours:     []string{"1\n"},
+					Theirs:   []string{"2\n"},
+				},
+				{Lines: []string{"mid\n"}},
+				{
+					Conflict: true,
+					Lines:    []string{"<<<<<<< HEAD\n", "3\n", "=======\n", "4\n", ">>>>>>> feature\n"},
+					Ours:     []string{"3\n"},
+					Theirs:   []string{"4\n"},
+				},
+			},
+		},
+		{
+			name:    "an unterminated conflict",
+			content: "a\n<<<<<<< HEAD\nours\n=======\ntheirs\n",
+			want:    []mergeRegion{{Lines: []string{"a\n", "<<<<<<< HEAD\n", "ours\n", "=======\n", "theirs\n"}}},
+		},
+		{
+			name:    "lines that only look like markers",
+			content: "=======\n<<<<<<<< eight\n<<<<<<<x\n",
+			want:    []mergeRegion{{Lines: []string{"=======\n", "<<<<<<<< eight\n", "<<<<<<<x\n"}}},
+		},
+		{
+			name:    "crlf line endings",
+			content: "<<<<<<< HEAD\r\nours\r\n=======\r\ntheirs\r\n>>>>>>> feature\r\n",
+			want: []mergeRegion{{
+				Conflict: true,
+				Lines:    []string{"<<<<<<< HEAD\r\n", "ours\r\n", "=======\r\n", "theirs\r\n", ">>>>>>> feature\r\n"},
+				Ours:     []string{"ours\r\n"},
+				Theirs:   []string{"theirs\r\n"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseConflictMarkers(tt.content)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseConflictMarkers =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// the markers renderMerge writes parse back into the same sides
+func TestParseConflictMarkersOfRenderMerge(t *testing.T) {
+	merged := mergeLines("a\nb\nc\nd\ne\n", "a\nours\nc\nd\nE\n", "a\ntheirs\nc\nd\ne\n")
+	content, conflict := renderMerge(merged, "HEAD", "feature")
+	if !conflict {
+		t.Fatalf("expected a conflict in %q", content)
+	}
+
+	parsed := parseConflictMarkers(content)
+	if len(parsed) != len(merged) {
+		t.Fatalf("parsed %d regions, merged %d", len(parsed), len(merged))
+	}
+	for i := range merged {
+		if merged[i].Conflict != parsed[i].Conflict {
+			t.Fatalf("region %d: conflict is %v, want %v", i, parsed[i].Conflict, merged[i].Conflict)
+		}
+		if !merged[i].Conflict {
+			if !reflect.DeepEqual(parsed[i].Lines, merged[i].Lines) {
+				t.Errorf("region %d: lines %q, want %q", i, parsed[i].Lines, merged[i].Lines)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(parsed[i].Ours, merged[i].Ours) || !reflect.DeepEqual(parsed[i].Theirs, merged[i].Theirs) {
+			t.Errorf("region %d: sides %q and %q, want %q and %q", i, parsed[i].Ours, parsed[i].Theirs, merged[i].Ours, merged[i].Theirs)
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// number of unchanged lines shown around each change
+const diffContext = 3
+
+type DiffLine struct {
+	Kind  byte // ' ', '+' or '-'
+	Text  string
+	OldNo int // line number in the old side, 0 for additions
+	NewNo int // line number in the new side, 0 for deletions
+	// marked for line-level staging
+	Selected bool
+}
+
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []DiffLine
+}
+
+// header in unified diff format
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+type FileDiff struct {
+	Path   string
+	Status string // status of the file the diff was computed for
+	Binary bool
+	Hunks  []Hunk
+}
+
+// computes the diff shown for a file in the status view
+func getFileDiff(file FileStatus) (*FileDiff, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	var oldContent, newContent string
+	switch file.Status {
+	case "staged":
+		// index vs HEAD
+		if oldContent, err = readHeadFile(r, file.Path); err != nil {
+			return nil, err
+		}
+		if newContent, err = readIndexFile(r, file.Path); err != nil {
+			return nil, err
+		}
+	case "unstaged":
+		// worktree vs index
+		if oldContent, err = readIndexFile(r, file.Path); err != nil {
+			return nil, err
+		}
+		if newContent, err = readWorktreeFile(w, file.Path); err != nil {
+			return nil, err
+		}
+	case "untracked":
+		// the whole file is new
+		if newContent, err = readWorktreeFile(w, file.Path); err != nil {
+			return nil, err
+		}
+	case "conflicted":
+		// worktree, markers and all, vs our side of the merge
+		if oldContent, err = readStageFile(r, file.Path, index.OurMode); err != nil {
+			return nil, err
+		}
+		if newContent, err = readWorktreeFile(w, file.Path); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown file status: %s", file.Status)
+	}
+
+	d := &FileDiff{Path: file.Path, Status: file.Status}
+	if isBinary(oldContent) || isBinary(newContent) {
+		d.Binary = true
+		return d, nil
+	}
+
+	d.Hunks = computeHunks(oldContent, newContent, diffContext)
+	return d, nil
+}
+
+// reads a file from the HEAD tree, empty if there is no HEAD or no such file
+func readHeadFile(r *git.Repository, path string) (string, error) {
+	head, err := r.Head()
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	c, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return "", err
+	}
+
+	return readCommitFile(c, path)
+}
+
+// reads a file from a commit tree, empty if the file does not exist there
+func readCommitFile(c *object.Commit, path string) (string, error) {
+	f, err := c.File(path)
+	if err != nil {
+		if errors.Is(err, object.ErrFileNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return f.Contents()
+}
+
+// reads the staged content of a file, empty if it is not in the index
+func readIndexFile(r *git.Repository, path string) (string, error) {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return "", err
+	}
+
+	e, err := idx.Entry(path)
+	if err != nil {
+		if errors.Is(err, index.ErrEntryNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return readBlob(r, e.Hash)
+}
+
+// reads the content of a blob object
+func readBlob(r *git.Repository, hash plumbing.Hash) (string, error) {
+	blob, err := r.BlobObject(hash)
+	if err != nil {
+		return "", err
+	}
+
+	rd, err := blob.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer rd.Close()
+
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// reads a file from the worktree, empty if it was deleted
+func readWorktreeFile(w *git.Worktree, path string) (string, error) {
+	f, err := w.Filesystem.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// same heuristic as git: a NUL byte in the first 8000 bytes means binary
+func isBinary(content string) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return strings.IndexByte(content, 0) >= 0
+}
+
+// splits content into lines, keeping the trailing newline of each line
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// computes unified diff hunks between two versions of a file
+func computeHunks(oldContent, newContent string, context int) []Hunk {
+	// flatten the line diff into a single list of lines
+	var lines []DiffLine
+	oldNo, newNo := 1, 1
+	for _, d := range diff.Do(oldContent, newContent) {
+		for _, text := range splitLines(d.Text) {
+			switch d.Type {
+			case diffmatchpatch.DiffEqual:
+				lines = append(lines, DiffLine{Kind: ' ', Text: text, OldNo: oldNo, NewNo: newNo})
+				oldNo++
+				newNo++
+			case diffmatchpatch.DiffDelete:
+				lines = append(lines, DiffLine{Kind: '-', Text: text, OldNo: oldNo})
+				oldNo++
+			case diffmatchpatch.DiffInsert:
+				lines = append(lines, DiffLine{Kind: '+', Text: text, NewNo: newNo})
+				newNo++
+			}
+		}
+	}
+
+	// group changes that are within 2*context lines of each other
+	var hunks []Hunk
+	i := 0
+	for i < len(lines) {
+		if lines[i].Kind == ' ' {
+			i++
+			continue
+		}
+
+		start := max(i-context, 0)
+		end := i
+		for end < len(lines) {
+			if lines[end].Kind != ' ' {
+				end++
+				continue
+			}
+			// count the run of context lines following this change
+			run := 0
+			for end+run < len(lines) && lines[end+run].Kind == ' ' {
+				run++
+			}
+			if end+run == len(lines) || run > 2*context {
+				end += min(run, context)
+				break
+			}
+			end += run
+		}
+
+		hunks = append(hunks, newHunk(lines, start, end))
+		i = end
+	}
+
+	return hunks
+}
+
+// builds a hunk and its header ranges from lines[start:end]
+func newHunk(lines []DiffLine, start, end int) Hunk {
+	h := Hunk{Lines: append([]DiffLine(nil), lines[start:end]...)}
+
+	// lines of each side that come before the hunk
+	for _, l := range lines[:start] {
+		if l.Kind != '+' {
+			h.OldStart++
+		}
+		if l.Kind != '-' {
+			h.NewStart++
+		}
+	}
+
+	for _, l := range h.Lines {
+		if l.Kind != '+' {
+			h.OldLines++
+		}
+		if l.Kind != '-' {
+			h.NewLines++
+		}
+	}
+
+	// an empty side starts at the line before the hunk, like git does
+	if h.OldLines > 0 {
+		h.OldStart++
+	}
+	if h.NewLines > 0 {
+		h.NewStart++
+	}
+
+	return h
+}
+
+// rebuilds the new side of a diff from its old side, applying only the
+// added and removed lines for which include returns true
+func applyLines(oldContent string, hunks []Hunk, include func(DiffLine) bool) string {
+	oldLines := splitLines(oldContent)
+
+	var b strings.Builder
+	write := func(text string) {
+		// a kept last line without newline may no longer be the last one
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+		b.WriteString(text)
+	}
+
+	next := 1 // next old line to copy
+	for _, h := range hunks {
+		// copy the untouched old lines up to the hunk
+		start := h.OldStart
+		if h.OldLines == 0 {
+			start++
+		}
+		for ; next < start; next++ {
+			write(oldLines[next-1])
+		}
+
+		for _, l := range h.Lines {
+			switch {
+			case l.Kind == ' ':
+				write(l.Text)
+				next++
+			case l.Kind == '-':
+				// a removal that is left out keeps the old line
+				if !include(l) {
+					write(l.Text)
+				}
+				next++
+			case l.Kind == '+' && include(l):
+				write(l.Text)
+			}
+		}
+	}
+
+	for ; next <= len(oldLines); next++ {
+		write(oldLines[next-1])
+	}
+
+	return b.String()
+}
+
+// copy of the hunks with the lines an action applies to marked as selected:
+// the selected lines, or the line or hunk under the cursor if none are
+func (d *FileDiff) chosenHunks(hunkCursor, lineCursor int, lineMode bool) []Hunk {
+	hunks := make([]Hunk, len(d.Hunks))
+	picked := false
+	for i, h := range d.Hunks {
+		hunks[i] = h
+		hunks[i].Lines = append([]DiffLine(nil), h.Lines...)
+		picked = picked || h.selectedCount() > 0
+	}
+	if picked || hunkCursor < 0 || hunkCursor >= len(hunks) {
+		return hunks
+	}
+
+	h := &hunks[hunkCursor]
+	if lineMode {
+		if lineCursor >= 0 && lineCursor < len(h.Lines) && h.Lines[lineCursor].Kind != ' ' {
+			h.Lines[lineCursor].Selected = true
+		}
+	} else {
+		h.setSelected(true)
+	}
+	return hunks
+}
+
+// number of changed lines in the hunk
+func (h Hunk) changeCount() int {
+	return len(h.Lines) - countKind(h.Lines, ' ')
+}
+
+// number of selected changed lines in the hunk
+func (h Hunk) selectedCount() int {
+	n := 0
+	for _, l := range h.Lines {
+		if l.Kind != ' ' && l.Selected {
+			n++
+		}
+	}
+	return n
+}
+
+// selects or deselects every changed line of the hunk
+func (h *Hunk) setSelected(selected bool) {
+	for i := range h.Lines {
+		if h.Lines[i].Kind != ' ' {
+			h.Lines[i].Selected = selected
+		}
+	}
+}
+
+// counts lines of the given kind
+func countKind(lines []DiffLine, kind byte) int {
+	n := 0
+	for _, l := range lines {
+		if l.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// true if every changed line of the hunks is selected
+func allSelected(hunks []Hunk) bool {
+	for _, h := range hunks {
+		if h.selectedCount() != h.changeCount() {
+			return false
+		}
+	}
+	return true
+}
+
+// true if no changed line of the hunks is selected
+func noneSelected(hunks []Hunk) bool {
+	for _, h := range hunks {
+		if h.selectedCount() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// row of a hunk's header in the rendered diff
+func (d *FileDiff) hunkRow(hunk int) int {
+	row := 0
+	for i := 0; i < hunk && i < len(d.Hunks); i++ {
+		row += 1 + len(d.Hunks[i].Lines)
+	}
+	return row
+}
+
+// renders a diff as plain unified diff lines, without the file header
+func (d *FileDiff) Lines() []string {
+	if d == nil {
+		return nil
+	}
+	if d.Binary {
+		return []string{"binary file differs"}
+	}
+
+	var out []string
+	for _, h := range d.Hunks {
+		out = append(out, h.Header())
+		for _, l := range h.Lines {
+			out = append(out, string(l.Kind)+strings.TrimRight(l.Text, "\n"))
+		}
+	}
+	return out
+}
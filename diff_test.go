@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// numbered lines from first to last, with the given lines replaced
+func numberedLines(first, last int, replace map[int]string) string {
+	var b strings.Builder
+	for i := first; i <= last; i++ {
+		if text, ok := replace[i]; ok {
+			b.WriteString(text)
+			continue
+		}
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func TestApplyLinesHunks(t *testing.T) {
+	old := numberedLines(1, 20, nil)
+	// far enough apart to be separate hunks
+	changed := numberedLines(1, 20, map[int]string{2: "two\n", 18: "eighteen\n"})
+
+	tests := []struct {
+		name     string
+		old, new string
+		hunks    []int // the hunks selected
+		unselect bool  // apply the hunks that are not selected, as unstaging does
+		want     string
+	}{
+		{"no hunks", old, changed, nil, false, old},
+		{"every hunk", old, changed, []int{0, 1}, false, changed},
+		{"first hunk", old, changed, []int{0}, false, numberedLines(1, 20, map[int]string{2: "two\n"})},
+		{"last hunk", old, changed, []int{1}, false, numberedLines(1, 20, map[int]string{18: "eighteen\n"})},
+		{"all but the first hunk", old, changed, []int{0}, true, numberedLines(1, 20, map[int]string{18: "eighteen\n"})},
+		{"new file", "", "a\nb\n", []int{0}, false, "a\nb\n"},
+		{"deleted file", "a\nb\n", "", []int{0}, false, ""},
+		{"appended lines", old, old + "line 21\n", []int{0}, false, old + "line 21\n"},
+		{"prepended lines", old, "line 0\n" + old, []int{0}, false, "line 0\n" + old},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hunks := computeHunks(tt.old, tt.new, diffContext)
+			for _, i := range tt.hunks {
+				for j := range hunks[i].Lines {
+					hunks[i].Lines[j].Selected = true
+				}
+			}
+
+			got := applyLines(tt.old, hunks, func(l DiffLine) bool {
+				return l.Selected != tt.unselect
+			})
+			if got != tt.want {
+				t.Errorf("applyLines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeHunksSplitsDistantChanges(t *testing.T) {
+	old := numberedLines(1, 20, nil)
+	near := numberedLines(1, 20, map[int]string{2: "two\n", 8: "eight\n"})
+	far := numberedLines(1, 20, map[int]string{2: "two\n", 18: "eighteen\n"})
+
+	if got := len(computeHunks(old, near, diffContext)); got != 1 {
+		t.Errorf("changes with overlapping context made %d hunks, want 1", got)
+	}
+	if got := len(computeHunks(old, far, diffContext)); got != 2 {
+		t.Errorf("distant changes made %d hunks, want 2", got)
+	}
+	if !slices.Equal(hunkStarts(computeHunks(old, far, diffContext)), []int{1, 15}) {
+		t.Errorf("hunks start at old lines %v, want [1 15]", hunkStarts(computeHunks(old, far, diffContext)))
+	}
+}
+
+func hunkStarts(hunks []Hunk) []int {
+	var starts []int
+	for _, h := range hunks {
+		starts = append(starts, h.OldStart)
+	}
+	return starts
+}
+
+func TestApplyLinesSingleLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		lines    []string // the added ("+text") and removed ("-text") lines selected
+		unselect bool     // apply the lines that are not selected, as unstaging does
+		want     string
+	}{
+		{
+			name:  "addition of a replaced line",
+			old:   "a\nb\nc\n",
+			new:   "a\nB\nc\n",
+			lines: []string{"+B\n"},
+			want:  "a\nb\nB\nc\n",
+		},
+		{
+			name:  "removal of a replaced line",
+			old:   "a\nb\nc\n",
+			new:   "a\nB\nc\n",
+			lines: []string{"-b\n"},
+			want:  "a\nc\n",
+		},
+		{
+			name:  "one of two added lines",
+			old:   "a\nc\n",
+			new:   "a\ndebug\nb\nc\n",
+			lines: []string{"+b\n"},
+			want:  "a\nb\nc\n",
+		},
+		{
+			name:     "all but one added line",
+			old:      "a\nc\n",
+			new:      "a\ndebug\nb\nc\n",
+			lines:    []string{"+debug\n"},
+			unselect: true,
+			want:     "a\nb\nc\n",
+		},
+		{
+			name:  "one of two removed lines",
+			old:   "a\nb\nc\nd\n",
+			new:   "a\nd\n",
+			lines: []string{"-c\n"},
+			want:  "a\nb\nd\n",
+		},
+		{
+			name:  "addition after a last line without newline",
+			old:   "a\nb",
+			new:   "a\nb\nc\n",
+			lines: []string{"+c\n"},
+			want:  "a\nb\nc\n",
+		},
+		{
+			name:  "newline added to the last line",
+			old:   "a\nb",
+			new:   "a\nb\nc\n",
+			lines: []string{"-b", "+b\n"},
+			want:  "a\nb\n",
+		},
+		{
+			name: "nothing selected",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			want: "a\nb\nc\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hunks := computeHunks(tt.old, tt.new, diffContext)
+			for i := range hunks {
+				for j, l := range hunks[i].Lines {
+					hunks[i].Lines[j].Selected = slices.Contains(tt.lines, string(l.Kind)+l.Text)
+				}
+			}
+
+			got := applyLines(tt.old, hunks, func(l DiffLine) bool {
+				return l.Selected != tt.unselect
+			})
+			if got != tt.want {
+				t.Errorf("applyLines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
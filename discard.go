@@ -0,0 +1,524 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"gopkg.in/yaml.v3"
+)
+
+// discarded content is saved under .git/got/trash, one directory per
+// discard named after its time. restoreDiscard takes back the latest one,
+// and discards older than trashExpiry are pruned when a new one is saved
+const (
+	trashIDLayout = "2006-01-02T15-04-05.000000000"
+	trashExpiry   = 30 * 24 * time.Hour
+)
+
+// a discard, recorded in discard.yaml in its trash directory; worktree/
+// there holds the discarded worktree version of each path and index/ the
+// discarded staged version
+type Discard struct {
+	Time      time.Time `yaml:"time"`
+	Unstaged  []string  `yaml:"unstaged,omitempty"`  // restored to their staged version
+	Staged    []string  `yaml:"staged,omitempty"`    // restored to HEAD in the index and worktree
+	Untracked []string  `yaml:"untracked,omitempty"` // deleted
+	Hunks     []string  `yaml:"hunks,omitempty"`     // had some unstaged lines discarded
+
+	// blob hash of what the worktree kept of each hunks path, to tell
+	// whether it changed again before a restore
+	Kept map[string]string `yaml:"kept,omitempty"`
+}
+
+// every path the discard changes
+func (d *Discard) Paths() []string {
+	var paths []string
+	for _, group := range [][]string{d.Unstaged, d.Staged, d.Untracked, d.Hunks} {
+		paths = append(paths, group...)
+	}
+	return paths
+}
+
+// a discard's directory in the trash, filled before anything is discarded
+type trash struct {
+	dir     string
+	discard *Discard
+}
+
+// creates the trash directory for a discard
+func newTrash(r *git.Repository, d *Discard) (*trash, error) {
+	gd, err := gitDir(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Time = time.Now()
+	parent := filepath.Join(gd, "got", "trash")
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+	if err := pruneTrash(parent, d.Time.Add(-trashExpiry)); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(parent, d.Time.Format(trashIDLayout))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &trash{dir: dir, discard: d}, nil
+}
+
+// removes the discards saved before a time
+func pruneTrash(parent string, before time.Time) error {
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		saved, err := time.ParseInLocation(trashIDLayout, e.Name(), time.Local)
+		if err != nil || !saved.Before(before) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(parent, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// opens the latest discard in the trash, or nil if the trash is empty
+func lastTrash(r *git.Repository) (*trash, error) {
+	gd, err := gitDir(r)
+	if err != nil {
+		return nil, err
+	}
+
+	parent := filepath.Join(gd, "got", "trash")
+	entries, err := os.ReadDir(parent)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the names sort by time, latest last
+	for i := len(entries) - 1; i >= 0; i-- {
+		dir := filepath.Join(parent, entries[i].Name())
+		data, err := os.ReadFile(filepath.Join(dir, "discard.yaml"))
+		if os.IsNotExist(err) {
+			// a discard that failed before it was recorded
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var d Discard
+		if err := yaml.Unmarshal(data, &d); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filepath.Join(dir, "discard.yaml"), err)
+		}
+		return &trash{dir: dir, discard: &d}, nil
+	}
+	return nil, nil
+}
+
+// saves the worktree version of path, if it exists
+func (t *trash) saveWorktree(w *git.Worktree, path string) error {
+	content, mode, ok, err := readWorktreeContent(w, path)
+	if err != nil || !ok {
+		return err
+	}
+	return t.write("worktree", path, content, mode)
+}
+
+// saves the staged version of path, if it has one
+func (t *trash) saveIndex(r *git.Repository, idx *index.Index, path string) error {
+	e, err := idx.Entry(path)
+	if errors.Is(err, index.ErrEntryNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	content, err := readBlob(r, e.Hash)
+	if err != nil {
+		return err
+	}
+	return t.write("index", path, content, e.Mode)
+}
+
+// writes a saved version with its mode, a symlink as a symlink
+func (t *trash) write(side, path, content string, mode filemode.FileMode) error {
+	dest := filepath.Join(t.dir, side, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	if mode == filemode.Symlink {
+		return os.Symlink(content, dest)
+	}
+	perm := os.FileMode(0644)
+	if mode == filemode.Executable {
+		perm = 0755
+	}
+	return os.WriteFile(dest, []byte(content), perm)
+}
+
+// reads a saved version back with its mode; ok is false if the side has
+// no version of path
+func (t *trash) read(side, path string) (string, filemode.FileMode, bool, error) {
+	src := filepath.Join(t.dir, side, filepath.FromSlash(path))
+	info, err := os.Lstat(src)
+	if os.IsNotExist(err) {
+		return "", 0, false, nil
+	}
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		return target, filemode.Symlink, err == nil, err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return "", 0, false, err
+	}
+	mode := filemode.Regular
+	if info.Mode()&0111 != 0 {
+		mode = filemode.Executable
+	}
+	return string(data), mode, true, nil
+}
+
+// records the discard next to the saved files; returns the directory
+// relative to the worktree root for showing it
+func (t *trash) close(w *git.Worktree) (string, error) {
+	data, err := yaml.Marshal(t.discard)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(t.dir, "discard.yaml"), data, 0644); err != nil {
+		return "", err
+	}
+
+	root, err := filepath.Abs(w.Filesystem.Root())
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.Abs(t.dir)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, dir); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel, nil
+	}
+	return dir, nil
+}
+
+// discards changes to files, saving what is lost in the trash first:
+// unstaged paths go back to their staged version, staged paths back to
+// HEAD in both the index and the worktree, and untracked paths are
+// deleted. returns the trash directory
+func discardFiles(unstaged, staged, untracked []string) (string, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return "", err
+	}
+
+	d := &Discard{Unstaged: unstaged, Staged: staged, Untracked: untracked}
+	unmerged, err := unmergedPaths(r)
+	if err != nil {
+		return "", err
+	}
+	for _, path := range d.Paths() {
+		if slices.Contains(unmerged, path) {
+			return "", fmt.Errorf("resolve the conflicts of %s first", path)
+		}
+	}
+
+	head, err := headCommitOrNil(r)
+	if err != nil {
+		return "", err
+	}
+	headFiles, err := commitFiles(head)
+	if err != nil {
+		return "", err
+	}
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return "", err
+	}
+
+	t, err := newTrash(r, d)
+	if err != nil {
+		return "", err
+	}
+	for _, path := range d.Paths() {
+		if err := t.saveWorktree(w, path); err != nil {
+			return "", err
+		}
+	}
+	for _, path := range staged {
+		if err := t.saveIndex(r, idx, path); err != nil {
+			return "", err
+		}
+	}
+	dir, err := t.close(w)
+	if err != nil {
+		return "", err
+	}
+
+	// the index is written before the worktree changes, so a failure part
+	// way leaves the files looking modified rather than already discarded
+	restored := map[string]treeFile{}
+	for _, path := range unstaged {
+		e, err := idx.Entry(path)
+		if err != nil {
+			return "", err
+		}
+		restored[path] = treeFile{Hash: e.Hash, Mode: e.Mode}
+	}
+	for _, path := range staged {
+		removeIndexPath(idx, path)
+		if f, ok := headFiles[path]; ok {
+			e := idx.Add(path)
+			e.Hash = f.Hash
+			e.Mode = f.Mode
+		}
+	}
+	if err := r.Storer.SetIndex(idx); err != nil {
+		return "", err
+	}
+
+	for _, path := range unstaged {
+		if err := restoreWorktreeFile(r, w, path, restored[path]); err != nil {
+			return "", err
+		}
+	}
+	for _, path := range staged {
+		f, ok := headFiles[path]
+		if !ok {
+			if err := removeWorktreeFile(w, path); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if err := restoreWorktreeFile(r, w, path, f); err != nil {
+			return "", err
+		}
+	}
+	for _, path := range untracked {
+		if err := removeWorktreeFile(w, path); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// discards the selected lines of a file's unstaged or untracked changes
+// from the worktree, saving the file in the trash first; returns the trash
+// directory, "" if no lines were selected
+func discardHunks(path string, untracked bool, hunks []Hunk) (string, error) {
+	if noneSelected(hunks) {
+		return "", nil
+	}
+	if allSelected(hunks) {
+		if untracked {
+			return discardFiles(nil, nil, []string{path})
+		}
+		return discardFiles([]string{path}, nil, nil)
+	}
+
+	r, w, err := openRepo()
+	if err != nil {
+		return "", err
+	}
+
+	indexContent, err := readIndexFile(r, path)
+	if err != nil {
+		return "", err
+	}
+	_, mode, ok, err := readWorktreeContent(w, path)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%s no longer exists", path)
+	}
+
+	// the worktree keeps every change that was not selected
+	content := applyLines(indexContent, hunks, func(l DiffLine) bool {
+		return !l.Selected
+	})
+
+	kept := plumbing.ComputeHash(plumbing.BlobObject, []byte(content))
+	t, err := newTrash(r, &Discard{Hunks: []string{path}, Kept: map[string]string{path: kept.String()}})
+	if err != nil {
+		return "", err
+	}
+	if err := t.saveWorktree(w, path); err != nil {
+		return "", err
+	}
+	dir, err := t.close(w)
+	if err != nil {
+		return "", err
+	}
+
+	return dir, writeWorktreeFile(w, path, content, mode)
+}
+
+// the latest discard restoreDiscard would take back, or nil if there is
+// none
+func getLastDiscard() (*Discard, error) {
+	r, _, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+	t, err := lastTrash(r)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	return t.discard, nil
+}
+
+// puts back what the latest discard threw away and removes it from the
+// trash; refuses if any of its files changed since
+func restoreDiscard() (*Discard, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := lastTrash(r)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, fmt.Errorf("nothing discarded to restore")
+	}
+	d := t.discard
+	if err := requireUnchangedSinceDiscard(w, d); err != nil {
+		return nil, err
+	}
+
+	// the index first, as discardFiles does
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range d.Staged {
+		removeIndexPath(idx, path)
+		content, mode, ok, err := t.read("index", path)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		hash, err := writeBlob(r, content)
+		if err != nil {
+			return nil, err
+		}
+		e := idx.Add(path)
+		e.Hash = hash
+		e.Mode = mode
+	}
+	if err := r.Storer.SetIndex(idx); err != nil {
+		return nil, err
+	}
+
+	for _, path := range d.Paths() {
+		content, mode, ok, err := t.read("worktree", path)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if err := removeWorktreeFile(w, path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// writing over the old file would keep its mode
+		if err := w.Filesystem.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err := writeWorktreeFile(w, path, content, mode); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, os.RemoveAll(t.dir)
+}
+
+// checks the files of a discard are still as the discard left them, so
+// restoring it loses nothing
+func requireUnchangedSinceDiscard(w *git.Worktree, d *Discard) error {
+	status, err := w.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range d.Unstaged {
+		if s, ok := status[path]; ok && s.Worktree != git.Unmodified {
+			return fmt.Errorf("%s changed since it was discarded", path)
+		}
+	}
+	for _, path := range d.Staged {
+		if s, ok := status[path]; ok && (s.Staging != git.Unmodified || s.Worktree != git.Unmodified) {
+			return fmt.Errorf("%s changed since it was discarded", path)
+		}
+	}
+	for _, path := range d.Untracked {
+		if _, err := w.Filesystem.Lstat(path); err == nil {
+			return fmt.Errorf("%s was created again since it was discarded", path)
+		}
+	}
+	for _, path := range d.Hunks {
+		content, _, ok, err := readWorktreeContent(w, path)
+		if err != nil {
+			return err
+		}
+		if !ok || plumbing.ComputeHash(plumbing.BlobObject, []byte(content)).String() != d.Kept[path] {
+			return fmt.Errorf("%s changed since its lines were discarded", path)
+		}
+	}
+	return nil
+}
+
+// replaces a worktree file with a blob, mode included
+func restoreWorktreeFile(r *git.Repository, w *git.Worktree, path string, f treeFile) error {
+	content, err := readBlob(r, f.Hash)
+	if err != nil {
+		return err
+	}
+	// writing over the old file would keep its mode
+	if err := w.Filesystem.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return writeWorktreeFile(w, path, content, f.Mode)
+}
+
+// deletes a worktree file and the directories it leaves empty
+func removeWorktreeFile(w *git.Worktree, path string) error {
+	if err := w.Filesystem.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// stopping at the first directory still in use
+	for dir := filepath.Dir(path); dir != "."; dir = filepath.Dir(dir) {
+		if w.Filesystem.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
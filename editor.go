@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// how staged changes are described in the commit message comments
+var stagedActions = map[git.StatusCode]string{
+	git.Added:    "new file",
+	git.Modified: "modified",
+	git.Deleted:  "deleted",
+	git.Renamed:  "renamed",
+	git.Copied:   "copied",
+}
+
+// the editor git would use: $GIT_EDITOR, core.editor, $VISUAL, $EDITOR,
+// then vi
+func gitEditor() string {
+	if editor := os.Getenv("GIT_EDITOR"); editor != "" {
+		return editor
+	}
+	if r, err := git.PlainOpen("."); err == nil {
+		if editor, err := gitConfigValue(r, "core", "", "editor"); err == nil && editor != "" {
+			return editor
+		}
+	}
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := os.Getenv(name); editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
+
+// writes COMMIT_EDITMSG for editing a message: the message so far, the
+// commit.template, and the branch and the files to be committed, the staged
+// ones or only, as comments; returns the file's path
+func writeCommitEditMessage(message string, only []string) (string, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(message, "\n") + "\n")
+
+	template, err := commitTemplate(r, w)
+	if err != nil {
+		return "", err
+	}
+	if template != "" {
+		b.WriteString("\n" + template + "\n")
+	}
+
+	b.WriteString("\n# Please enter the commit message for your changes. Lines starting\n")
+	b.WriteString("# with '#' will be ignored, and an empty message aborts the commit.\n#\n")
+
+	// HEAD is read unresolved so an unborn branch is named too
+	if head, err := r.Storer.Reference(plumbing.HEAD); err == nil && head.Target().IsBranch() {
+		b.WriteString("# On branch " + head.Target().Short() + "\n")
+	}
+
+	actions, err := committedActions(r, w, only)
+	if err != nil {
+		return "", err
+	}
+	var paths []string
+	for path := range actions {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if len(paths) > 0 {
+		b.WriteString("# Changes to be committed:\n")
+		for _, path := range paths {
+			fmt.Fprintf(&b, "#\t%-12s%s\n", actions[path]+":", path)
+		}
+	} else {
+		b.WriteString("# No changes staged.\n")
+	}
+	b.WriteString("#\n")
+
+	dir, err := gitDir(r)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.Abs(filepath.Join(dir, "COMMIT_EDITMSG"))
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// what a commit will do to each path it changes: the staged changes, or
+// with only the worktree version of just those paths against HEAD
+func committedActions(r *git.Repository, w *git.Worktree, only []string) (map[string]string, error) {
+	actions := map[string]string{}
+	if only == nil {
+		status, err := w.Status()
+		if err != nil {
+			return nil, err
+		}
+		for path, s := range status {
+			if action, ok := stagedActions[s.Staging]; ok {
+				actions[path] = action
+			}
+		}
+		return actions, nil
+	}
+
+	head, err := headCommitOrNil(r)
+	if err != nil {
+		return nil, err
+	}
+	headFiles, err := commitFiles(head)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range only {
+		f, err := readWorktreeBlob(r, w, path)
+		if err != nil {
+			return nil, err
+		}
+		old := fileAt(headFiles, path)
+		switch {
+		case sameFile(old, f):
+		case old == nil:
+			actions[path] = stagedActions[git.Added]
+		case f == nil:
+			actions[path] = stagedActions[git.Deleted]
+		default:
+			actions[path] = stagedActions[git.Modified]
+		}
+	}
+	return actions, nil
+}
+
+// the content of the file commit.template names, "" if none is set
+func commitTemplate(r *git.Repository, w *git.Worktree) (string, error) {
+	path, err := gitConfigValue(r, "commit", "", "template")
+	if err != nil || path == "" {
+		return "", err
+	}
+
+	path = expandHome(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(w.Filesystem.Root(), path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read commit.template: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// the edited message with comments stripped, "" if it was emptied
+func readCommitEditMessage(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return stripComments(string(data)), nil
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	git "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gopkg.in/yaml.v3"
 )
 
 // check if current dir is a git repo
@@ -92,33 +103,34 @@ func getGitStatus() ([]FileStatus, error) {
 		return nil, err
 	}
 
-	// create a map to track all files and their statuses
-	fileMap := make(map[string]FileStatus)
+	conflicts, err := getConflicts()
+	if err != nil {
+		return nil, err
+	}
 
-	for path, fileStatus := range status {
-		var fileStatusStr string
+	var files []FileStatus
+	conflicted := map[string]bool{}
+	for _, c := range conflicts {
+		conflicted[c.Path] = true
+		files = append(files, FileStatus{Path: c.Path, Status: "conflicted"})
+	}
 
-		// determine the overall status for the file
-		if fileStatus.Staging == git.Added || fileStatus.Staging == git.Modified || fileStatus.Staging == git.Deleted {
-			fileStatusStr = "staged"
-		} else if fileStatus.Worktree == git.Modified || fileStatus.Worktree == git.Deleted {
-			fileStatusStr = "unstaged"
-		} else if fileStatus.Worktree == git.Untracked {
-			fileStatusStr = "untracked"
-		} else {
+	// a file with both staged and unstaged changes is listed once for each,
+	// so the remaining worktree changes stay reachable after partial staging
+	for path, fileStatus := range status {
+		if conflicted[path] {
 			continue
 		}
 
-		fileMap[path] = FileStatus{
-			Path:     path,
-			Status:   fileStatusStr,
-			Selected: false,
+		if fileStatus.Staging == git.Added || fileStatus.Staging == git.Modified || fileStatus.Staging == git.Deleted {
+			files = append(files, FileStatus{Path: path, Status: "staged"})
 		}
-	}
 
-	var files []FileStatus
-	for _, file := range fileMap {
-		files = append(files, file)
+		if fileStatus.Worktree == git.Modified || fileStatus.Worktree == git.Deleted {
+			files = append(files, FileStatus{Path: path, Status: "unstaged"})
+		} else if fileStatus.Worktree == git.Untracked {
+			files = append(files, FileStatus{Path: path, Status: "untracked"})
+		}
 	}
 
 	sortFiles(files)
@@ -128,7 +140,11 @@ func getGitStatus() ([]FileStatus, error) {
 
 func sortFiles(files []FileStatus) {
 	sort.Slice(files, func(i, j int) bool {
-		return files[i].Path < files[j].Path
+		if files[i].Path != files[j].Path {
+			return files[i].Path < files[j].Path
+		}
+		// staged entry first when a file is listed twice
+		return files[i].Status == "staged"
 	})
 }
 
@@ -147,10 +163,25 @@ func openRepo() (*git.Repository, *git.Worktree, error) {
 }
 
 func stageFile(path string) error {
-	_, w, err := openRepo()
+	r, w, err := openRepo()
+	if err != nil {
+		return err
+	}
+
+	// staging a conflicted file marks it resolved, so drop its conflict stages
+	idx, err := r.Storer.Index()
 	if err != nil {
 		return err
 	}
+	for _, e := range idx.Entries {
+		if e.Name == path && e.Stage != 0 {
+			removeIndexPath(idx, path)
+			if err := r.Storer.SetIndex(idx); err != nil {
+				return err
+			}
+			break
+		}
+	}
 
 	_, err = w.Add(path)
 	return err
@@ -189,92 +220,1033 @@ func unstageFile(path string) error {
 	return err
 }
 
-// creates a commit with the given message
+// stages only the selected lines of a file's unstaged or untracked changes
+func stageHunks(path string, hunks []Hunk) error {
+	if noneSelected(hunks) {
+		return nil
+	}
+	if allSelected(hunks) {
+		return stageFile(path)
+	}
+
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	indexContent, err := readIndexFile(r, path)
+	if err != nil {
+		return err
+	}
+
+	content := applyLines(indexContent, hunks, func(l DiffLine) bool {
+		return l.Selected
+	})
+	return writeIndexBlob(r, path, content)
+}
+
+// unstages only the selected lines of a file's staged changes
+func unstageHunks(path string, hunks []Hunk) error {
+	if noneSelected(hunks) {
+		return nil
+	}
+	if allSelected(hunks) {
+		return unstageFile(path)
+	}
+
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	headContent, err := readHeadFile(r, path)
+	if err != nil {
+		return err
+	}
+
+	// the index keeps every staged line that was not selected
+	content := applyLines(headContent, hunks, func(l DiffLine) bool {
+		return !l.Selected
+	})
+	return writeIndexBlob(r, path, content)
+}
+
+// stores content as a blob and points the index entry for path at it
+func writeIndexBlob(r *git.Repository, path, content string) error {
+	hash, err := writeBlob(r, content)
+	if err != nil {
+		return err
+	}
+
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	e, err := idx.Entry(path)
+	if errors.Is(err, index.ErrEntryNotFound) {
+		e = idx.Add(path)
+		e.Mode = filemode.Regular
+		if info, err := os.Stat(path); err == nil && info.Mode()&0111 != 0 {
+			e.Mode = filemode.Executable
+		}
+	} else if err != nil {
+		return err
+	}
+
+	e.Hash = hash
+	e.Size = uint32(len(content))
+	// the blob no longer matches the worktree file, so drop the stat cache
+	e.CreatedAt = time.Time{}
+	e.ModifiedAt = time.Time{}
+
+	return r.Storer.SetIndex(idx)
+}
+
+// creates a commit with the given message, concluding a merge, cherry-pick
+// or revert in progress
 func commit(message string) error {
-	_, w, err := openRepo()
+	r, w, err := openRepo()
 	if err != nil {
 		return err
 	}
 
-	_, err = w.Commit(message, &git.CommitOptions{})
-	return err
+	if state, err := readPickState(r); err != nil {
+		return err
+	} else if state != nil {
+		return concludePick(message)
+	}
+
+	author, err := authorSignature(r)
+	if err != nil {
+		return err
+	}
+	committer, err := committerSignature(r)
+	if err != nil {
+		return err
+	}
+	signer, err := commitSigner(r)
+	if err != nil {
+		return err
+	}
+	opts := &git.CommitOptions{Author: author, Committer: committer, Signer: signer}
+
+	mergeHead, _, err := readMergeState(r)
+	if err != nil {
+		return err
+	}
+	if mergeHead.IsZero() {
+		_, err = w.Commit(message, opts)
+		return err
+	}
+
+	unmerged, err := unmergedPaths(r)
+	if err != nil {
+		return err
+	}
+	if len(unmerged) > 0 {
+		return fmt.Errorf("resolve and stage the conflicted files first: %s", strings.Join(unmerged, ", "))
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+
+	opts.Parents = []plumbing.Hash{head.Hash(), mergeHead}
+	_, err = w.Commit(message, opts)
+	if err != nil {
+		return err
+	}
+	return clearMergeState(r)
 }
 
-// returns the name of the current branch
-func getCurrentBranch() (string, error) {
+// the message of the commit HEAD points at, for amending it
+func getHeadMessage() (string, error) {
 	r, err := git.PlainOpen(".")
 	if err != nil {
 		return "", err
 	}
 
 	head, err := r.Head()
+	if err != nil {
+		return "", fmt.Errorf("there is no commit to amend yet")
+	}
+	c, err := r.CommitObject(head.Hash())
 	if err != nil {
 		return "", err
 	}
+	return c.Message, nil
+}
 
-	if head.Name().IsBranch() {
-		return head.Name().Short(), nil
+// replaces the commit HEAD points at with one holding the staged changes
+// and the new message, keeping its parents and author
+func amendCommit(message string) error {
+	r, w, err := openRepo()
+	if err != nil {
+		return err
 	}
 
-	return "HEAD", nil
+	if err := requireNoOperation(r); err != nil {
+		return err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return fmt.Errorf("there is no commit to amend yet")
+	}
+	c, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	committer, err := committerSignature(r)
+	if err != nil {
+		return err
+	}
+	signer, err := commitSigner(r)
+	if err != nil {
+		return err
+	}
+
+	opts := &git.CommitOptions{
+		Author:            &c.Author,
+		Committer:         committer,
+		AllowEmptyCommits: true,
+		Signer:            signer,
+	}
+	// go-git's Amend keeps only the first parent, and no parents would mean
+	// HEAD, so only a merge is amended by naming its parents
+	if c.NumParents() > 1 {
+		opts.Parents = c.ParentHashes
+	} else {
+		opts.Amend = true
+	}
+	_, err = w.Commit(message, opts)
+	return err
 }
 
-// returns a list of all local branches
-func listBranches() ([]string, error) {
-	r, err := git.PlainOpen(".")
+// the commit HEAD points at, nil on a branch with no commits yet
+func headCommitOrNil(r *git.Repository) (*object.Commit, error) {
+	head, err := r.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
+	return r.CommitObject(head.Hash())
+}
 
-	branches, err := r.Branches()
+// runs fn, a commit, against an index holding HEAD plus the worktree
+// version of paths, as git commit -- paths does. the real index is put
+// back afterwards with paths updated to what was committed, so other staged
+// changes stay staged. nil paths runs fn against the index as it is
+func withOnlyIndex(paths []string, fn func() error) error {
+	if paths == nil {
+		return fn()
+	}
+
+	r, w, err := openRepo()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var branchNames []string
-	err = branches.ForEach(func(ref *plumbing.Reference) error {
-		if !strings.Contains(ref.Name().String(), "refs/remotes/") {
-			branchNames = append(branchNames, ref.Name().Short())
+	if err := requireNoOperation(r); err != nil {
+		return err
+	}
+	if unmerged, err := unmergedPaths(r); err != nil {
+		return err
+	} else if len(unmerged) > 0 {
+		return fmt.Errorf("resolve the conflicts first: %s", strings.Join(unmerged, ", "))
+	}
+
+	head, err := headCommitOrNil(r)
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(head)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		f, err := readWorktreeBlob(r, w, path)
+		if err != nil {
+			return err
 		}
-		return nil
-	})
+		delete(files, path)
+		if f != nil {
+			files[path] = *f
+		}
+	}
 
-	return branchNames, err
+	original, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+	only := &index.Index{Version: original.Version}
+	for path, f := range files {
+		// entries the index already has keep their stat cache
+		if e, err := original.Entry(path); err == nil && e.Hash == f.Hash && e.Mode == f.Mode {
+			kept := *e
+			only.Entries = append(only.Entries, &kept)
+			continue
+		}
+		e := only.Add(path)
+		e.Hash = f.Hash
+		e.Mode = f.Mode
+	}
+
+	// the real index is set aside on disk first, so it is not lost if got
+	// stops before putting it back
+	set := &OnlyIndex{Paths: paths}
+	if head != nil {
+		set.Head = head.Hash.String()
+	}
+	if err := saveOnlyIndex(r, set, original); err != nil {
+		return err
+	}
+
+	err = r.Storer.SetIndex(only)
+	if err == nil {
+		err = fn()
+	}
+	if restoreErr := restoreOnlyIndex(r); restoreErr != nil {
+		if err != nil {
+			return fmt.Errorf("%w, and restoring the index failed: %v", err, restoreErr)
+		}
+		return restoreErr
+	}
+	return err
 }
 
-// creates a new branch from the current HEAD
-func createBranch(branchName string) error {
-	r, err := git.PlainOpen(".")
+// the index withOnlyIndex set aside, with what is needed to put it back:
+// kept in .git/got/only-index.yaml, the index itself in .git/got/index
+type OnlyIndex struct {
+	Head  string   `yaml:"head"` // HEAD before the commit, empty without commits
+	Paths []string `yaml:"paths"`
+}
+
+// paths of the set aside index and of its description
+func onlyIndexPaths(r *git.Repository) (string, string, error) {
+	dir, err := gitDir(r)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(dir, "got", "index"), filepath.Join(dir, "got", "only-index.yaml"), nil
+}
+
+func saveOnlyIndex(r *git.Repository, set *OnlyIndex, idx *index.Index) error {
+	indexPath, statePath, err := onlyIndexPaths(r)
 	if err != nil {
 		return err
 	}
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0755); err != nil {
+		return err
+	}
 
-	head, err := r.Head()
+	var buf bytes.Buffer
+	if err := index.NewEncoder(&buf).Encode(idx); err != nil {
+		return err
+	}
+	if err := os.WriteFile(indexPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	// the description is written last, it is what marks the index as set
+	// aside
+	data, err := yaml.Marshal(set)
 	if err != nil {
 		return err
 	}
+	return os.WriteFile(statePath, data, 0644)
+}
 
-	// create the new branch
-	refName := plumbing.NewBranchReferenceName(branchName)
-	ref := plumbing.NewHashReference(refName, head.Hash())
+// puts back the index withOnlyIndex set aside, if there is one. when HEAD
+// has moved the commit went through, and the set aside paths take what the
+// index holds now, including anything a hook staged
+func restoreOnlyIndex(r *git.Repository) error {
+	indexPath, statePath, err := onlyIndexPaths(r)
+	if err != nil {
+		return err
+	}
 
-	return r.Storer.SetReference(ref)
+	data, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var set OnlyIndex
+	if err := yaml.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("failed to parse the set aside index: %w", err)
+	}
+
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	original := &index.Index{}
+	if err := index.NewDecoder(f).Decode(original); err != nil {
+		return fmt.Errorf("failed to read the set aside index: %w", err)
+	}
+
+	head, err := headCommitOrNil(r)
+	if err != nil {
+		return err
+	}
+	if head != nil && head.Hash.String() != set.Head {
+		committed, err := r.Storer.Index()
+		if err != nil {
+			return err
+		}
+		for _, path := range set.Paths {
+			removeIndexPath(original, path)
+			if e, err := committed.Entry(path); err == nil {
+				kept := *e
+				original.Entries = append(original.Entries, &kept)
+			}
+		}
+	}
+
+	if err := r.Storer.SetIndex(original); err != nil {
+		return err
+	}
+	if err := os.Remove(statePath); err != nil {
+		return err
+	}
+	return os.Remove(indexPath)
 }
 
-// switches to the specified branch
-func switchBranch(branchName string) error {
+// puts back an index left set aside by a commit got was stopped in
+func recoverOnlyIndex() error {
 	r, err := git.PlainOpen(".")
 	if err != nil {
 		return err
 	}
+	return restoreOnlyIndex(r)
+}
 
-	w, err := r.Worktree()
+// returns the remote branch HEAD has already been pushed to, or "" if it
+// has not been
+func headPushedTo() (string, error) {
+	r, err := git.PlainOpen(".")
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	err = w.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.NewBranchReferenceName(branchName),
-	})
-	return err
+	head, err := r.Head()
+	if err != nil {
+		return "", err
+	}
+	if !head.Name().IsBranch() {
+		return "", nil
+	}
+
+	remoteName, mergeRef, err := pushTarget(r)
+	if err != nil {
+		// nowhere to push to, so nothing was pushed
+		return "", nil
+	}
+
+	remoteRef := plumbing.NewRemoteReferenceName(remoteName, mergeRef.Short())
+	if remoteName == "." {
+		remoteRef = mergeRef
+	}
+	remote, err := r.Reference(remoteRef, true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	pushed, err := isAncestor(r, head.Hash(), remote.Hash())
+	if err != nil || !pushed {
+		return "", err
+	}
+	return remoteRef.Short(), nil
+}
+
+// returns the name of the current branch
+func getCurrentBranch() (string, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return "", err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return "", err
+	}
+
+	if head.Name().IsBranch() {
+		return head.Name().Short(), nil
+	}
+
+	return "HEAD", nil
+}
+
+// returns a list of all local branches
+func listBranches() ([]string, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	branches, err := r.Branches()
+	if err != nil {
+		return nil, err
+	}
+
+	var branchNames []string
+	err = branches.ForEach(func(ref *plumbing.Reference) error {
+		if !strings.Contains(ref.Name().String(), "refs/remotes/") {
+			branchNames = append(branchNames, ref.Name().Short())
+		}
+		return nil
+	})
+
+	return branchNames, err
+}
+
+// creates a new branch from the current HEAD
+func createBranch(branchName string) error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+
+	// create the new branch
+	refName := plumbing.NewBranchReferenceName(branchName)
+	ref := plumbing.NewHashReference(refName, head.Hash())
+
+	return r.Storer.SetReference(ref)
+}
+
+// what happens to local changes when switching branches
+const (
+	switchStash   = "stash"   // stash them, switch, then pop the stash
+	switchCarry   = "carry"   // take them along, as git checkout does
+	switchDiscard = "discard" // throw them away, into the trash
+)
+
+// local changes that matter when switching to a branch
+type SwitchCheck struct {
+	Dirty     []string // tracked paths with staged or unstaged changes
+	Blocking  []string // changed paths that differ between the branches
+	Untracked []string // untracked files the branch would overwrite
+}
+
+// true if the checkout has to decide what to do with local changes
+func (c *SwitchCheck) NeedsChoice() bool {
+	return len(c.Dirty) > 0 || len(c.Untracked) > 0
+}
+
+// finds the local changes a switch to a branch would clobber
+func checkSwitch(branchName string) (*SwitchCheck, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireNoOperation(r); err != nil {
+		return nil, err
+	}
+	if unmerged, err := unmergedPaths(r); err != nil {
+		return nil, err
+	} else if len(unmerged) > 0 {
+		return nil, fmt.Errorf("resolve the conflicts first: %s", strings.Join(unmerged, ", "))
+	}
+
+	headFiles, targetFiles, err := switchFiles(r, branchName)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	check := &SwitchCheck{}
+	for path, s := range status {
+		changed := !sameFile(fileAt(headFiles, path), fileAt(targetFiles, path))
+		switch {
+		case s.Worktree == git.Untracked:
+			if changed {
+				check.Untracked = append(check.Untracked, path)
+			}
+		case s.Staging != git.Unmodified || s.Worktree != git.Unmodified:
+			check.Dirty = append(check.Dirty, path)
+			if changed {
+				check.Blocking = append(check.Blocking, path)
+			}
+		}
+	}
+	sort.Strings(check.Dirty)
+	sort.Strings(check.Blocking)
+	sort.Strings(check.Untracked)
+
+	return check, nil
+}
+
+// the files of HEAD and of a branch's tip
+func switchFiles(r *git.Repository, branchName string) (map[string]treeFile, map[string]treeFile, error) {
+	ref, err := r.Reference(plumbing.NewBranchReferenceName(branchName), true)
+	if err != nil {
+		return nil, nil, err
+	}
+	target, err := r.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	targetFiles, err := commitFiles(target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// an unborn branch has no files yet
+	head, err := r.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return map[string]treeFile{}, targetFiles, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	headCommit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	headFiles, err := commitFiles(headCommit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return headFiles, targetFiles, nil
+}
+
+// switches to the specified branch, handling local changes as mode says;
+// returns the conflicts left by reapplying stashed changes
+func switchBranch(branchName, mode string) ([]string, error) {
+	check, err := checkSwitch(branchName)
+	if err != nil {
+		return nil, err
+	}
+
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+	branch := plumbing.NewBranchReferenceName(branchName)
+
+	switch mode {
+	case switchStash:
+		if !check.NeedsChoice() {
+			break
+		}
+		if err := saveStash("autostash before switching to "+branchName, len(check.Untracked) > 0, nil); err != nil {
+			return nil, err
+		}
+		if err := checkoutBranch(r, w, branch); err != nil {
+			return nil, fmt.Errorf("%w, your changes are kept in stash@{0}", err)
+		}
+		conflicts, err := applyStash(0, true)
+		if err != nil {
+			return nil, fmt.Errorf("switched, but reapplying your changes failed, they are kept in stash@{0}: %w", err)
+		}
+		return conflicts, nil
+
+	case switchCarry:
+		if len(check.Blocking) > 0 || len(check.Untracked) > 0 {
+			return nil, fmt.Errorf("local changes would be overwritten: %s", strings.Join(append(check.Blocking, check.Untracked...), ", "))
+		}
+		return nil, carryCheckout(r, w, branch, check.Dirty)
+
+	case switchDiscard:
+		// saved in the trash first, like any other discard
+		if check.NeedsChoice() {
+			if _, err := discardFiles(nil, check.Dirty, check.Untracked); err != nil {
+				return nil, err
+			}
+		}
+		return nil, checkoutBranch(r, w, branch)
+	}
+
+	if check.NeedsChoice() {
+		return nil, fmt.Errorf("there are local changes, choose what to do with them")
+	}
+	return nil, checkoutBranch(r, w, branch)
+}
+
+// checks out the files of a branch, overwriting local changes to tracked
+// files, and points HEAD at it
+func checkoutBranch(r *git.Repository, w *git.Worktree, branch plumbing.ReferenceName) error {
+	ref, err := r.Reference(branch, true)
+	if err != nil {
+		return err
+	}
+	c, err := r.CommitObject(ref.Hash())
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(c)
+	if err != nil {
+		return err
+	}
+	if err := checkoutFiles(r, w, files); err != nil {
+		return err
+	}
+	return r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch))
+}
+
+// checks out a branch and puts back the index entries and worktree files
+// of paths the branches agree on
+func carryCheckout(r *git.Repository, w *git.Worktree, branch plumbing.ReferenceName, paths []string) error {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	entries := map[string]index.Entry{}
+	files := map[string]*treeFile{}
+	for _, path := range paths {
+		if e, err := idx.Entry(path); err == nil {
+			entries[path] = *e
+		}
+		if files[path], err = readWorktreeBlob(r, w, path); err != nil {
+			return err
+		}
+	}
+
+	if err := checkoutBranch(r, w, branch); err != nil {
+		return err
+	}
+
+	if idx, err = r.Storer.Index(); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		removeIndexPath(idx, path)
+		if e, ok := entries[path]; ok {
+			idx.Entries = append(idx.Entries, &e)
+		}
+
+		f := files[path]
+		if f == nil {
+			if err := w.Filesystem.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		content, err := readBlob(r, f.Hash)
+		if err != nil {
+			return err
+		}
+		if err := writeWorktreeFile(w, path, content, f.Mode); err != nil {
+			return err
+		}
+	}
+
+	return r.Storer.SetIndex(idx)
+}
+
+var errBranchNotMerged = errors.New("branch is not fully merged")
+
+// deletes a local branch and its config section, refusing branches whose
+// commits are not reachable from HEAD unless force is set
+func deleteBranch(branchName string, force bool) error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+
+	refName := plumbing.NewBranchReferenceName(branchName)
+	if head.Name() == refName {
+		return fmt.Errorf("cannot delete the current branch")
+	}
+
+	ref, err := r.Reference(refName, true)
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		merged, err := isAncestor(r, ref.Hash(), head.Hash())
+		if err != nil {
+			return err
+		}
+		if !merged {
+			return errBranchNotMerged
+		}
+	}
+
+	if err := r.Storer.RemoveReference(refName); err != nil {
+		return err
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Branches[branchName]; ok {
+		delete(cfg.Branches, branchName)
+		return r.SetConfig(cfg)
+	}
+	return nil
+}
+
+// renames a local branch, moving HEAD and its config section along
+func renameBranch(oldName, newName string) error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	oldRef := plumbing.NewBranchReferenceName(oldName)
+	newRef := plumbing.NewBranchReferenceName(newName)
+
+	ref, err := r.Reference(oldRef, true)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Reference(newRef, true); err == nil {
+		return fmt.Errorf("branch %s already exists", newName)
+	}
+
+	if err := r.Storer.SetReference(plumbing.NewHashReference(newRef, ref.Hash())); err != nil {
+		return err
+	}
+
+	// HEAD is symbolic, so it has to follow the rename
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+	if head.Type() == plumbing.SymbolicReference && head.Target() == oldRef {
+		if err := r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, newRef)); err != nil {
+			return err
+		}
+	}
+
+	if err := r.Storer.RemoveReference(oldRef); err != nil {
+		return err
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	if b, ok := cfg.Branches[oldName]; ok {
+		delete(cfg.Branches, oldName)
+		b.Name = newName
+		cfg.Branches[newName] = b
+		return r.SetConfig(cfg)
+	}
+	return nil
+}
+
+// resolves a revision such as a hash, branch, tag or HEAD~2 to a commit
+func resolveCommit(r *git.Repository, rev string) (plumbing.Hash, error) {
+	hash, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unknown revision %s: %w", rev, err)
+	}
+	return *hash, nil
+}
+
+// returns how many commits a branch would lose by pointing it at target
+func branchResetLoss(branchName, target string) (int, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return 0, err
+	}
+
+	ref, err := r.Reference(plumbing.NewBranchReferenceName(branchName), true)
+	if err != nil {
+		return 0, err
+	}
+
+	hash, err := resolveCommit(r, target)
+	if err != nil {
+		return 0, err
+	}
+
+	lost, _, err := aheadBehind(r, ref.Hash(), hash)
+	return lost, err
+}
+
+// points a branch other than the current one at another commit, like
+// git branch --force
+func resetBranch(branchName, target string) error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+
+	refName := plumbing.NewBranchReferenceName(branchName)
+	if head.Name() == refName {
+		return fmt.Errorf("cannot force-reset the current branch, reset HEAD instead")
+	}
+	if _, err := r.Reference(refName, true); err != nil {
+		return err
+	}
+
+	hash, err := resolveCommit(r, target)
+	if err != nil {
+		return err
+	}
+
+	return r.Storer.SetReference(plumbing.NewHashReference(refName, hash))
+}
+
+// true if ancestor is reachable from commit
+func isAncestor(r *git.Repository, ancestor, commit plumbing.Hash) (bool, error) {
+	if ancestor == commit {
+		return true, nil
+	}
+
+	a, err := r.CommitObject(ancestor)
+	if err != nil {
+		return false, err
+	}
+	c, err := r.CommitObject(commit)
+	if err != nil {
+		return false, err
+	}
+
+	return a.IsAncestor(c)
+}
+
+// how many commits older than every walked one revList still reads once
+// only excluded commits are left, for clocks that disagree a little
+const revListSlop = 5
+
+// commits reachable from include but not from exclude, newest first, like
+// git rev-list include ^exclude. the walk goes by committer time and stops
+// shortly after only excluded commits older than the walked ones are left,
+// so it reads little more than the commits it returns; like git it relies
+// on commits being mostly newer than their parents
+func revList(r *git.Repository, include, exclude []plumbing.Hash) ([]*object.Commit, error) {
+	const (
+		queued = 1 << iota
+		visited
+		excluded
+	)
+	flags := map[plumbing.Hash]int{}
+	queue := &commitQueue{}
+	included := 0 // queued commits not excluded
+
+	mark := func(hash plumbing.Hash, exclude bool) error {
+		f, known := flags[hash]
+		switch {
+		case known && (!exclude || f&excluded != 0):
+			return nil
+		case f&queued != 0:
+			flags[hash] = f | excluded
+			included--
+			return nil
+		}
+
+		// a commit visited as included is queued again to exclude its
+		// parents too
+		c, err := r.CommitObject(hash)
+		if err != nil {
+			return err
+		}
+		f |= queued
+		if exclude {
+			f |= excluded
+		} else {
+			included++
+		}
+		flags[hash] = f
+		heap.Push(queue, c)
+		return nil
+	}
+
+	for _, hash := range exclude {
+		if err := mark(hash, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, hash := range include {
+		if err := mark(hash, false); err != nil {
+			return nil, err
+		}
+	}
+
+	var walked []*object.Commit
+	slop := revListSlop
+	for queue.Len() > 0 {
+		if included == 0 {
+			// the excluded commits left can only reach walked ones if they
+			// are as new as those, or a few older
+			if len(walked) == 0 {
+				break
+			}
+			if (*queue)[0].Committer.When.Before(walked[len(walked)-1].Committer.When) {
+				if slop--; slop == 0 {
+					break
+				}
+			} else {
+				slop = revListSlop
+			}
+		}
+
+		c := heap.Pop(queue).(*object.Commit)
+		f := flags[c.Hash]&^queued | visited
+		flags[c.Hash] = f
+		if f&excluded == 0 {
+			included--
+			walked = append(walked, c)
+		}
+		for _, parent := range c.ParentHashes {
+			if err := mark(parent, f&excluded != 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// a commit can be excluded after it was walked
+	commits := walked[:0]
+	for _, c := range walked {
+		if flags[c.Hash]&excluded == 0 {
+			commits = append(commits, c)
+		}
+	}
+	return commits, nil
+}
+
+// commits by committer time, newest first, for container/heap
+type commitQueue []*object.Commit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	return q[i].Committer.When.After(q[j].Committer.When)
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(*object.Commit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
 }
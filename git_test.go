@@ -0,0 +1,386 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// runs the test from dir, with HOME empty so no global git config is read
+func chdirRepo(t *testing.T, dir string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(dir)
+}
+
+// a new repository in a temporary directory the test runs from
+func newTestRepo(t *testing.T) *git.Repository {
+	t.Helper()
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chdirRepo(t, dir)
+	return r
+}
+
+// writes a worktree file, creating its directory
+func writeTestFile(t *testing.T, r *git.Repository, path, content string) {
+	t.Helper()
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := filepath.Join(w.Filesystem.Root(), filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writes a file, stages it and commits it on the current branch
+func commitTestFile(t *testing.T, r *git.Repository, path, content string) plumbing.Hash {
+	t.Helper()
+	writeTestFile(t, r, path, content)
+
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add(path); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := w.Commit("change "+path, &git.CommitOptions{Author: testSignature()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func testSignature() *object.Signature {
+	return &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+}
+
+// the commit a reference points at
+func refHash(t *testing.T, r *git.Repository, name plumbing.ReferenceName) plumbing.Hash {
+	t.Helper()
+	ref, err := r.Reference(name, true)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return ref.Hash()
+}
+
+// the content of every file in the index
+func indexContents(t *testing.T, r *git.Repository) map[string]string {
+	t.Helper()
+	idx, err := r.Storer.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{}
+	for _, e := range idx.Entries {
+		content, err := readBlob(r, e.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[e.Name] = content
+	}
+	return contents
+}
+
+// the content of every file in a commit
+func commitContents(t *testing.T, r *git.Repository, hash plumbing.Hash) map[string]string {
+	t.Helper()
+	c, err := r.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := commitFiles(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{}
+	for path, f := range files {
+		content, err := readBlob(r, f.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[path] = content
+	}
+	return contents
+}
+
+func TestWithOnlyIndex(t *testing.T) {
+	tests := []struct {
+		name      string
+		paths     []string
+		fail      bool              // the commit fails
+		restage   bool              // a hook changes and stages a.txt
+		stop      bool              // got stops inside fn and recovers on the next start
+		wantTree  map[string]string // committed, nil if nothing is
+		wantIndex map[string]string // afterwards
+	}{
+		{
+			name:      "the index as it is",
+			paths:     nil,
+			wantTree:  map[string]string{"a.txt": "a1", "b.txt": "b2", "c.txt": "c1"},
+			wantIndex: map[string]string{"a.txt": "a1", "b.txt": "b2", "c.txt": "c1"},
+		},
+		{
+			name:      "an unstaged change",
+			paths:     []string{"a.txt"},
+			wantTree:  map[string]string{"a.txt": "a2", "b.txt": "b1", "c.txt": "c1"},
+			wantIndex: map[string]string{"a.txt": "a2", "b.txt": "b2", "c.txt": "c1"},
+		},
+		{
+			name:      "a staged change",
+			paths:     []string{"b.txt"},
+			wantTree:  map[string]string{"a.txt": "a1", "b.txt": "b2", "c.txt": "c1"},
+			wantIndex: map[string]string{"a.txt": "a1", "b.txt": "b2", "c.txt": "c1"},
+		},
+		{
+			name:      "an untracked file",
+			paths:     []string{"new.txt"},
+			wantTree:  map[string]string{"a.txt": "a1", "b.txt": "b1", "c.txt": "c1", "new.txt": "n"},
+			wantIndex: map[string]string{"a.txt": "a1", "b.txt": "b2", "c.txt": "c1", "new.txt": "n"},
+		},
+		{
+			name:      "a deleted file",
+			paths:     []string{"c.txt"},
+			wantTree:  map[string]string{"a.txt": "a1", "b.txt": "b1"},
+			wantIndex: map[string]string{"a.txt": "a1", "b.txt": "b2"},
+		},
+		{
+			name:      "several paths",
+			paths:     []string{"a.txt", "c.txt", "new.txt"},
+			wantTree:  map[string]string{"a.txt": "a2", "b.txt": "b1", "new.txt": "n"},
+			wantIndex: map[string]string{"a.txt": "a2", "b.txt": "b2", "new.txt": "n"},
+		},
+		{
+			name:      "a failed commit",
+			paths:     []string{"a.txt", "c.txt"},
+			fail:      true,
+			wantIndex: map[string]string{"a.txt": "a1", "b.txt": "b2", "c.txt": "c1"},
+		},
+		{
+			name:      "a hook staging a change",
+			paths:     []string{"a.txt"},
+			restage:   true,
+			wantTree:  map[string]string{"a.txt": "a3", "b.txt": "b1", "c.txt": "c1"},
+			wantIndex: map[string]string{"a.txt": "a3", "b.txt": "b2", "c.txt": "c1"},
+		},
+		{
+			name:      "stopped before the commit",
+			paths:     []string{"a.txt", "c.txt"},
+			fail:      true,
+			stop:      true,
+			wantIndex: map[string]string{"a.txt": "a1", "b.txt": "b2", "c.txt": "c1"},
+		},
+		{
+			name:      "stopped after the commit",
+			paths:     []string{"a.txt", "c.txt"},
+			stop:      true,
+			wantTree:  map[string]string{"a.txt": "a2", "b.txt": "b1"},
+			wantIndex: map[string]string{"a.txt": "a2", "b.txt": "b2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t)
+			commitTestFile(t, r, "a.txt", "a1")
+			commitTestFile(t, r, "b.txt", "b1")
+			commitTestFile(t, r, "c.txt", "c1")
+
+			// a staged change, an unstaged change, an untracked file and
+			// a file deleted only from the worktree
+			w, err := r.Worktree()
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, r, "b.txt", "b2")
+			if _, err := w.Add("b.txt"); err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, r, "a.txt", "a2")
+			writeTestFile(t, r, "new.txt", "n")
+			if err := os.Remove("c.txt"); err != nil {
+				t.Fatal(err)
+			}
+
+			var committed plumbing.Hash
+			failure := errors.New("hook failed")
+			run := func() (err error) {
+				if tt.stop {
+					defer func() {
+						if recover() == nil {
+							t.Fatal("fn did not stop")
+						}
+						err = recoverOnlyIndex()
+					}()
+				}
+				return withOnlyIndex(tt.paths, func() error {
+					if tt.restage {
+						writeTestFile(t, r, "a.txt", "a3")
+						if _, err := w.Add("a.txt"); err != nil {
+							return err
+						}
+					}
+					var err error
+					if !tt.fail {
+						committed, err = w.Commit("commit", &git.CommitOptions{Author: testSignature()})
+					}
+					if tt.stop {
+						panic("stopped")
+					}
+					if tt.fail {
+						return failure
+					}
+					return err
+				})
+			}
+			err = run()
+
+			switch {
+			case tt.fail && !tt.stop:
+				if !errors.Is(err, failure) {
+					t.Fatalf("withOnlyIndex returned %v, want %v", err, failure)
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+			if tt.wantTree != nil {
+				if got := commitContents(t, r, committed); !maps.Equal(got, tt.wantTree) {
+					t.Errorf("committed %v, want %v", got, tt.wantTree)
+				}
+			}
+			if got := indexContents(t, r); !maps.Equal(got, tt.wantIndex) {
+				t.Errorf("index afterwards holds %v, want %v", got, tt.wantIndex)
+			}
+			if _, statePath, err := onlyIndexPaths(r); err != nil {
+				t.Fatal(err)
+			} else if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+				t.Errorf("the set aside index was left behind")
+			}
+		})
+	}
+}
+
+func TestRevList(t *testing.T) {
+	r := newTestRepo(t)
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour)
+	commit := func(message string, minutes int, parents ...plumbing.Hash) plumbing.Hash {
+		t.Helper()
+		sig := &object.Signature{Name: "Test", Email: "test@example.com", When: start.Add(time.Duration(minutes) * time.Minute)}
+		hash, err := w.Commit(message, &git.CommitOptions{Author: sig, Committer: sig, Parents: parents, AllowEmptyCommits: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	// c0 - c1 - c2 - m - c3
+	//   \           /
+	//    s1 ------ s2
+	// s2's clock is behind, it claims to be older than its parent s1
+	c0 := commit("c0", 0)
+	c1 := commit("c1", 1, c0)
+	c2 := commit("c2", 2, c1)
+	s1 := commit("s1", 3, c0)
+	s2 := commit("s2", 1, s1)
+	m := commit("m", 4, c2, s2)
+	c3 := commit("c3", 5, m)
+
+	tests := []struct {
+		name             string
+		include, exclude []plumbing.Hash
+		want             []plumbing.Hash
+	}{
+		{"a tip alone", []plumbing.Hash{s2}, nil, []plumbing.Hash{s1, s2, c0}},
+		{"excluding itself", []plumbing.Hash{c3}, []plumbing.Hash{c3}, nil},
+		{"excluding an ancestor", []plumbing.Hash{c3}, []plumbing.Hash{c2}, []plumbing.Hash{c3, m, s1, s2}},
+		{"excluding a skewed side", []plumbing.Hash{c3}, []plumbing.Hash{s2}, []plumbing.Hash{c3, m, c2, c1}},
+		{"excluding a descendant", []plumbing.Hash{s1}, []plumbing.Hash{c3}, nil},
+		{"several tips", []plumbing.Hash{c2, s2}, []plumbing.Hash{s1}, []plumbing.Hash{c2, c1, s2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commits, err := revList(r, tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[plumbing.Hash]bool{}
+			for _, c := range commits {
+				got[c.Hash] = true
+			}
+			want := map[plumbing.Hash]bool{}
+			for _, h := range tt.want {
+				want[h] = true
+			}
+			if !maps.Equal(got, want) {
+				t.Errorf("revList gave %d commits %v, want %d %v", len(got), commits, len(want), tt.want)
+			}
+		})
+	}
+}
+
+// discarding local changes to switch saves them in the trash first
+func TestSwitchBranchDiscard(t *testing.T) {
+	r := newTestRepo(t)
+	commitTestFile(t, r, "a.txt", "a1")
+	if err := createBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	commitTestFile(t, r, "a.txt", "a2")
+
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, r, "a.txt", "local")
+	writeTestFile(t, r, "b.txt", "staged")
+	if _, err := w.Add("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := switchBranch("other", switchDiscard); err != nil {
+		t.Fatal(err)
+	}
+	if got := refHash(t, r, plumbing.HEAD); got != refHash(t, r, plumbing.NewBranchReferenceName("other")) {
+		t.Errorf("HEAD is %s after the switch", got)
+	}
+	if got := indexContents(t, r); !maps.Equal(got, map[string]string{"a.txt": "a1"}) {
+		t.Errorf("index holds %v after the switch", got)
+	}
+
+	d, err := getLastDiscard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil || !slices.Equal(d.Paths(), []string{"a.txt", "b.txt"}) {
+		t.Fatalf("the trash holds %+v, want a.txt and b.txt", d)
+	}
+	if _, err := restoreDiscard(); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{"a.txt": "local", "b.txt": "staged"} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != want {
+			t.Errorf("%s restored as %q, %v, want %q", path, data, err, want)
+		}
+	}
+}
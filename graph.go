@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// colors cycled through by graph lanes
+var laneColors = []lipgloss.Color{"1", "2", "3", "4", "5", "6"}
+
+// graph characters, each lane takes two columns: a node and a connector
+const (
+	graphCommit = '*'
+	graphLine   = '│'
+	graphHoriz  = '─'
+	graphFork   = '╮' // a parent opens a new lane to the right
+	graphJoin   = '╯' // a lane from above ends in this commit
+	graphMerge  = '┤' // a parent continues on an existing lane
+	graphCross  = '┼'
+)
+
+// lays out commits on lanes, one row per commit, like git log --graph
+type commitGraph struct {
+	// commit each lane is waiting for, zero for a free lane
+	lanes []plumbing.Hash
+}
+
+type graphCell struct {
+	char rune
+	lane int
+}
+
+// places the next commit and returns its rendered graph row; commits must
+// be fed children first
+func (g *commitGraph) next(c CommitInfo) string {
+	col := g.laneOf(c.Hash)
+	if col < 0 {
+		// a branch tip nothing has pointed at yet
+		col = g.freeLane(0)
+		g.lanes[col] = c.Hash
+	}
+
+	width := len(g.lanes)
+	cells := make([]graphCell, 0, width)
+	for i, h := range g.lanes {
+		char := ' '
+		if !h.IsZero() {
+			char = graphLine
+		}
+		cells = append(cells, graphCell{char: char, lane: i})
+	}
+	cells[col].char = graphCommit
+
+	// connectors to the right of each node, drawn between col and target
+	horiz := map[int]int{}
+	connect := func(target int, char rune) {
+		for len(cells) <= target {
+			cells = append(cells, graphCell{char: ' ', lane: len(cells)})
+		}
+		lo, hi := min(col, target), max(col, target)
+		for i := lo + 1; i < hi; i++ {
+			if cells[i].char == graphLine {
+				cells[i].char = graphCross
+			} else if cells[i].char == ' ' {
+				cells[i].char = graphHoriz
+			}
+		}
+		for i := lo; i < hi; i++ {
+			horiz[i] = target
+		}
+		// a lane ending here and reopened for a parent passes straight through
+		if cells[target].char == graphJoin && char == graphFork {
+			char = graphMerge
+		}
+		cells[target] = graphCell{char: char, lane: target}
+	}
+
+	// other lanes waiting for this commit were forks that end here
+	for i, h := range g.lanes {
+		if i != col && h == c.Hash {
+			g.lanes[i] = plumbing.ZeroHash
+			connect(i, graphJoin)
+		}
+	}
+
+	// the first parent continues this lane, other parents join or open one
+	g.lanes[col] = plumbing.ZeroHash
+	for i, p := range c.Parents {
+		if i == 0 {
+			g.lanes[col] = p
+			continue
+		}
+
+		if lane := g.laneOf(p); lane >= 0 {
+			connect(lane, graphMerge)
+			continue
+		}
+		lane := g.freeLane(col + 1)
+		g.lanes[lane] = p
+		connect(lane, graphFork)
+	}
+
+	// drop free lanes at the right edge
+	for len(g.lanes) > 0 && g.lanes[len(g.lanes)-1].IsZero() {
+		g.lanes = g.lanes[:len(g.lanes)-1]
+	}
+
+	var b strings.Builder
+	for i, cell := range cells {
+		style := lipgloss.NewStyle().Foreground(laneColors[cell.lane%len(laneColors)])
+		if cell.char == graphCommit {
+			style = style.Bold(true)
+		}
+		b.WriteString(style.Render(string(cell.char)))
+
+		if target, ok := horiz[i]; ok {
+			b.WriteString(lipgloss.NewStyle().Foreground(laneColors[target%len(laneColors)]).Render(string(graphHoriz)))
+		} else {
+			b.WriteString(" ")
+		}
+	}
+
+	return b.String()
+}
+
+// lane waiting for the given commit, or -1
+func (g *commitGraph) laneOf(hash plumbing.Hash) int {
+	for i, h := range g.lanes {
+		if h == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// first free lane at or after from, growing the lanes if needed
+func (g *commitGraph) freeLane(from int) int {
+	for i := from; i < len(g.lanes); i++ {
+		if g.lanes[i].IsZero() {
+			return i
+		}
+	}
+	for len(g.lanes) < from {
+		g.lanes = append(g.lanes, plumbing.ZeroHash)
+	}
+	g.lanes = append(g.lanes, plumbing.ZeroHash)
+	return len(g.lanes) - 1
+}
+
+// returns labels for every commit pointed at by a local branch, remote
+// branch or tag, in the order git log --decorate uses
+func getRefLabels() (map[plumbing.Hash][]string, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := r.Head()
+	if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, err
+	}
+
+	refs, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+
+	type label struct {
+		text  string
+		order int
+	}
+	labels := map[plumbing.Hash][]label{}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		name := ref.Name()
+		hash := ref.Hash()
+		switch {
+		case name.IsBranch():
+			text := name.Short()
+			order := 1
+			if head != nil && head.Name() == name {
+				text = "HEAD -> " + text
+				order = 0
+			}
+			labels[hash] = append(labels[hash], label{text, order})
+		case name.IsRemote():
+			if strings.HasSuffix(name.String(), "/HEAD") {
+				return nil
+			}
+			labels[hash] = append(labels[hash], label{name.Short(), 2})
+		case name.IsTag():
+			// annotated tags point at a tag object, label its target
+			if tag, err := r.TagObject(hash); err == nil {
+				hash = tag.Target
+			}
+			labels[hash] = append(labels[hash], label{"tag: " + name.Short(), 3})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// a detached HEAD is labelled on its own
+	if head != nil && !head.Name().IsBranch() {
+		labels[head.Hash()] = append(labels[head.Hash()], label{"HEAD", 0})
+	}
+
+	result := make(map[plumbing.Hash][]string, len(labels))
+	for hash, ls := range labels {
+		sort.SliceStable(ls, func(i, j int) bool {
+			if ls[i].order != ls[j].order {
+				return ls[i].order < ls[j].order
+			}
+			return ls[i].text < ls[j].text
+		})
+		for _, l := range ls {
+			result[hash] = append(result[hash], l.text)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommitGraph(t *testing.T) {
+	info := func(name string, parents ...string) CommitInfo {
+		c := CommitInfo{Hash: testHash(name), Subject: name}
+		for _, p := range parents {
+			c.Parents = append(c.Parents, testHash(p))
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		commits []CommitInfo // children first
+		want    []string
+	}{
+		{
+			name:    "a straight line",
+			commits: []CommitInfo{info("b", "a"), info("a")},
+			want:    []string{"*", "*"},
+		},
+		{
+			name: "a merge",
+			commits: []CommitInfo{
+				info("m", "x", "y"), info("y", "a"), info("x", "a"), info("a"),
+			},
+			want: []string{"*─╮", "│ *", "* │", "*─╯"},
+		},
+		{
+			name: "two tips",
+			commits: []CommitInfo{
+				info("x", "a"), info("y", "a"), info("a"),
+			},
+			want: []string{"*", "│ *", "*─╯"},
+		},
+		{
+			name: "several lanes ending in one commit",
+			commits: []CommitInfo{
+				info("t", "a"), info("m", "b", "c"), info("c", "a"), info("b", "a"), info("a"),
+			},
+			want: []string{"*", "│ *─╮", "│ │ *", "│ * │", "*─╯─╯"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &commitGraph{}
+			var got []string
+			for _, c := range tt.commits {
+				got = append(got, strings.TrimRight(g.next(c), " "))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("graph is\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			if len(g.lanes) != 0 {
+				t.Errorf("lanes left open: %v", g.lanes)
+			}
+		})
+	}
+}
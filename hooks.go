@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	git "github.com/go-git/go-git/v5"
+)
+
+// the hooks run around a commit, in the order git runs them
+var commitHooks = []string{"pre-commit", "prepare-commit-msg", "commit-msg", "post-commit"}
+
+// runs a repository's hooks from .git/hooks or core.hooksPath, writing
+// their output to out
+type hookRunner struct {
+	dir   string // hooks directory
+	root  string // worktree root, where git runs hooks
+	index string // index file, exported as GIT_INDEX_FILE
+	out   io.Writer
+}
+
+func newHookRunner(r *git.Repository, w *git.Worktree, out io.Writer) (*hookRunner, error) {
+	gd, err := gitDir(r)
+	if err != nil {
+		return nil, err
+	}
+	if gd, err = filepath.Abs(gd); err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(w.Filesystem.Root())
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(gd, "hooks")
+	hooksPath, err := gitConfigValue(r, "core", "", "hooksPath")
+	if err != nil {
+		return nil, err
+	}
+	if hooksPath != "" {
+		// a relative hooks path is relative to where hooks run
+		dir = expandHome(hooksPath)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+	}
+
+	return &hookRunner{dir: dir, root: root, index: filepath.Join(gd, "index"), out: out}, nil
+}
+
+// path of an installed hook, or "" if it is missing or not executable, in
+// which case git skips it too
+func (h *hookRunner) path(name string) string {
+	path := filepath.Join(h.dir, name)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+		return ""
+	}
+	return path
+}
+
+// runs a hook if it is installed, failing if it exits non-zero
+func (h *hookRunner) run(name string, args ...string) error {
+	path := h.path(name)
+	if path == "" {
+		return nil
+	}
+
+	fmt.Fprintf(h.out, "running %s\n", name)
+	cmd := exec.Command(path, args...)
+	cmd.Dir = h.root
+	cmd.Stdout = h.out
+	cmd.Stderr = h.out
+	// as for git commit -m: the hooks see the index and no editor is run
+	cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+h.index, "GIT_EDITOR=:")
+
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return fmt.Errorf("%s hook exited with status %d", name, exit.ExitCode())
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook: %w", name, err)
+	}
+	return nil
+}
+
+// true if any commit hook is installed
+func hasCommitHooks() (bool, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return false, err
+	}
+	h, err := newHookRunner(r, w, io.Discard)
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range commitHooks {
+		if h.path(name) != "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// runs prepare-commit-msg on a message about to be opened in the editor,
+// as git does before starting it, so the hook can fill in the message
+func prepareCommitEditMessage(path string, amend bool, out io.Writer) error {
+	r, w, err := openRepo()
+	if err != nil {
+		return err
+	}
+	h, err := newHookRunner(r, w, out)
+	if err != nil {
+		return err
+	}
+
+	args := []string{path}
+	if amend {
+		args = append(args, "commit", "HEAD")
+	} else if template, err := commitTemplate(r, w); err != nil {
+		return err
+	} else if template != "" {
+		args = append(args, "template")
+	}
+	return h.run("prepare-commit-msg", args...)
+}
+
+// commits, or amends the last commit, running the hooks around it as git
+// commit -m does; verify false skips pre-commit and commit-msg like
+// --no-verify. an edited message already went through prepare-commit-msg
+// before the editor and has its comments stripped. with paths only those
+// are committed, and the hooks see the index holding them, as with git
+// commit -- paths
+func commitWithHooks(message string, amend, verify, edited bool, paths []string, out io.Writer) error {
+	r, w, err := openRepo()
+	if err != nil {
+		return err
+	}
+	h, err := newHookRunner(r, w, out)
+	if err != nil {
+		return err
+	}
+
+	err = withOnlyIndex(paths, func() error {
+		if verify {
+			if err := h.run("pre-commit"); err != nil {
+				return err
+			}
+		}
+
+		// the message hooks edit COMMIT_EDITMSG in place
+		gd, err := gitDir(r)
+		if err != nil {
+			return err
+		}
+		msgFile, err := filepath.Abs(filepath.Join(gd, "COMMIT_EDITMSG"))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(msgFile, []byte(message+"\n"), 0644); err != nil {
+			return err
+		}
+
+		if !edited {
+			if err := h.run("prepare-commit-msg", msgFile, "message"); err != nil {
+				return err
+			}
+		}
+		if verify {
+			if err := h.run("commit-msg", msgFile); err != nil {
+				return err
+			}
+		}
+
+		data, err := os.ReadFile(msgFile)
+		if err != nil {
+			return err
+		}
+		// as with git commit -m, lines starting with # are part of a message
+		// that was not written in the editor
+		if edited {
+			message = stripComments(string(data))
+		} else {
+			message = cleanupWhitespace(string(data))
+		}
+		if message == "" {
+			return fmt.Errorf("the hooks left an empty commit message")
+		}
+
+		if amend {
+			return amendCommit(message)
+		}
+		return commit(message)
+	})
+	if err != nil {
+		return err
+	}
+
+	// like git, a failing post-commit hook does not undo the commit
+	if err := h.run("post-commit"); err != nil {
+		fmt.Fprintln(out, err)
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// git stops following includes this deep
+const maxConfigIncludeDepth = 10
+
+// the name and email commits are made with
+type Identity struct {
+	Name  string
+	Email string
+}
+
+func (i Identity) String() string {
+	return i.Name + " <" + i.Email + ">"
+}
+
+// true once both the name and the email are known
+func (i Identity) Complete() bool {
+	return i.Name != "" && i.Email != ""
+}
+
+// reads a git config value the way git does: the system, global and
+// repository config files in turn, each with the files it includes, the
+// last one setting the key winning
+func gitConfigValue(r *git.Repository, section, subsection, key string) (string, error) {
+	files, err := loadGitConfigs(r)
+	if err != nil {
+		return "", err
+	}
+	return lookupGitConfig(files, section, subsection, key), nil
+}
+
+// the value of a key in the last of the loaded config files setting it
+func lookupGitConfig(files []*format.Config, section, subsection, key string) string {
+	for i := len(files) - 1; i >= 0; i-- {
+		s := files[i].Section(section)
+		opts := s.Options
+		if subsection != "" {
+			opts = s.Subsection(subsection).Options
+		}
+		if value := opts.Get(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// every config file that applies to the repository, lowest precedence
+// first; go-git reads neither includes nor more than one global file
+func loadGitConfigs(r *git.Repository) ([]*format.Config, error) {
+	dir, err := gitDir(r)
+	if err != nil {
+		return nil, err
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return nil, err
+	}
+
+	ctx := &includeContext{gitDir: dir}
+	if head, err := r.Head(); err == nil && head.Name().IsBranch() {
+		ctx.branch = head.Name().Short()
+	}
+
+	var paths []string
+	for _, scope := range []config.Scope{config.SystemScope, config.GlobalScope} {
+		scoped, err := config.Paths(scope)
+		if err != nil {
+			return nil, err
+		}
+		// git reads the xdg file before ~/.gitconfig, go-git lists them the
+		// other way round
+		for i := len(scoped) - 1; i >= 0; i-- {
+			paths = append(paths, scoped[i])
+		}
+	}
+	paths = append(paths, filepath.Join(dir, "config"))
+
+	var files []*format.Config
+	for _, path := range paths {
+		read, err := ctx.read(path, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, read...)
+	}
+	return files, nil
+}
+
+// what includeIf conditions are matched against
+type includeContext struct {
+	gitDir string
+	branch string
+}
+
+// parses a config file followed by the files it includes; included values
+// override the including file's, as they do when the include comes last
+func (ctx *includeContext) read(path string, depth int) ([]*format.Config, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := format.New()
+	if err := format.NewDecoder(f).Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	files := []*format.Config{cfg}
+	if depth >= maxConfigIncludeDepth {
+		return files, nil
+	}
+
+	dir := filepath.Dir(path)
+	var includes []string
+	for _, s := range cfg.Sections {
+		switch {
+		case strings.EqualFold(s.Name, "include"):
+			includes = append(includes, s.Options.GetAll("path")...)
+		case strings.EqualFold(s.Name, "includeIf"):
+			for _, sub := range s.Subsections {
+				if ctx.matches(sub.Name, dir) {
+					includes = append(includes, sub.Options.GetAll("path")...)
+				}
+			}
+		}
+	}
+
+	for _, include := range includes {
+		include = expandHome(include)
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(dir, include)
+		}
+		read, err := ctx.read(include, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, read...)
+	}
+	return files, nil
+}
+
+// evaluates an includeIf condition: gitdir:, gitdir/i: or onbranch:
+func (ctx *includeContext) matches(condition, configDir string) bool {
+	kind, pattern, ok := strings.Cut(condition, ":")
+	if !ok || pattern == "" {
+		return false
+	}
+
+	switch kind {
+	case "gitdir", "gitdir/i":
+		fold := kind == "gitdir/i"
+		pattern = gitdirPattern(pattern, configDir)
+		if wildmatch(pattern, ctx.gitDir, fold) {
+			return true
+		}
+		// git also tries the path with symlinks resolved
+		resolved, err := filepath.EvalSymlinks(ctx.gitDir)
+		return err == nil && wildmatch(pattern, resolved, fold)
+	case "onbranch":
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		return ctx.branch != "" && wildmatch(pattern, ctx.branch, false)
+	}
+	return false
+}
+
+// expands a gitdir: pattern as git does: ~/ is home, ./ is the config
+// file's directory, a relative pattern matches anywhere and a trailing
+// slash matches everything below
+func gitdirPattern(pattern, configDir string) string {
+	trailing := strings.HasSuffix(pattern, "/")
+	switch {
+	case strings.HasPrefix(pattern, "~/"):
+		pattern = expandHome(pattern)
+	case strings.HasPrefix(pattern, "./"):
+		pattern = filepath.Join(configDir, pattern[2:])
+	case !filepath.IsAbs(pattern):
+		pattern = "**/" + pattern
+	}
+	if trailing {
+		pattern = strings.TrimSuffix(pattern, "/") + "/**"
+	}
+	return pattern
+}
+
+// matches a path against a git wildcard pattern, where ** crosses slashes
+// and * and ? do not
+func wildmatch(pattern, name string, fold bool) bool {
+	var re strings.Builder
+	if fold {
+		re.WriteString("(?i)")
+	}
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			re.WriteString("[^/]*")
+		case pattern[i] == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+
+	matched, err := regexp.MatchString(re.String(), name)
+	return err == nil && matched
+}
+
+// the identity from a section's name and email, falling back to user.*
+func configIdentity(r *git.Repository, section string) (Identity, error) {
+	sections := []string{section}
+	if section != "user" {
+		sections = append(sections, "user")
+	}
+
+	files, err := loadGitConfigs(r)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var id Identity
+	for _, s := range sections {
+		if id.Name == "" {
+			id.Name = lookupGitConfig(files, s, "", "name")
+		}
+		if id.Email == "" {
+			id.Email = lookupGitConfig(files, s, "", "email")
+		}
+	}
+	return id, nil
+}
+
+// the configured author of new commits, failing if none is set
+func authorSignature(r *git.Repository) (*object.Signature, error) {
+	id, err := configIdentity(r, "author")
+	if err != nil {
+		return nil, err
+	}
+	if !id.Complete() {
+		return nil, fmt.Errorf("set user.name and user.email in git config, or apply an identity profile")
+	}
+	return &object.Signature{Name: id.Name, Email: id.Email, When: time.Now()}, nil
+}
+
+// the configured user as committer, or nil to commit as the author
+func committerSignature(r *git.Repository) (*object.Signature, error) {
+	id, err := configIdentity(r, "committer")
+	if err != nil {
+		return nil, err
+	}
+	if !id.Complete() {
+		return nil, nil
+	}
+	return &object.Signature{Name: id.Name, Email: id.Email, When: time.Now()}, nil
+}
+
+// the identity the next commit will be made with, and the name of the
+// profile it comes from, if any
+func getIdentity() (Identity, string, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return Identity{}, "", err
+	}
+
+	id, err := configIdentity(r, "author")
+	if err != nil {
+		return id, "", err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return id, "", err
+	}
+	for _, p := range cfg.Profiles {
+		if p.UserName == id.Name && p.Email == id.Email {
+			return id, p.Name, nil
+		}
+	}
+	return id, "", nil
+}
+
+// writes a profile's identity into the repository's own git config, so it
+// applies to this repository only
+func applyProfile(name string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	var profile *Profile
+	for i := range cfg.Profiles {
+		if cfg.Profiles[i].Name == name {
+			profile = &cfg.Profiles[i]
+		}
+	}
+	if profile == nil {
+		return fmt.Errorf("no identity profile named %s", name)
+	}
+	if profile.UserName == "" || profile.Email == "" {
+		return fmt.Errorf("profile %s needs both user_name and email", name)
+	}
+
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+	local, err := r.Storer.Config()
+	if err != nil {
+		return err
+	}
+
+	local.User.Name = profile.UserName
+	local.User.Email = profile.Email
+	// a key left over from another profile would sign as that profile
+	if profile.SigningKey != "" {
+		local.Raw.Section("user").SetOption("signingkey", profile.SigningKey)
+	} else {
+		local.Raw.Section("user").RemoveOption("signingkey")
+	}
+	return r.Storer.SetConfig(local)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// number of commits loaded at a time by the log screen
+const logPageSize = 100
+
+type CommitInfo struct {
+	Hash    plumbing.Hash
+	Author  string
+	Email   string
+	When    time.Time
+	Subject string
+	Parents []plumbing.Hash
+	// signatureNone, signatureVerified or signatureUnverified; set by the
+	// log and commit detail only
+	Signature string
+}
+
+// abbreviated hash, as shown in lists
+func (c CommitInfo) ShortHash() string {
+	return c.Hash.String()[:7]
+}
+
+type CommitFile struct {
+	Path   string
+	Action string // "added", "modified", "deleted"
+}
+
+type CommitDetail struct {
+	CommitInfo
+	Committer string
+	Message   string
+	Files     []CommitFile
+}
+
+// builds the list entry for a commit
+func newCommitInfo(c *object.Commit) CommitInfo {
+	subject, _, _ := strings.Cut(strings.TrimSpace(c.Message), "\n")
+	return CommitInfo{
+		Hash:    c.Hash,
+		Author:  c.Author.Name,
+		Email:   c.Author.Email,
+		When:    c.Author.When,
+		Subject: subject,
+		Parents: c.ParentHashes,
+	}
+}
+
+// returns the hashes of the commits reachable from HEAD, or from every ref
+// when all is set, children before parents. the log screen keeps this order
+// and loads the commits a page at a time with getCommitLog
+func getLogOrder(all bool) ([]plumbing.Hash, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	// a repository without commits has an empty history
+	if _, err := r.Head(); errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	}
+
+	iter, err := r.Log(&git.LogOptions{Order: git.LogOrderCommitterTime, All: all})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	// the graph needs every child before its parents, which committer time
+	// does not promise once clocks disagree, so the whole history is read
+	// and put in topological order as git log --graph does
+	var history []*object.Commit
+	if err := iter.ForEach(func(c *object.Commit) error {
+		history = append(history, c)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	order := make([]plumbing.Hash, 0, len(history))
+	for _, c := range topoOrder(history) {
+		order = append(order, c.Hash)
+	}
+	return order, nil
+}
+
+// returns the log entries of the given commits, in the same order
+func getCommitLog(hashes []plumbing.Hash) ([]CommitInfo, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	verifier := newSignatureVerifier(r)
+	commits := make([]CommitInfo, len(hashes))
+	for i, hash := range hashes {
+		c, err := r.CommitObject(hash)
+		if err != nil {
+			return nil, err
+		}
+		commits[i] = newCommitInfo(c)
+		commits[i].Signature = verifier.check(c)
+	}
+	return commits, nil
+}
+
+// orders commits, given newest first, the way git log --topo-order does:
+// no commit comes before all of its children, and a line of history is
+// kept together instead of being interleaved with others by date
+func topoOrder(commits []*object.Commit) []*object.Commit {
+	children := map[plumbing.Hash]int{}
+	listed := map[plumbing.Hash]*object.Commit{}
+	for _, c := range commits {
+		listed[c.Hash] = c
+	}
+	for _, c := range commits {
+		for _, p := range c.ParentHashes {
+			if _, ok := listed[p]; ok {
+				children[p]++
+			}
+		}
+	}
+
+	// commits whose children are all placed wait on a stack, so the
+	// parent just freed comes next; tips are pushed oldest first so the
+	// newest is taken first
+	var stack []*object.Commit
+	for i := len(commits) - 1; i >= 0; i-- {
+		if children[commits[i].Hash] == 0 {
+			stack = append(stack, commits[i])
+		}
+	}
+
+	ordered := make([]*object.Commit, 0, len(commits))
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		ordered = append(ordered, c)
+		for _, p := range c.ParentHashes {
+			parent, ok := listed[p]
+			if !ok {
+				continue
+			}
+			if children[p]--; children[p] == 0 {
+				stack = append(stack, parent)
+			}
+		}
+	}
+	return ordered
+}
+
+// how far back the history is searched for co-authors
+const authorScanDepth = 2000
+
+// distinct authors of the recent history of HEAD as "Name <email>", most
+// recent first
+func listAuthors() ([]string, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Head(); errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	}
+
+	iter, err := r.Log(&git.LogOptions{Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var authors []string
+	seen := map[string]bool{}
+	n := 0
+	err = iter.ForEach(func(c *object.Commit) error {
+		if n++; n > authorScanDepth {
+			return io.EOF
+		}
+		email := strings.ToLower(c.Author.Email)
+		if email != "" && !seen[email] {
+			seen[email] = true
+			authors = append(authors, c.Author.Name+" <"+c.Author.Email+">")
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return authors, nil
+}
+
+// returns the full message and changed files of a commit
+func getCommitDetail(hash plumbing.Hash) (*CommitDetail, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := r.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := commitChanges(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []CommitFile
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			return nil, err
+		}
+
+		switch action {
+		case merkletrie.Insert:
+			files = append(files, CommitFile{Path: change.To.Name, Action: "added"})
+		case merkletrie.Delete:
+			files = append(files, CommitFile{Path: change.From.Name, Action: "deleted"})
+		default:
+			files = append(files, CommitFile{Path: change.To.Name, Action: "modified"})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	info := newCommitInfo(c)
+	info.Signature = newSignatureVerifier(r).check(c)
+
+	return &CommitDetail{
+		CommitInfo: info,
+		Committer:  c.Committer.Name + " <" + c.Committer.Email + ">",
+		Message:    strings.TrimSpace(c.Message),
+		Files:      files,
+	}, nil
+}
+
+// tree changes introduced by a commit relative to its first parent
+func commitChanges(c *object.Commit) (object.Changes, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var parentTree *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	return object.DiffTree(parentTree, tree)
+}
+
+// computes the diff of one file in a commit against its first parent
+func getCommitFileDiff(hash plumbing.Hash, file CommitFile) (*FileDiff, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := r.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var oldContent string
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		if oldContent, err = readCommitFile(parent, file.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	newContent, err := readCommitFile(c, file.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &FileDiff{Path: file.Path, Status: file.Action}
+	if isBinary(oldContent) || isBinary(newContent) {
+		d.Binary = true
+		return d, nil
+	}
+
+	d.Hunks = computeHunks(oldContent, newContent, diffContext)
+	return d, nil
+}
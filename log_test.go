@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// a commit named name with the named parents, for ordering tests
+func testCommit(name string, parents ...string) *object.Commit {
+	c := &object.Commit{Hash: testHash(name), Message: name}
+	for _, p := range parents {
+		c.ParentHashes = append(c.ParentHashes, testHash(p))
+	}
+	return c
+}
+
+func testHash(name string) plumbing.Hash {
+	return plumbing.ComputeHash(plumbing.CommitObject, []byte(name))
+}
+
+func TestTopoOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		commits []*object.Commit // newest first by committer time
+		want    []string
+	}{
+		{
+			name:    "a straight line",
+			commits: []*object.Commit{testCommit("c", "b"), testCommit("b", "a"), testCommit("a")},
+			want:    []string{"c", "b", "a"},
+		},
+		{
+			name:    "a parent dated after its child",
+			commits: []*object.Commit{testCommit("b", "a"), testCommit("a"), testCommit("c", "b")},
+			want:    []string{"c", "b", "a"},
+		},
+		{
+			name: "a merge keeps each side together",
+			commits: []*object.Commit{
+				testCommit("m", "x2", "y2"), testCommit("y2", "y1"), testCommit("x2", "x1"),
+				testCommit("y1", "a"), testCommit("x1", "a"), testCommit("a"),
+			},
+			want: []string{"m", "y2", "y1", "x2", "x1", "a"},
+		},
+		{
+			name:    "two tips",
+			commits: []*object.Commit{testCommit("x", "a"), testCommit("y", "a"), testCommit("a")},
+			want:    []string{"x", "y", "a"},
+		},
+		{
+			name:    "parents outside the list",
+			commits: []*object.Commit{testCommit("c", "b"), testCommit("b", "a")},
+			want:    []string{"c", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, c := range topoOrder(tt.commits) {
+				got = append(got, c.Message)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("topoOrder gave %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
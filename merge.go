@@ -0,0 +1,1051 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+var errNothingToMerge = errors.New("already up to date")
+
+type MergeResult struct {
+	FastForward bool
+	Commit      plumbing.Hash // merge commit, zero when there are conflicts
+	Conflicts   []string      // paths left with conflict markers
+}
+
+// a file in a flattened tree
+type treeFile struct {
+	Hash plumbing.Hash
+	Mode filemode.FileMode
+}
+
+// outcome of merging one path
+type mergedPath struct {
+	file     *treeFile // nil when the path is deleted
+	conflict bool
+	content  string // worktree content written for a content conflict
+	stages   [3]*treeFile
+}
+
+// merges a local branch into the current branch: fast-forwards when
+// possible, otherwise creates a merge commit or leaves the conflicts in the
+// worktree with MERGE_HEAD recorded
+func mergeBranch(branchName string) (*MergeResult, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	if !head.Name().IsBranch() {
+		return nil, fmt.Errorf("cannot merge into a detached HEAD")
+	}
+	if inProgress, err := mergeInProgress(r); err != nil {
+		return nil, err
+	} else if inProgress {
+		return nil, fmt.Errorf("a merge is already in progress, conclude or abort it first")
+	}
+
+	ref, err := r.Reference(plumbing.NewBranchReferenceName(branchName), true)
+	if err != nil {
+		return nil, err
+	}
+
+	if merged, err := isAncestor(r, ref.Hash(), head.Hash()); err != nil {
+		return nil, err
+	} else if merged {
+		return nil, errNothingToMerge
+	}
+
+	if err := requireCleanWorktree(w); err != nil {
+		return nil, err
+	}
+
+	if ff, err := isAncestor(r, head.Hash(), ref.Hash()); err != nil {
+		return nil, err
+	} else if ff {
+		if err := checkFastForward(r, w, head.Hash(), ref.Hash()); err != nil {
+			return nil, err
+		}
+		if err := resetHard(r, w, ref.Hash()); err != nil {
+			return nil, err
+		}
+		return &MergeResult{FastForward: true, Commit: ref.Hash()}, nil
+	}
+
+	ours, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := r.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("Merge branch '%s' into %s", branchName, head.Name().Short())
+	conflicts, err := mergeCommits(r, w, ours, theirs, "HEAD", branchName)
+	if err != nil {
+		return nil, err
+	}
+
+	// recorded even for a clean merge, so a failed commit can be retried
+	if err := writeMergeState(r, theirs.Hash, message, conflicts); err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 {
+		return &MergeResult{Conflicts: conflicts}, nil
+	}
+
+	author, err := authorSignature(r)
+	if err != nil {
+		return nil, err
+	}
+	committer, err := committerSignature(r)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := commitSigner(r)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := w.Commit(message, &git.CommitOptions{
+		Author:    author,
+		Committer: committer,
+		Parents:   []plumbing.Hash{ours.Hash, theirs.Hash},
+		Signer:    signer,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := clearMergeState(r); err != nil {
+		return nil, err
+	}
+	return &MergeResult{Commit: hash}, nil
+}
+
+// three-way merges theirs into ours using their merge base, updating the
+// index and worktree; returns the conflicted paths
+func mergeCommits(r *git.Repository, w *git.Worktree, ours, theirs *object.Commit, oursLabel, theirsLabel string) ([]string, error) {
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	var base *object.Commit
+	if len(bases) > 0 {
+		base = bases[0]
+	}
+	return mergeTrees(r, w, base, ours, theirs, oursLabel, theirsLabel)
+}
+
+// applies the changes between base and theirs on top of ours, which must be
+// what the index and worktree hold; returns the conflicted paths
+func mergeTrees(r *git.Repository, w *git.Worktree, base, ours, theirs *object.Commit, oursLabel, theirsLabel string) ([]string, error) {
+	baseFiles, err := commitFiles(base)
+	if err != nil {
+		return nil, err
+	}
+	ourFiles, err := commitFiles(ours)
+	if err != nil {
+		return nil, err
+	}
+	theirFiles, err := commitFiles(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]bool{}
+	for _, files := range []map[string]treeFile{baseFiles, ourFiles, theirFiles} {
+		for path := range files {
+			paths[path] = true
+		}
+	}
+
+	results := map[string]mergedPath{}
+	var conflicts []string
+	for path := range paths {
+		b, o, t := fileAt(baseFiles, path), fileAt(ourFiles, path), fileAt(theirFiles, path)
+		if sameFile(o, t) || sameFile(b, t) {
+			continue // ours already has the result
+		}
+
+		var result mergedPath
+		if sameFile(b, o) {
+			result = mergedPath{file: t}
+		} else {
+			result, err = mergeFileVersions(r, b, o, t, oursLabel, theirsLabel)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if result.conflict {
+			conflicts = append(conflicts, path)
+		}
+		results[path] = result
+	}
+	sort.Strings(conflicts)
+
+	var created []string
+	for path, result := range results {
+		if result.file != nil || result.conflict {
+			created = append(created, path)
+		}
+	}
+	if err := checkUntracked(w, ourFiles, created); err != nil {
+		return nil, err
+	}
+
+	if err := applyMergeResults(r, w, results); err != nil {
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
+// merges the three versions of a file that both sides changed
+func mergeFileVersions(r *git.Repository, b, o, t *treeFile, oursLabel, theirsLabel string) (mergedPath, error) {
+	conflict := mergedPath{conflict: true, stages: [3]*treeFile{b, o, t}}
+
+	// modify/delete: keep the surviving version in the worktree
+	if o == nil || t == nil {
+		survivor := o
+		if survivor == nil {
+			survivor = t
+		}
+		content, err := readBlob(r, survivor.Hash)
+		if err != nil {
+			return mergedPath{}, err
+		}
+		conflict.content = content
+		return conflict, nil
+	}
+
+	// only regular files can be merged line by line
+	if !o.Mode.IsFile() || !t.Mode.IsFile() || o.Mode == filemode.Symlink || t.Mode == filemode.Symlink {
+		return conflict, nil
+	}
+
+	var baseContent string
+	if b != nil {
+		var err error
+		if baseContent, err = readBlob(r, b.Hash); err != nil {
+			return mergedPath{}, err
+		}
+	}
+	ourContent, err := readBlob(r, o.Hash)
+	if err != nil {
+		return mergedPath{}, err
+	}
+	theirContent, err := readBlob(r, t.Hash)
+	if err != nil {
+		return mergedPath{}, err
+	}
+
+	if isBinary(baseContent) || isBinary(ourContent) || isBinary(theirContent) {
+		conflict.content = ourContent
+		return conflict, nil
+	}
+
+	regions := mergeLines(baseContent, ourContent, theirContent)
+	merged, conflicted := renderMerge(regions, oursLabel, theirsLabel)
+
+	// the executable bit follows whichever side changed it
+	mode := o.Mode
+	if b != nil && o.Mode == b.Mode {
+		mode = t.Mode
+	}
+
+	if conflicted {
+		conflict.content = merged
+		return conflict, nil
+	}
+
+	hash, err := writeBlob(r, merged)
+	if err != nil {
+		return mergedPath{}, err
+	}
+	return mergedPath{file: &treeFile{Hash: hash, Mode: mode}}, nil
+}
+
+// writes merge results to the index, with conflict stages, and to the
+// worktree, with conflict markers
+func applyMergeResults(r *git.Repository, w *git.Worktree, results map[string]mergedPath) error {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for path, result := range results {
+		removeIndexPath(idx, path)
+
+		if result.conflict {
+			for i, stage := range result.stages {
+				if stage == nil {
+					continue
+				}
+				e := idx.Add(path)
+				e.Hash = stage.Hash
+				e.Mode = stage.Mode
+				e.Stage = index.Stage(i + 1)
+			}
+			if err := writeWorktreeFile(w, path, result.content, filemode.Regular); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if result.file == nil {
+			if err := w.Filesystem.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		content, err := readBlob(r, result.file.Hash)
+		if err != nil {
+			return err
+		}
+		if err := writeWorktreeFile(w, path, content, result.file.Mode); err != nil {
+			return err
+		}
+
+		e := idx.Add(path)
+		e.Hash = result.file.Hash
+		e.Mode = result.file.Mode
+		e.Size = uint32(len(content))
+	}
+
+	return r.Storer.SetIndex(idx)
+}
+
+// removes every entry of a path from the index, including conflict stages
+func removeIndexPath(idx *index.Index, path string) {
+	path = filepath.ToSlash(path)
+	entries := idx.Entries[:0]
+	for _, e := range idx.Entries {
+		if e.Name != path {
+			entries = append(entries, e)
+		}
+	}
+	idx.Entries = entries
+}
+
+// writes a file to the worktree, creating its directory
+func writeWorktreeFile(w *git.Worktree, path, content string, mode filemode.FileMode) error {
+	if err := w.Filesystem.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if mode == filemode.Symlink {
+		w.Filesystem.Remove(path)
+		return w.Filesystem.Symlink(content, path)
+	}
+
+	perm := os.FileMode(0644)
+	if mode == filemode.Executable {
+		perm = 0755
+	}
+
+	f, err := w.Filesystem.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stores content as a blob object
+func writeBlob(r *git.Repository, content string) (plumbing.Hash, error) {
+	obj := r.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(content)))
+
+	wr, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := io.WriteString(wr, content); err != nil {
+		wr.Close()
+		return plumbing.ZeroHash, err
+	}
+	if err := wr.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return r.Storer.SetEncodedObject(obj)
+}
+
+// flattens the tree of a commit into its files, empty for a nil commit
+func commitFiles(c *object.Commit) (map[string]treeFile, error) {
+	files := map[string]treeFile{}
+	if c == nil {
+		return files, nil
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.Mode == filemode.Dir {
+			continue
+		}
+		files[name] = treeFile{Hash: entry.Hash, Mode: entry.Mode}
+	}
+
+	return files, nil
+}
+
+// writes the tree objects for a set of files and returns the root tree
+func buildTree(r *git.Repository, files map[string]treeFile) (plumbing.Hash, error) {
+	entries := []object.TreeEntry{}
+	dirs := map[string]map[string]treeFile{}
+	for path, f := range files {
+		dir, rest, nested := strings.Cut(path, "/")
+		if !nested {
+			entries = append(entries, object.TreeEntry{Name: path, Mode: f.Mode, Hash: f.Hash})
+			continue
+		}
+		if dirs[dir] == nil {
+			dirs[dir] = map[string]treeFile{}
+		}
+		dirs[dir][rest] = f
+	}
+
+	for dir, sub := range dirs {
+		hash, err := buildTree(r, sub)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		entries = append(entries, object.TreeEntry{Name: dir, Mode: filemode.Dir, Hash: hash})
+	}
+
+	// git orders directories as if their name ended in a slash
+	sortName := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return sortName(entries[i]) < sortName(entries[j])
+	})
+
+	obj := r.Storer.NewEncodedObject()
+	if err := (&object.Tree{Entries: entries}).Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return r.Storer.SetEncodedObject(obj)
+}
+
+// stores a commit object without moving any ref
+func writeCommit(r *git.Repository, c *object.Commit) (plumbing.Hash, error) {
+	obj := r.Storer.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return r.Storer.SetEncodedObject(obj)
+}
+
+// file at path, or nil if the tree does not have it
+func fileAt(files map[string]treeFile, path string) *treeFile {
+	if f, ok := files[path]; ok {
+		return &f
+	}
+	return nil
+}
+
+// true if both are absent or have the same content and mode
+func sameFile(a, b *treeFile) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// fails if fast-forwarding from one commit to another would overwrite
+// untracked files
+func checkFastForward(r *git.Repository, w *git.Worktree, from, to plumbing.Hash) error {
+	fromCommit, err := r.CommitObject(from)
+	if err != nil {
+		return err
+	}
+	toCommit, err := r.CommitObject(to)
+	if err != nil {
+		return err
+	}
+
+	fromFiles, err := commitFiles(fromCommit)
+	if err != nil {
+		return err
+	}
+	toFiles, err := commitFiles(toCommit)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for path := range toFiles {
+		paths = append(paths, path)
+	}
+	return checkUntracked(w, fromFiles, paths)
+}
+
+// fails if any of paths is not tracked in files but exists in the worktree
+func checkUntracked(w *git.Worktree, files map[string]treeFile, paths []string) error {
+	sort.Strings(paths)
+	for _, path := range paths {
+		if _, tracked := files[path]; tracked {
+			continue
+		}
+		if _, err := w.Filesystem.Lstat(path); err == nil {
+			return fmt.Errorf("untracked file %s would be overwritten by the merge", path)
+		}
+	}
+	return nil
+}
+
+// fails if there are staged changes or modified tracked files, which a
+// merge could overwrite
+func requireCleanWorktree(w *git.Worktree) error {
+	dirty, err := dirtyPaths(w)
+	if err != nil {
+		return err
+	}
+	if len(dirty) > 0 {
+		return fmt.Errorf("commit or stash your changes first: %s", strings.Join(dirty, ", "))
+	}
+	return nil
+}
+
+// returns tracked paths with staged or unstaged changes
+func dirtyPaths(w *git.Worktree) ([]string, error) {
+	status, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for path, s := range status {
+		if s.Worktree == git.Untracked {
+			continue
+		}
+		if s.Staging != git.Unmodified || s.Worktree != git.Unmodified {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// path of the repository's git directory
+func gitDir(r *git.Repository) (string, error) {
+	s, ok := r.Storer.(*filesystem.Storage)
+	if !ok {
+		return "", fmt.Errorf("repository is not stored on disk")
+	}
+	return s.Filesystem().Root(), nil
+}
+
+// true if MERGE_HEAD exists
+func mergeInProgress(r *git.Repository) (bool, error) {
+	dir, err := gitDir(r)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "MERGE_HEAD"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// records an unfinished merge the way git does, so both got and git can
+// conclude it
+func writeMergeState(r *git.Repository, theirs plumbing.Hash, message string, conflicts []string) error {
+	dir, err := gitDir(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "MERGE_HEAD"), []byte(theirs.String()+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "MERGE_MODE"), []byte("no-ff"), 0644); err != nil {
+		return err
+	}
+
+	msg := message + "\n"
+	if len(conflicts) > 0 {
+		msg += "\n# Conflicts:\n"
+		for _, path := range conflicts {
+			msg += "#\t" + path + "\n"
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, "MERGE_MSG"), []byte(msg), 0644)
+}
+
+// returns the commit being merged and the prepared message, or a zero hash
+// if no merge is in progress
+func readMergeState(r *git.Repository) (plumbing.Hash, string, error) {
+	dir, err := gitDir(r)
+	if err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "MERGE_HEAD"))
+	if os.IsNotExist(err) {
+		return plumbing.ZeroHash, "", nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+
+	hash := plumbing.NewHash(strings.TrimSpace(string(data)))
+
+	msg, err := os.ReadFile(filepath.Join(dir, "MERGE_MSG"))
+	if err != nil && !os.IsNotExist(err) {
+		return plumbing.ZeroHash, "", err
+	}
+
+	return hash, stripComments(string(msg)), nil
+}
+
+// removes MERGE_HEAD and friends once a merge is concluded or aborted
+func clearMergeState(r *git.Repository) error {
+	dir, err := gitDir(r)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{"MERGE_HEAD", "MERGE_MODE", "MERGE_MSG"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// reports whether a merge is in progress and which paths are unmerged,
+// which a stash apply can leave behind too
+func getMergeState() (bool, []Conflict, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return false, nil, err
+	}
+
+	inProgress, err := mergeInProgress(r)
+	if err != nil {
+		return false, nil, err
+	}
+
+	conflicts, err := getConflicts()
+	if err != nil {
+		return false, nil, err
+	}
+	return inProgress, conflicts, nil
+}
+
+// commits the merge in progress with its prepared message
+func concludeMerge() error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	mergeHead, message, err := readMergeState(r)
+	if err != nil {
+		return err
+	}
+	if mergeHead.IsZero() {
+		return fmt.Errorf("no merge in progress")
+	}
+	if message == "" {
+		message = "Merge commit " + mergeHead.String()[:7]
+	}
+	return commit(message)
+}
+
+// abandons a merge in progress, restoring HEAD's index and worktree
+func abortMerge() error {
+	r, w, err := openRepo()
+	if err != nil {
+		return err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+
+	if err := resetHard(r, w, head.Hash()); err != nil {
+		return err
+	}
+
+	return clearMergeState(r)
+}
+
+// resets HEAD, the index and the worktree to a commit, even with conflicts
+// in the index
+func resetHard(r *git.Repository, w *git.Worktree, hash plumbing.Hash) error {
+	c, err := r.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(c)
+	if err != nil {
+		return err
+	}
+	if err := checkoutFiles(r, w, files); err != nil {
+		return err
+	}
+
+	// move the branch HEAD points to, or HEAD itself when detached
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+	name := plumbing.HEAD
+	if head.Type() == plumbing.SymbolicReference {
+		name = head.Target()
+	}
+	return r.Storer.SetReference(plumbing.NewHashReference(name, hash))
+}
+
+// makes the index and the tracked worktree files match files; go-git's own
+// resets are not used because they delete untracked and ignored files
+func checkoutFiles(r *git.Repository, w *git.Worktree, files map[string]treeFile) error {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return err
+	}
+
+	tracked := map[string]bool{}
+	unmerged := map[string]bool{}
+	for _, e := range idx.Entries {
+		tracked[e.Name] = true
+		if e.Stage != 0 {
+			unmerged[e.Name] = true
+		}
+	}
+
+	var removed, written []string
+	for path := range tracked {
+		if _, ok := files[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	for path, f := range files {
+		var current *treeFile
+		if e, err := idx.Entry(path); err == nil && !unmerged[path] {
+			current = &treeFile{Hash: e.Hash, Mode: e.Mode}
+		}
+		s, changed := status[path]
+		if sameFile(current, &f) && (!changed || s.Worktree == git.Unmodified) {
+			continue
+		}
+		written = append(written, path)
+	}
+
+	// removals go first so a file can replace a directory and the other way
+	sort.Strings(removed)
+	for _, path := range removed {
+		removeIndexPath(idx, path)
+		if err := removeWorktreeFile(w, path); err != nil {
+			return err
+		}
+	}
+
+	sort.Strings(written)
+	for _, path := range written {
+		f := files[path]
+		content, err := readBlob(r, f.Hash)
+		if err != nil {
+			return err
+		}
+		if err := writeWorktreeFile(w, path, content, f.Mode); err != nil {
+			return err
+		}
+
+		removeIndexPath(idx, path)
+		e := idx.Add(path)
+		e.Hash = f.Hash
+		e.Mode = f.Mode
+		e.Size = uint32(len(content))
+	}
+
+	return r.Storer.SetIndex(idx)
+}
+
+// paths with unmerged entries in the index
+func unmergedPaths(r *git.Repository) ([]string, error) {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var paths []string
+	for _, e := range idx.Entries {
+		if e.Stage != 0 && !seen[e.Name] {
+			seen[e.Name] = true
+			paths = append(paths, e.Name)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// drops lines starting with # and cleans up the whitespace left, as git
+// does for a message written in the editor
+func stripComments(message string) string {
+	var lines []string
+	for _, line := range strings.Split(message, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return cleanupWhitespace(strings.Join(lines, "\n"))
+}
+
+// trims trailing whitespace from every line, collapses runs of blank lines
+// and drops blank lines at either end, as git commit -m does
+func cleanupWhitespace(message string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// a stretch of a merged file: either lines both sides agree on, or a
+// conflict between the two sides
+type mergeRegion struct {
+	Lines    []string
+	Conflict bool
+	Base     []string
+	Ours     []string
+	Theirs   []string
+}
+
+// a change from the base: base lines [start, end) replaced by lines
+type lineChange struct {
+	start, end int
+	lines      []string
+}
+
+// three-way merges file contents line by line, like git merge-file
+func mergeLines(baseContent, ourContent, theirContent string) []mergeRegion {
+	base := splitLines(baseContent)
+	ourChanges := lineChanges(baseContent, ourContent)
+	theirChanges := lineChanges(baseContent, theirContent)
+
+	var regions []mergeRegion
+	clean := func(lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		if n := len(regions); n > 0 && !regions[n-1].Conflict {
+			regions[n-1].Lines = append(regions[n-1].Lines, lines...)
+			return
+		}
+		regions = append(regions, mergeRegion{Lines: append([]string(nil), lines...)})
+	}
+
+	pos, i, j := 0, 0, 0
+	for i < len(ourChanges) || j < len(theirChanges) {
+		// start a group at the earliest change, then absorb every change of
+		// either side that overlaps or touches it
+		var ours, theirs []lineChange
+		var start, end int
+		if j >= len(theirChanges) || (i < len(ourChanges) && ourChanges[i].start <= theirChanges[j].start) {
+			start, end = ourChanges[i].start, ourChanges[i].end
+			ours = append(ours, ourChanges[i])
+			i++
+		} else {
+			start, end = theirChanges[j].start, theirChanges[j].end
+			theirs = append(theirs, theirChanges[j])
+			j++
+		}
+		for {
+			if i < len(ourChanges) && ourChanges[i].start <= end && (len(theirs) > 0 || ourChanges[i].start < end) {
+				end = max(end, ourChanges[i].end)
+				ours = append(ours, ourChanges[i])
+				i++
+			} else if j < len(theirChanges) && theirChanges[j].start <= end && (len(ours) > 0 || theirChanges[j].start < end) {
+				end = max(end, theirChanges[j].end)
+				theirs = append(theirs, theirChanges[j])
+				j++
+			} else {
+				break
+			}
+		}
+
+		clean(base[pos:start])
+		pos = end
+
+		ourLines := applyChanges(base, start, end, ours)
+		theirLines := applyChanges(base, start, end, theirs)
+		switch {
+		case len(theirs) == 0:
+			clean(ourLines)
+		case len(ours) == 0:
+			clean(theirLines)
+		case equalLines(ourLines, theirLines):
+			clean(ourLines)
+		default:
+			regions = append(regions, mergeRegion{
+				Conflict: true,
+				Base:     append([]string(nil), base[start:end]...),
+				Ours:     ourLines,
+				Theirs:   theirLines,
+			})
+		}
+	}
+	clean(base[pos:])
+
+	return regions
+}
+
+// changes that turn base into other, in base order
+func lineChanges(baseContent, otherContent string) []lineChange {
+	var changes []lineChange
+	pos := 0
+	var current *lineChange
+	flush := func() {
+		if current != nil {
+			changes = append(changes, *current)
+			current = nil
+		}
+	}
+
+	for _, d := range diff.Do(baseContent, otherContent) {
+		lines := splitLines(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			flush()
+			pos += len(lines)
+		case diffmatchpatch.DiffDelete:
+			if current == nil {
+				current = &lineChange{start: pos, end: pos}
+			}
+			pos += len(lines)
+			current.end = pos
+		case diffmatchpatch.DiffInsert:
+			if current == nil {
+				current = &lineChange{start: pos, end: pos}
+			}
+			current.lines = append(current.lines, lines...)
+		}
+	}
+	flush()
+
+	return changes
+}
+
+// base lines [start, end) with the given changes applied
+func applyChanges(base []string, start, end int, changes []lineChange) []string {
+	var out []string
+	pos := start
+	for _, c := range changes {
+		out = append(out, base[pos:c.start]...)
+		out = append(out, c.lines...)
+		pos = c.end
+	}
+	return append(out, base[pos:end]...)
+}
+
+// true if both slices hold the same lines
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writes merge regions out as file content, with git style conflict
+// markers; reports whether any conflict was written
+func renderMerge(regions []mergeRegion, oursLabel, theirsLabel string) (string, bool) {
+	var b strings.Builder
+	conflicted := false
+
+	writeLines := func(lines []string) {
+		for _, line := range lines {
+			b.WriteString(line)
+		}
+	}
+	marker := func(text string) {
+		// markers always start on a line of their own
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+		b.WriteString(text + "\n")
+	}
+
+	for _, region := range regions {
+		if !region.Conflict {
+			writeLines(region.Lines)
+			continue
+		}
+
+		conflicted = true
+		marker("<<<<<<< " + oursLabel)
+		writeLines(region.Ours)
+		marker("=======")
+		writeLines(region.Theirs)
+		marker(">>>>>>> " + theirsLabel)
+	}
+
+	return b.String(), conflicted
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMergeLines(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		want               string
+		conflict           bool
+	}{
+		{
+			name:   "only ours changed",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "only theirs changed",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\n",
+			theirs: "a\nb\nC\n",
+			want:   "a\nb\nC\n",
+		},
+		{
+			name:   "the same change on both sides",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nB\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "changes to separate lines",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "A\nb\nc\nd\ne\n",
+			theirs: "a\nb\nc\nd\nE\n",
+			want:   "A\nb\nc\nd\nE\n",
+		},
+		{
+			name:   "lines added in separate places",
+			base:   "a\nb\nc\nd\n",
+			ours:   "a\nours\nb\nc\nd\n",
+			theirs: "a\nb\nc\ntheirs\nd\n",
+			want:   "a\nours\nb\nc\ntheirs\nd\n",
+		},
+		{
+			name:     "different changes to the same line",
+			base:     "a\nb\nc\n",
+			ours:     "a\nours\nc\n",
+			theirs:   "a\ntheirs\nc\n",
+			want:     "a\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> feature\nc\n",
+			conflict: true,
+		},
+		{
+			name:     "changes to adjacent lines",
+			base:     "a\nb\nc\nd\n",
+			ours:     "a\nB\nc\nd\n",
+			theirs:   "a\nb\nC\nd\n",
+			want:     "a\n<<<<<<< HEAD\nB\nc\n=======\nb\nC\n>>>>>>> feature\nd\n",
+			conflict: true,
+		},
+		{
+			name:     "a deleted line changed on the other side",
+			base:     "a\nb\nc\n",
+			ours:     "a\nc\n",
+			theirs:   "a\nB\nc\n",
+			want:     "a\n<<<<<<< HEAD\n=======\nB\n>>>>>>> feature\nc\n",
+			conflict: true,
+		},
+		{
+			name:     "different lines added at the end",
+			base:     "a\n",
+			ours:     "a\nours\n",
+			theirs:   "a\ntheirs\n",
+			want:     "a\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> feature\n",
+			conflict: true,
+		},
+		{
+			name:     "added on both sides without a base",
+			base:     "",
+			ours:     "ours\n",
+			theirs:   "theirs\n",
+			want:     "<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> feature\n",
+			conflict: true,
+		},
+		{
+			name:     "conflicting last lines without newline",
+			base:     "a\nb",
+			ours:     "a\nours",
+			theirs:   "a\ntheirs",
+			want:     "a\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> feature\n",
+			conflict: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflict := renderMerge(mergeLines(tt.base, tt.ours, tt.theirs), "HEAD", "feature")
+			if got != tt.want {
+				t.Errorf("merged content is %q, want %q", got, tt.want)
+			}
+			if conflict != tt.conflict {
+				t.Errorf("conflict is %v, want %v", conflict, tt.conflict)
+			}
+		})
+	}
+}
+
+func TestMergeLinesConflictSides(t *testing.T) {
+	regions := mergeLines("a\nb\nc\n", "a\nours\nc\n", "a\ntheirs\nmore\nc\n")
+	if len(regions) != 3 {
+		t.Fatalf("got %d regions, want 3: %+v", len(regions), regions)
+	}
+
+	if r := regions[0]; r.Conflict || !slices.Equal(r.Lines, []string{"a\n"}) {
+		t.Errorf("first region is %+v, want the clean line a", r)
+	}
+	r := regions[1]
+	if !r.Conflict {
+		t.Fatalf("second region is %+v, want a conflict", r)
+	}
+	if !slices.Equal(r.Base, []string{"b\n"}) {
+		t.Errorf("base side is %q, want %q", r.Base, []string{"b\n"})
+	}
+	if !slices.Equal(r.Ours, []string{"ours\n"}) {
+		t.Errorf("our side is %q, want %q", r.Ours, []string{"ours\n"})
+	}
+	if !slices.Equal(r.Theirs, []string{"theirs\n", "more\n"}) {
+		t.Errorf("their side is %q, want %q", r.Theirs, []string{"theirs\n", "more\n"})
+	}
+	if r := regions[2]; r.Conflict || !slices.Equal(r.Lines, []string{"c\n"}) {
+		t.Errorf("last region is %+v, want the clean line c", r)
+	}
+}
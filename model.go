@@ -2,6 +2,7 @@ package main
 
 import (
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
 type Model struct {
@@ -20,14 +21,80 @@ type Model struct {
 	showBranchList    bool
 	branches          []string
 	branchListCursor  int
+	width             int
+	height            int
+	diff              *FileDiff
+	diffOffset        int
+	diffFocused       bool
+	hunkCursor        int
+	lineMode          bool
+	lineCursor        int
+	showLog           bool
+	commits           []CommitInfo
+	logOrder          []plumbing.Hash
+	logCursor         int
+	logHasMore        bool
+	logAll            bool
+	logGraph          *commitGraph
+	graphRows         []string
+	refLabels         map[plumbing.Hash][]string
+	showCommitDetail  bool
+	commitDetail      *CommitDetail
+	detailCursor      int
+	detailDiff        *FileDiff
+	detailOffset      int
+	remoteOp          string
+	remoteEvents      chan tea.Msg
+	remoteProgress    []string
+	statusMessage     string
+	statusIsError     bool
+	upstream          *UpstreamInfo
+	branchUpstreams   map[string]*UpstreamInfo
+	merging           bool
+	showConflicts     bool
+	conflicts         []Conflict
+	conflictCursor    int
+	conflictFile      *ConflictFile
+	conflictFocused   bool
+	conflictHunk      int
+	conflictOffset    int
+	showRebase        bool
+	rebaseSteps       []RebaseStep
+	rebaseCursor      int
+	rebaseOnto        plumbing.Hash
+	rebaseUpstream    string
+	rebase            *RebaseState
+	showStash         bool
+	stashes           []StashEntry
+	stashCursor       int
+	stashDiff         []*FileDiff
+	stashOffset       int
+	showTags          bool
+	tags              []TagInfo
+	tagCursor         int
+	pick              *PickState
+	showHooks         bool
+	hookOp            string
+	hookEvents        chan tea.Msg
+	hookOutput        []string
+	hookOffset        int
+	editedCommit      *EditedCommit
 }
 
 type FileStatus struct {
 	Path     string
-	Status   string // "staged", "unstaged", "untracked"
+	Status   string // "staged", "unstaged", "untracked", "conflicted"
 	Selected bool
 }
 
+// a commit waiting for its message to be finished in the editor
+type EditedCommit struct {
+	Path    string // COMMIT_EDITMSG being edited
+	Input   CommitInput
+	Amend   bool
+	Context *CommitFormContext
+}
+
 func NewModel() Model {
 	if !isGitRepo() {
 		return Model{
@@ -46,6 +113,10 @@ func NewModel() Model {
 		}
 	}
 
+	// a commit of only some paths that got was stopped in leaves the rest
+	// of the index set aside
+	recoverErr := recoverOnlyIndex()
+
 	files, err := getGitStatus()
 	if err != nil {
 		files = []FileStatus{}
@@ -56,7 +127,7 @@ func NewModel() Model {
 		currentBranch = "unknown"
 	}
 
-	return Model{
+	m := Model{
 		files:             files,
 		cursor:            0,
 		quitting:          false,
@@ -69,6 +140,14 @@ func NewModel() Model {
 		branchListCursor:  0,
 		showLocalRepoForm: false,
 	}
+	m.loadDiff()
+	m.refreshUpstream()
+	m.refreshMergeState()
+	if recoverErr != nil {
+		m.setError("restoring the index failed: " + recoverErr.Error())
+	}
+
+	return m
 }
 
 func (m Model) Init() tea.Cmd {
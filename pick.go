@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// the two ways of replaying a single commit
+const (
+	pickCherryPick = "cherry-pick"
+	pickRevert     = "revert"
+)
+
+// a cherry-pick or revert stopped by conflicts, kept in CHERRY_PICK_HEAD or
+// REVERT_HEAD and MERGE_MSG as git does
+type PickState struct {
+	Action  string
+	Commit  plumbing.Hash
+	Message string
+}
+
+// what a cherry-pick or revert did: the new commit, or the conflicts that
+// stopped it
+type PickResult struct {
+	Commit    plumbing.Hash
+	Conflicts []string
+}
+
+// applies the changes of a commit onto HEAD as a new commit keeping its
+// author, optionally noting where it was picked from
+func cherryPick(hash plumbing.Hash, recordOrigin bool) (*PickResult, error) {
+	return pick(pickCherryPick, hash, recordOrigin)
+}
+
+// commits the inverse of a commit's changes onto HEAD
+func revertCommit(hash plumbing.Hash) (*PickResult, error) {
+	return pick(pickRevert, hash, false)
+}
+
+func pick(action string, hash plumbing.Hash, recordOrigin bool) (*PickResult, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireNoOperation(r); err != nil {
+		return nil, err
+	}
+	if err := requireCleanWorktree(w); err != nil {
+		return nil, err
+	}
+
+	c, err := r.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if c.NumParents() > 1 {
+		return nil, fmt.Errorf("cannot %s a merge commit", action)
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	ours, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	var parent *object.Commit
+	if c.NumParents() > 0 {
+		if parent, err = c.Parent(0); err != nil {
+			return nil, err
+		}
+	}
+
+	info := newCommitInfo(c)
+	label := info.ShortHash() + " (" + info.Subject + ")"
+
+	// a revert is a cherry-pick of the commit's parent with the commit as base
+	var conflicts []string
+	var message string
+	if action == pickRevert {
+		conflicts, err = mergeTrees(r, w, c, ours, parent, "HEAD", "parent of "+label)
+		message = revertMessage(c)
+	} else {
+		conflicts, err = mergeTrees(r, w, parent, ours, c, "HEAD", label)
+		message = strings.TrimRight(c.Message, "\n")
+		if recordOrigin {
+			message = appendTrailer(message, "(cherry picked from commit "+c.Hash.String()+")")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the state is written first so a failed commit can still be concluded
+	state := &PickState{Action: action, Commit: c.Hash, Message: message}
+	if err := writePickState(r, state, conflicts); err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 {
+		return &PickResult{Conflicts: conflicts}, nil
+	}
+
+	commit, err := commitPick(r, w, state, message)
+	if errors.Is(err, git.ErrEmptyCommit) {
+		if err := abortPickState(r, w); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("nothing to %s, the changes of %s are already on this branch", action, info.ShortHash())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &PickResult{Commit: commit}, nil
+}
+
+// conventional-commit style message of a revert
+func revertMessage(c *object.Commit) string {
+	subject := newCommitInfo(c).Subject
+	return "revert: " + subject + "\n\nThis reverts commit " + c.Hash.String() + "."
+}
+
+// commits the result of a pick, keeping the author of a cherry-picked
+// commit, and clears the pick state
+func commitPick(r *git.Repository, w *git.Worktree, state *PickState, message string) (plumbing.Hash, error) {
+	signer, err := commitSigner(r)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	committer, err := committerSignature(r)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	opts := &git.CommitOptions{Committer: committer, Signer: signer}
+	if state.Action == pickCherryPick {
+		c, err := r.CommitObject(state.Commit)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		opts.Author = &c.Author
+	} else if opts.Author, err = authorSignature(r); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	hash, err := w.Commit(message, opts)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return hash, clearPickState(r)
+}
+
+// commits a cherry-pick or revert whose conflicts were resolved, with the
+// prepared message unless message is given
+func concludePick(message string) error {
+	r, w, err := openRepo()
+	if err != nil {
+		return err
+	}
+
+	state, err := readPickState(r)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no cherry-pick or revert in progress")
+	}
+
+	unmerged, err := unmergedPaths(r)
+	if err != nil {
+		return err
+	}
+	if len(unmerged) > 0 {
+		return fmt.Errorf("resolve and stage the conflicted files first: %s", strings.Join(unmerged, ", "))
+	}
+
+	if message == "" {
+		message = state.Message
+	}
+	_, err = commitPick(r, w, state, message)
+	return err
+}
+
+// abandons a cherry-pick or revert in progress, restoring HEAD
+func abortPick() error {
+	r, w, err := openRepo()
+	if err != nil {
+		return err
+	}
+	return abortPickState(r, w)
+}
+
+func abortPickState(r *git.Repository, w *git.Worktree) error {
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	if err := resetHard(r, w, head.Hash()); err != nil {
+		return err
+	}
+	return clearPickState(r)
+}
+
+// fails if a merge, rebase, cherry-pick or revert is already in progress
+func requireNoOperation(r *git.Repository) error {
+	if merging, err := mergeInProgress(r); err != nil {
+		return err
+	} else if merging {
+		return fmt.Errorf("a merge is in progress, conclude or abort it first")
+	}
+	if state, err := loadRebaseState(r); err != nil {
+		return err
+	} else if state != nil {
+		return fmt.Errorf("a rebase is in progress, finish or abort it first")
+	}
+	if state, err := readPickState(r); err != nil {
+		return err
+	} else if state != nil {
+		return fmt.Errorf("a %s is in progress, conclude or abort it first", state.Action)
+	}
+	return nil
+}
+
+// the head file recording a pick in progress
+func pickHeadFile(action string) string {
+	if action == pickRevert {
+		return "REVERT_HEAD"
+	}
+	return "CHERRY_PICK_HEAD"
+}
+
+// records a pick the way git does, listing conflicts in MERGE_MSG
+func writePickState(r *git.Repository, state *PickState, conflicts []string) error {
+	dir, err := gitDir(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, pickHeadFile(state.Action)), []byte(state.Commit.String()+"\n"), 0644); err != nil {
+		return err
+	}
+
+	msg := state.Message + "\n"
+	if len(conflicts) > 0 {
+		msg += "\n# Conflicts:\n"
+		for _, path := range conflicts {
+			msg += "#\t" + path + "\n"
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, "MERGE_MSG"), []byte(msg), 0644)
+}
+
+// returns the pick in progress, or nil if there is none
+func readPickState(r *git.Repository) (*PickState, error) {
+	dir, err := gitDir(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, action := range []string{pickCherryPick, pickRevert} {
+		data, err := os.ReadFile(filepath.Join(dir, pickHeadFile(action)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		msg, err := os.ReadFile(filepath.Join(dir, "MERGE_MSG"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		return &PickState{
+			Action:  action,
+			Commit:  plumbing.NewHash(strings.TrimSpace(string(data))),
+			Message: stripComments(string(msg)),
+		}, nil
+	}
+	return nil, nil
+}
+
+// removes the pick state once the pick is concluded or aborted
+func clearPickState(r *git.Repository) error {
+	dir, err := gitDir(r)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{"CHERRY_PICK_HEAD", "REVERT_HEAD", "MERGE_MSG"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the cherry-pick or revert in progress, or nil
+func getPickState() (*PickState, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+	return readPickState(r)
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gopkg.in/yaml.v3"
+)
+
+// actions of an interactive rebase step, as in git's todo list
+const (
+	rebasePick   = "pick"
+	rebaseReword = "reword"
+	rebaseEdit   = "edit"
+	rebaseSquash = "squash"
+	rebaseFixup  = "fixup"
+	rebaseDrop   = "drop"
+)
+
+var rebaseActions = []string{rebasePick, rebaseReword, rebaseEdit, rebaseSquash, rebaseFixup, rebaseDrop}
+
+type RebaseStep struct {
+	Action  string `yaml:"action"`
+	Hash    string `yaml:"hash"`
+	Subject string `yaml:"subject"`
+	Message string `yaml:"message,omitempty"` // new message of a reword
+}
+
+// short hash of the step's commit
+func (s RebaseStep) ShortHash() string {
+	return s.Hash[:7]
+}
+
+// an interactive rebase in progress, kept in .git/got/rebase.yaml
+type RebaseState struct {
+	Branch   string       `yaml:"branch"`    // branch being rebased
+	Onto     string       `yaml:"onto"`      // commit the steps are replayed on
+	OrigHead string       `yaml:"orig_head"` // branch tip before the rebase
+	Done     []RebaseStep `yaml:"done"`
+	Todo     []RebaseStep `yaml:"todo"`
+	// step being replayed, and why the rebase stopped there: "conflict",
+	// "edit", or empty when got was interrupted in the middle of it
+	Current *RebaseStep `yaml:"current,omitempty"`
+	Stopped string      `yaml:"stopped,omitempty"`
+}
+
+type RebaseResult struct {
+	Done      bool // every step replayed and the branch updated
+	Stopped   string
+	Step      *RebaseStep
+	Conflicts []string
+}
+
+// returns the commits of HEAD not in upstream as a pick list, oldest first,
+// and the commit they would be replayed on
+func getRebaseTodo(upstream string) ([]RebaseStep, plumbing.Hash, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	onto, err := resolveCommit(r, upstream)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+
+	// everything onto reaches is left out, including what a merge pulled
+	// in below the merge base
+	commits, err := revList(r, []plumbing.Hash{head.Hash()}, []plumbing.Hash{onto})
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+
+	// oldest first, and merge commits are dropped, like git rebase does by
+	// default
+	var steps []RebaseStep
+	for _, c := range slices.Backward(topoOrder(commits)) {
+		if c.NumParents() > 1 {
+			continue
+		}
+		steps = append(steps, RebaseStep{Action: rebasePick, Hash: c.Hash.String(), Subject: newCommitInfo(c).Subject})
+	}
+
+	return steps, onto, nil
+}
+
+// checks that a todo list can be replayed
+func validateRebaseTodo(steps []RebaseStep) error {
+	for _, step := range steps {
+		switch step.Action {
+		case rebaseDrop:
+			continue
+		case rebaseSquash, rebaseFixup:
+			return fmt.Errorf("cannot %s %s without a previous commit", step.Action, step.ShortHash())
+		}
+		return nil
+	}
+	return nil
+}
+
+// detaches HEAD at onto and starts replaying the steps on it
+func startRebase(onto plumbing.Hash, steps []RebaseStep) (*RebaseResult, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	if state, err := loadRebaseState(r); err != nil {
+		return nil, err
+	} else if state != nil {
+		return nil, fmt.Errorf("a rebase is already in progress, continue or abort it first")
+	}
+	if inProgress, err := mergeInProgress(r); err != nil {
+		return nil, err
+	} else if inProgress {
+		return nil, fmt.Errorf("a merge is in progress, conclude or abort it first")
+	}
+
+	if err := validateRebaseTodo(steps); err != nil {
+		return nil, err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	if !head.Name().IsBranch() {
+		return nil, fmt.Errorf("cannot rebase a detached HEAD")
+	}
+
+	if err := requireCleanWorktree(w); err != nil {
+		return nil, err
+	}
+	if err := checkFastForward(r, w, head.Hash(), onto); err != nil {
+		return nil, err
+	}
+
+	state := &RebaseState{
+		Branch:   head.Name().Short(),
+		Onto:     onto.String(),
+		OrigHead: head.Hash().String(),
+		Todo:     steps,
+	}
+	if err := saveRebaseState(r, state); err != nil {
+		return nil, err
+	}
+
+	// the steps are replayed on a detached HEAD, the branch only moves once
+	// they all succeed
+	if err := r.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, onto)); err != nil {
+		return nil, errors.Join(err, clearRebaseState(r))
+	}
+	if err := resetHard(r, w, onto); err != nil {
+		return nil, err
+	}
+
+	return runRebase(r, w, state)
+}
+
+// picks up a stopped rebase: commits the resolved conflict, moves on from
+// an edit stop, or replays again the step got was interrupted in
+func continueRebase() (*RebaseResult, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := loadRebaseState(r)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("no rebase in progress")
+	}
+
+	if state.Stopped == "" {
+		return resumeRebase(r, w, state)
+	}
+	if state.Current == nil {
+		return nil, fmt.Errorf("the rebase state has no stopped step, abort the rebase")
+	}
+
+	switch state.Stopped {
+	case "conflict":
+		unmerged, err := unmergedPaths(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(unmerged) > 0 {
+			return nil, fmt.Errorf("resolve and stage the conflicted files first: %s", strings.Join(unmerged, ", "))
+		}
+		if err := commitRebaseStep(r, w, state, *state.Current); err != nil {
+			return nil, err
+		}
+	case "edit":
+		if err := requireCleanWorktree(w); err != nil {
+			return nil, err
+		}
+	}
+
+	state.Done = append(state.Done, *state.Current)
+	state.Current = nil
+	state.Stopped = ""
+	return runRebase(r, w, state)
+}
+
+// drops the step the rebase stopped at and carries on with the next one
+func skipRebase() (*RebaseResult, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := loadRebaseState(r)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("no rebase in progress")
+	}
+	if state.Stopped != "conflict" || state.Current == nil {
+		return nil, fmt.Errorf("only a conflicting step can be skipped, continue instead")
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	if err := resetHard(r, w, head.Hash()); err != nil {
+		return nil, err
+	}
+
+	skipped := *state.Current
+	skipped.Action = rebaseDrop
+	state.Done = append(state.Done, skipped)
+	state.Current = nil
+	state.Stopped = ""
+	return runRebase(r, w, state)
+}
+
+// carries on with a rebase that got was interrupted in, e.g. by a failed
+// step or a crash: whatever the step had applied is thrown away and it is
+// replayed from the start
+func resumeRebase(r *git.Repository, w *git.Worktree, state *RebaseState) (*RebaseResult, error) {
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	if err := resetHard(r, w, head.Hash()); err != nil {
+		return nil, err
+	}
+
+	if state.Current != nil {
+		state.Todo = append([]RebaseStep{*state.Current}, state.Todo...)
+		state.Current = nil
+	}
+	return runRebase(r, w, state)
+}
+
+// puts the branch, index and worktree back as they were before the rebase
+func abortRebase() error {
+	r, w, err := openRepo()
+	if err != nil {
+		return err
+	}
+
+	state, err := loadRebaseState(r)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no rebase in progress")
+	}
+
+	branch := plumbing.NewBranchReferenceName(state.Branch)
+	if err := r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch)); err != nil {
+		return err
+	}
+	if err := resetHard(r, w, plumbing.NewHash(state.OrigHead)); err != nil {
+		return err
+	}
+
+	return clearRebaseState(r)
+}
+
+// replays the remaining steps until one stops or all are done. the state is
+// saved before each step, so an interrupted run can be continued or aborted
+func runRebase(r *git.Repository, w *git.Worktree, state *RebaseState) (*RebaseResult, error) {
+	for len(state.Todo) > 0 {
+		if err := saveRebaseState(r, state); err != nil {
+			return nil, err
+		}
+
+		step := state.Todo[0]
+		state.Todo = state.Todo[1:]
+		state.Current = &step
+
+		if step.Action == rebaseDrop {
+			state.Done = append(state.Done, step)
+			state.Current = nil
+			continue
+		}
+
+		reused, err := reuseRebaseStep(r, w, step)
+		if err != nil {
+			return nil, err
+		}
+		if reused {
+			state.Done = append(state.Done, step)
+			state.Current = nil
+			continue
+		}
+
+		conflicts, err := applyRebaseStep(r, w, step)
+		if err != nil {
+			return nil, err
+		}
+		if len(conflicts) > 0 {
+			state.Stopped = "conflict"
+			if err := saveRebaseState(r, state); err != nil {
+				return nil, err
+			}
+			return &RebaseResult{Stopped: state.Stopped, Step: &step, Conflicts: conflicts}, nil
+		}
+
+		if err := commitRebaseStep(r, w, state, step); err != nil {
+			return nil, err
+		}
+
+		if step.Action == rebaseEdit {
+			state.Stopped = "edit"
+			if err := saveRebaseState(r, state); err != nil {
+				return nil, err
+			}
+			return &RebaseResult{Stopped: state.Stopped, Step: &step}, nil
+		}
+
+		state.Done = append(state.Done, step)
+		state.Current = nil
+	}
+
+	if err := finishRebase(r, state); err != nil {
+		return nil, err
+	}
+	return &RebaseResult{Done: true}, nil
+}
+
+// moves HEAD to the step's own commit when it is a plain pick whose parent
+// is HEAD, so commits that do not move keep their hash
+func reuseRebaseStep(r *git.Repository, w *git.Worktree, step RebaseStep) (bool, error) {
+	if step.Action != rebasePick {
+		return false, nil
+	}
+
+	c, err := r.CommitObject(plumbing.NewHash(step.Hash))
+	if err != nil {
+		return false, err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return false, err
+	}
+	if c.NumParents() != 1 || c.ParentHashes[0] != head.Hash() {
+		return false, nil
+	}
+
+	return true, resetHard(r, w, c.Hash)
+}
+
+// applies the changes of a step's commit to the index and worktree;
+// returns the conflicted paths
+func applyRebaseStep(r *git.Repository, w *git.Worktree, step RebaseStep) ([]string, error) {
+	c, err := r.CommitObject(plumbing.NewHash(step.Hash))
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	ours, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	var base *object.Commit
+	if c.NumParents() > 0 {
+		if base, err = c.Parent(0); err != nil {
+			return nil, err
+		}
+	}
+
+	return mergeTrees(r, w, base, ours, c, "HEAD", step.ShortHash()+" ("+step.Subject+")")
+}
+
+// commits the applied changes of a step: a new commit keeping the original
+// author, or folded into the previous one for squash and fixup
+func commitRebaseStep(r *git.Repository, w *git.Worktree, state *RebaseState, step RebaseStep) error {
+	c, err := r.CommitObject(plumbing.NewHash(step.Hash))
+	if err != nil {
+		return err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+
+	committer, err := committerSignature(r)
+	if err != nil {
+		return err
+	}
+
+	signer, err := commitSigner(r)
+	if err != nil {
+		return err
+	}
+
+	opts := &git.CommitOptions{Author: &c.Author, Committer: committer, Signer: signer}
+	message := c.Message
+	switch step.Action {
+	case rebaseReword:
+		if step.Message != "" {
+			message = step.Message
+		}
+	case rebaseSquash, rebaseFixup:
+		prev, err := r.CommitObject(head.Hash())
+		if err != nil {
+			return err
+		}
+		opts.Author = &prev.Author
+		opts.Parents = prev.ParentHashes
+		opts.AllowEmptyCommits = true
+		message = prev.Message
+		if step.Action == rebaseSquash {
+			message = strings.TrimRight(prev.Message, "\n") + "\n\n" + c.Message
+		}
+	}
+
+	_, err = w.Commit(message, opts)
+	if errors.Is(err, git.ErrEmptyCommit) {
+		// the changes are already on onto, so the commit goes away
+		return nil
+	}
+	return err
+}
+
+// points the rebased branch at the new HEAD and checks it out again
+func finishRebase(r *git.Repository, state *RebaseState) error {
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+
+	branch := plumbing.NewBranchReferenceName(state.Branch)
+	if err := r.Storer.SetReference(plumbing.NewHashReference(branch, head.Hash())); err != nil {
+		return err
+	}
+	if err := r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch)); err != nil {
+		return err
+	}
+	if err := r.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName("ORIG_HEAD"), plumbing.NewHash(state.OrigHead))); err != nil {
+		return err
+	}
+
+	return clearRebaseState(r)
+}
+
+// path of the rebase state file
+func rebaseStatePath(r *git.Repository) (string, error) {
+	dir, err := gitDir(r)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "got", "rebase.yaml"), nil
+}
+
+// loads the rebase in progress, or nil if there is none
+func loadRebaseState(r *git.Repository) (*RebaseState, error) {
+	path, err := rebaseStatePath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state RebaseState
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse rebase state: %w", err)
+	}
+	return &state, nil
+}
+
+func saveRebaseState(r *git.Repository, state *RebaseState) error {
+	path, err := rebaseStatePath(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func clearRebaseState(r *git.Repository) error {
+	path, err := rebaseStatePath(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// returns the rebase in progress, or nil
+func getRebaseState() (*RebaseState, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+	return loadRebaseState(r)
+}
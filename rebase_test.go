@@ -0,0 +1,139 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestGetRebaseTodo(t *testing.T) {
+	r := newTestRepo(t)
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour)
+	commit := func(message string, minutes int, parents ...plumbing.Hash) plumbing.Hash {
+		t.Helper()
+		sig := &object.Signature{Name: "Test", Email: "test@example.com", When: start.Add(time.Duration(minutes) * time.Minute)}
+		hash, err := w.Commit(message, &git.CommitOptions{Author: sig, Committer: sig, Parents: parents, AllowEmptyCommits: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	//        u1 ------ um      master
+	//       /         /
+	// c0 - s1 -------+
+	//   \             \
+	//    f1 --------- fm - f2  feature
+	// both sides merged s1, so the merge base is s1 and c0 lies below it
+	c0 := commit("c0", 0)
+	s1 := commit("s1", 1, c0)
+	u1 := commit("u1", 2, c0)
+	um := commit("um", 3, u1, s1)
+	f1 := commit("f1", 4, c0)
+	fm := commit("fm", 5, f1, s1)
+	f2 := commit("f2", 6, fm)
+
+	feature := plumbing.NewBranchReferenceName("feature")
+	for name, hash := range map[plumbing.ReferenceName]plumbing.Hash{master: um, feature: f2} {
+		if err := r.Storer.SetReference(plumbing.NewHashReference(name, hash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, feature)); err != nil {
+		t.Fatal(err)
+	}
+
+	steps, onto, err := getRebaseTodo("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if onto != um {
+		t.Errorf("onto is %s, want %s", onto, um)
+	}
+	var got []plumbing.Hash
+	for _, step := range steps {
+		got = append(got, plumbing.NewHash(step.Hash))
+	}
+	if want := []plumbing.Hash{f1, f2}; !slices.Equal(got, want) {
+		t.Errorf("todo is %v, want %v", got, want)
+	}
+}
+
+// a rebase got was interrupted in is replayed from the step it was at
+func TestContinueInterruptedRebase(t *testing.T) {
+	r := newTestRepo(t)
+	commitTestFile(t, r, "a.txt", "a")
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	feature := plumbing.NewBranchReferenceName("feature")
+	if err := w.Checkout(&git.CheckoutOptions{Branch: feature, Create: true}); err != nil {
+		t.Fatal(err)
+	}
+	commitTestFile(t, r, "b.txt", "b")
+	commitTestFile(t, r, "c.txt", "c")
+
+	if err := w.Checkout(&git.CheckoutOptions{Branch: master}); err != nil {
+		t.Fatal(err)
+	}
+	onto := commitTestFile(t, r, "d.txt", "d")
+	if err := w.Checkout(&git.CheckoutOptions{Branch: feature}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps, _, err := getRebaseTodo("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("todo has %d steps, want 2", len(steps))
+	}
+
+	// stopped while the first step was half applied on a detached HEAD
+	state := &RebaseState{
+		Branch:   "feature",
+		Onto:     onto.String(),
+		OrigHead: refHash(t, r, plumbing.HEAD).String(),
+		Todo:     steps[1:],
+		Current:  &steps[0],
+	}
+	if err := saveRebaseState(r, state); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, onto)); err != nil {
+		t.Fatal(err)
+	}
+	if err := resetHard(r, w, onto); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, r, "b.txt", "half")
+	if _, err := w.Add("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := continueRebase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Done {
+		t.Fatalf("rebase stopped: %+v", result)
+	}
+	if state, err := loadRebaseState(r); err != nil || state != nil {
+		t.Errorf("rebase state left behind: %+v, %v", state, err)
+	}
+
+	want := map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c", "d.txt": "d"}
+	if got := commitContents(t, r, refHash(t, r, plumbing.HEAD)); !maps.Equal(got, want) {
+		t.Errorf("rebased branch holds %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+var (
+	errNonFastForward = errors.New("non-fast-forward")
+	errAuth           = errors.New("authentication failed")
+)
+
+// fetches all branches and tags of a remote
+func fetchRemote(remoteName string, progress io.Writer) error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	auth, err := remoteAuth(r, remoteName)
+	if err != nil {
+		return err
+	}
+
+	err = r.Fetch(&git.FetchOptions{
+		RemoteName: remoteName,
+		Progress:   progress,
+		Auth:       auth,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	return remoteError(err)
+}
+
+// fetches the current branch from its remote and fast-forwards onto it
+func pullRemote(progress io.Writer) error {
+	r, w, err := openRepo()
+	if err != nil {
+		return err
+	}
+
+	remoteName, mergeRef, err := pushTarget(r)
+	if err != nil {
+		return err
+	}
+	if remoteName == "." {
+		// tracking a local branch, there is nothing to fetch
+		return fastForwardLocal(r, w, mergeRef)
+	}
+
+	auth, err := remoteAuth(r, remoteName)
+	if err != nil {
+		return err
+	}
+
+	err = w.Pull(&git.PullOptions{
+		RemoteName:    remoteName,
+		ReferenceName: mergeRef,
+		Progress:      progress,
+		Auth:          auth,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	if errors.Is(err, git.ErrNonFastForwardUpdate) {
+		return fmt.Errorf("%w: local and remote branches have diverged, merge or rebase first", errNonFastForward)
+	}
+	return remoteError(err)
+}
+
+// pushes the current branch to its remote
+func pushRemote(progress io.Writer) error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	if !head.Name().IsBranch() {
+		return fmt.Errorf("cannot push a detached HEAD")
+	}
+
+	remoteName, mergeRef, err := pushTarget(r)
+	if err != nil {
+		return err
+	}
+
+	auth, err := remoteAuth(r, remoteName)
+	if err != nil {
+		return err
+	}
+
+	// go-git rejects a non-fast-forward push with an untyped error, so the
+	// remote's branch is checked first
+	remoteHash, err := remoteRefHash(r, remoteName, auth, mergeRef)
+	if err != nil {
+		return err
+	}
+	if !remoteHash.IsZero() && remoteHash != head.Hash() {
+		ff, err := isAncestor(r, remoteHash, head.Hash())
+		if err != nil && !errors.Is(err, plumbing.ErrObjectNotFound) {
+			return err
+		}
+		// a remote commit not fetched yet cannot be in the local history
+		if !ff {
+			return fmt.Errorf("%w: the remote has commits you do not have, pull first", errNonFastForward)
+		}
+	}
+
+	err = r.Push(&git.PushOptions{
+		RemoteName: remoteName,
+		RefSpecs:   []config.RefSpec{config.RefSpec(head.Name().String() + ":" + mergeRef.String())},
+		Progress:   progress,
+		Auth:       auth,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	return remoteError(err)
+}
+
+// the commit a remote has for a reference, zero if it has none
+func remoteRefHash(r *git.Repository, remoteName string, auth transport.AuthMethod, name plumbing.ReferenceName) (plumbing.Hash, error) {
+	remote, err := r.Remote(remoteName)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, remoteError(err)
+	}
+	for _, ref := range refs {
+		if ref.Name() == name {
+			return ref.Hash(), nil
+		}
+	}
+	return plumbing.ZeroHash, nil
+}
+
+// fast-forwards the current branch onto a local branch it tracks, as
+// pulling from the "." remote does
+func fastForwardLocal(r *git.Repository, w *git.Worktree, name plumbing.ReferenceName) error {
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	ref, err := r.Reference(name, true)
+	if err != nil {
+		return err
+	}
+
+	if upToDate, err := isAncestor(r, ref.Hash(), head.Hash()); err != nil {
+		return err
+	} else if upToDate {
+		return nil
+	}
+	if ff, err := isAncestor(r, head.Hash(), ref.Hash()); err != nil {
+		return err
+	} else if !ff {
+		return fmt.Errorf("%w: local and upstream branches have diverged, merge or rebase first", errNonFastForward)
+	}
+
+	if err := requireCleanWorktree(w); err != nil {
+		return err
+	}
+	if err := checkFastForward(r, w, head.Hash(), ref.Hash()); err != nil {
+		return err
+	}
+	return resetHard(r, w, ref.Hash())
+}
+
+// remote and remote branch the current branch syncs with
+func currentRemote() (string, plumbing.ReferenceName, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return "", "", err
+	}
+	return pushTarget(r)
+}
+
+// remote and remote branch the current branch syncs with: its configured
+// upstream, or the branch of the same name on origin
+func pushTarget(r *git.Repository) (string, plumbing.ReferenceName, error) {
+	head, err := r.Head()
+	if err != nil {
+		return "", "", err
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return "", "", err
+	}
+
+	if b, ok := cfg.Branches[head.Name().Short()]; ok && b.Remote != "" && b.Merge != "" {
+		return b.Remote, b.Merge, nil
+	}
+
+	if _, ok := cfg.Remotes["origin"]; !ok {
+		return "", "", fmt.Errorf("no upstream configured and no origin remote")
+	}
+	return "origin", head.Name(), nil
+}
+
+// picks credentials for a remote: a configured github token for https
+// remotes, the ssh agent or a default key for ssh remotes
+func remoteAuth(r *git.Repository, remoteName string) (transport.AuthMethod, error) {
+	remote, err := r.Remote(remoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("remote %s has no url", remoteName)
+	}
+
+	ep, err := transport.NewEndpoint(urls[0])
+	if err != nil {
+		return nil, err
+	}
+
+	switch ep.Protocol {
+	case "http", "https":
+		if ep.User != "" || ep.Password != "" {
+			return nil, nil // credentials embedded in the url
+		}
+		cfg, err := loadConfig()
+		if err != nil || cfg.GitHubToken == "" || cfg.GitHubToken == "your_github_token_here" {
+			return nil, nil
+		}
+		return &http.BasicAuth{Username: "x-access-token", Password: cfg.GitHubToken}, nil
+	case "ssh":
+		user := ep.User
+		if user == "" {
+			user = ssh.DefaultUsername
+		}
+		if os.Getenv("SSH_AUTH_SOCK") != "" {
+			if auth, err := ssh.NewSSHAgentAuth(user); err == nil {
+				return auth, nil
+			}
+		}
+		// no agent, fall back to the usual unencrypted key files
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			if auth, err := ssh.NewPublicKeysFromFile(user, filepath.Join(home, ".ssh", name), ""); err == nil {
+				return auth, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: no ssh agent or usable key in ~/.ssh", errAuth)
+	}
+
+	return nil, nil
+}
+
+// turns transport errors into messages that say what to fix
+func remoteError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, transport.ErrAuthenticationRequired):
+		return fmt.Errorf("%w: the remote requires credentials, set a github token or ssh key", errAuth)
+	case errors.Is(err, transport.ErrAuthorizationFailed):
+		return fmt.Errorf("%w: the credentials were rejected by the remote", errAuth)
+	case strings.Contains(err.Error(), "ssh: handshake failed"), strings.Contains(err.Error(), "unable to authenticate"):
+		return fmt.Errorf("%w: %v", errAuth, err)
+	case errors.Is(err, transport.ErrRepositoryNotFound):
+		return fmt.Errorf("remote repository not found")
+	}
+	return err
+}
+
+// forwards progress output as lines, treating a carriage return as an
+// update of the current line
+type progressWriter struct {
+	send    func(line string, replace bool)
+	partial string
+	replace bool
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	p.partial += string(data)
+	for {
+		i := strings.IndexAny(p.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		// leading space is kept, hook output is often indented
+		line := strings.TrimRight(p.partial[:i], " \t")
+		if line != "" {
+			p.send(line, p.replace)
+		}
+		p.replace = p.partial[i] == '\r'
+		p.partial = p.partial[i+1:]
+	}
+	return len(data), nil
+}
+
+type UpstreamInfo struct {
+	Name   string // remote branch, e.g. "origin/main"
+	Ahead  int
+	Behind int
+	Gone   bool // configured, but the remote branch no longer exists
+}
+
+// returns the upstream of a local branch with ahead/behind counts, or nil
+// if the branch has no upstream configured
+func getUpstream(branch string) (*UpstreamInfo, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	b, ok := cfg.Branches[branch]
+	if !ok || b.Remote == "" || b.Merge == "" {
+		return nil, nil
+	}
+
+	info := &UpstreamInfo{Name: b.Remote + "/" + b.Merge.Short()}
+	if b.Remote == "." {
+		// tracking another local branch
+		info.Name = b.Merge.Short()
+	}
+
+	local, err := r.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return nil, err
+	}
+
+	upstreamRef := plumbing.NewRemoteReferenceName(b.Remote, b.Merge.Short())
+	if b.Remote == "." {
+		upstreamRef = b.Merge
+	}
+	upstream, err := r.Reference(upstreamRef, true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		info.Gone = true
+		return info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.Ahead, info.Behind, err = aheadBehind(r, local.Hash(), upstream.Hash())
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// counts the commits only reachable from local and only reachable from
+// upstream, like git rev-list --left-right --count
+func aheadBehind(r *git.Repository, local, upstream plumbing.Hash) (int, int, error) {
+	if local == upstream {
+		return 0, 0, nil
+	}
+
+	ahead, err := revList(r, []plumbing.Hash{local}, []plumbing.Hash{upstream})
+	if err != nil {
+		return 0, 0, err
+	}
+	behind, err := revList(r, []plumbing.Hash{upstream}, []plumbing.Hash{local})
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(ahead), len(behind), nil
+}
+
+// returns the remote branches known locally, e.g. "origin/main"
+func listRemoteBranches() ([]string, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name().IsRemote() && !strings.HasSuffix(ref.Name().String(), "/HEAD") {
+			names = append(names, ref.Name().Short())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// returns the names of the configured remotes
+func listRemotes() ([]string, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	remotes, err := r.Remotes()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, remote := range remotes {
+		names = append(names, remote.Config().Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// makes a local branch track a remote branch given as "<remote>/<branch>"
+func setUpstream(branch, upstream string) error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	// remote names may contain slashes too, so the longest configured
+	// remote the upstream starts with is the one meant
+	var remoteName, remoteBranch string
+	for name := range cfg.Remotes {
+		branch, ok := strings.CutPrefix(upstream, name+"/")
+		if ok && branch != "" && len(name) > len(remoteName) {
+			remoteName, remoteBranch = name, branch
+		}
+	}
+	if remoteName == "" {
+		return fmt.Errorf("%s does not name a branch of a configured remote, it must look like <remote>/<branch>", upstream)
+	}
+
+	b, ok := cfg.Branches[branch]
+	if !ok {
+		b = &config.Branch{Name: branch}
+		cfg.Branches[branch] = b
+	}
+	b.Remote = remoteName
+	b.Merge = plumbing.NewBranchReferenceName(remoteBranch)
+
+	return r.SetConfig(cfg)
+}
+
+// removes the upstream of a local branch
+func unsetUpstream(branch string) error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	b, ok := cfg.Branches[branch]
+	if !ok {
+		return nil
+	}
+
+	b.Remote = ""
+	b.Merge = ""
+	// drop the section when nothing else is configured for the branch
+	if b.Rebase == "" && b.Description == "" {
+		delete(cfg.Branches, branch)
+	}
+
+	return r.SetConfig(cfg)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var master = plumbing.NewBranchReferenceName("master")
+
+// a bare repository in a temporary directory, used as the remote
+func newBareRemote(t *testing.T) (string, *git.Repository) {
+	t.Helper()
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, r
+}
+
+// clones the remote into a temporary directory and runs the test from it
+func cloneTestRemote(t *testing.T, url string) (string, *git.Repository) {
+	t.Helper()
+	dir := t.TempDir()
+	r, err := git.PlainClone(dir, false, &git.CloneOptions{URL: url})
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		// go-git gives up on a remote without commits, git sets up an
+		// empty repository with origin
+		r, err = git.PlainInit(dir, false)
+		if err == nil {
+			_, err = r.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{url}})
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	chdirRepo(t, dir)
+	return dir, r
+}
+
+func TestPushFetchPull(t *testing.T) {
+	url, remote := newBareRemote(t)
+
+	firstDir, first := cloneTestRemote(t, url)
+	c1 := commitTestFile(t, first, "a.txt", "one\n")
+	if err := pushRemote(io.Discard); err != nil {
+		t.Fatalf("first push: %v", err)
+	}
+	if got := refHash(t, remote, master); got != c1 {
+		t.Fatalf("remote master is %s, want %s", got, c1)
+	}
+
+	secondDir, second := cloneTestRemote(t, url)
+	if got := refHash(t, second, plumbing.HEAD); got != c1 {
+		t.Fatalf("clone HEAD is %s, want %s", got, c1)
+	}
+
+	t.Chdir(firstDir)
+	c2 := commitTestFile(t, first, "a.txt", "two\n")
+	if err := pushRemote(io.Discard); err != nil {
+		t.Fatalf("second push: %v", err)
+	}
+
+	t.Chdir(secondDir)
+	if err := fetchRemote("origin", io.Discard); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if got := refHash(t, second, plumbing.NewRemoteReferenceName("origin", "master")); got != c2 {
+		t.Fatalf("origin/master is %s after fetch, want %s", got, c2)
+	}
+	if got := refHash(t, second, plumbing.HEAD); got != c1 {
+		t.Fatalf("fetch moved HEAD to %s", got)
+	}
+
+	if err := pullRemote(io.Discard); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	if got := refHash(t, second, plumbing.HEAD); got != c2 {
+		t.Fatalf("HEAD is %s after pull, want %s", got, c2)
+	}
+	data, err := os.ReadFile(filepath.Join(secondDir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "two\n" {
+		t.Fatalf("a.txt is %q after pull, want %q", data, "two\n")
+	}
+
+	// nothing new on either side
+	if err := fetchRemote("origin", io.Discard); err != nil {
+		t.Fatalf("fetch with nothing new: %v", err)
+	}
+	if err := pullRemote(io.Discard); err != nil {
+		t.Fatalf("pull with nothing new: %v", err)
+	}
+	if err := pushRemote(io.Discard); err != nil {
+		t.Fatalf("push with nothing new: %v", err)
+	}
+}
+
+func TestPushNonFastForward(t *testing.T) {
+	url, remote := newBareRemote(t)
+
+	firstDir, first := cloneTestRemote(t, url)
+	commitTestFile(t, first, "a.txt", "one\n")
+	if err := pushRemote(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	secondDir, second := cloneTestRemote(t, url)
+	local := commitTestFile(t, second, "b.txt", "second\n")
+
+	t.Chdir(firstDir)
+	pushed := commitTestFile(t, first, "c.txt", "first\n")
+	if err := pushRemote(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second clone has diverged from the remote
+	t.Chdir(secondDir)
+	if err := pushRemote(io.Discard); !errors.Is(err, errNonFastForward) {
+		t.Fatalf("push of a diverged branch returned %v, want %v", err, errNonFastForward)
+	}
+	if got := refHash(t, remote, master); got != pushed {
+		t.Fatalf("rejected push moved remote master to %s", got)
+	}
+
+	if err := pullRemote(io.Discard); !errors.Is(err, errNonFastForward) {
+		t.Fatalf("pull into a diverged branch returned %v, want %v", err, errNonFastForward)
+	}
+	if got := refHash(t, second, plumbing.HEAD); got != local {
+		t.Fatalf("rejected pull moved HEAD to %s", got)
+	}
+}
+
+func TestAheadBehind(t *testing.T) {
+	r := newTestRepo(t)
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(message string, parents ...plumbing.Hash) plumbing.Hash {
+		t.Helper()
+		hash, err := w.Commit(message, &git.CommitOptions{
+			Author:            testSignature(),
+			Parents:           parents,
+			AllowEmptyCommits: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	// c0 - a1 - a2 - m - a3
+	//   \           /
+	//    b1 -------
+	c0 := commit("c0")
+	a1 := commit("a1", c0)
+	a2 := commit("a2", a1)
+	b1 := commit("b1", c0)
+	m := commit("m", a2, b1)
+	a3 := commit("a3", m)
+
+	tests := []struct {
+		name            string
+		local, upstream plumbing.Hash
+		ahead, behind   int
+	}{
+		{"same commit", a2, a2, 0, 0},
+		{"ahead", a2, c0, 2, 0},
+		{"behind", c0, a2, 0, 2},
+		{"diverged", a2, b1, 2, 1},
+		{"merged the upstream", m, b1, 3, 0},
+		{"ahead of a merged upstream", a3, b1, 4, 0},
+		{"behind through a merge", b1, a3, 0, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ahead, behind, err := aheadBehind(r, tt.local, tt.upstream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ahead != tt.ahead || behind != tt.behind {
+				t.Errorf("aheadBehind = %d, %d, want %d, %d", ahead, behind, tt.ahead, tt.behind)
+			}
+		})
+	}
+}
+
+func TestSetUpstream(t *testing.T) {
+	r := newTestRepo(t)
+	for _, name := range []string{"origin", "team", "team/fork"} {
+		if _, err := r.CreateRemote(&config.RemoteConfig{Name: name, URLs: []string{"https://example.com/" + name}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		upstream     string
+		remote       string
+		remoteBranch string // empty when setUpstream should fail
+	}{
+		{"origin/main", "origin", "main"},
+		{"origin/feature/x", "origin", "feature/x"},
+		{"team/main", "team", "main"},
+		{"team/fork/main", "team/fork", "main"},
+		{"team/fork/feature/x", "team/fork", "feature/x"},
+		{"upstream/main", "", ""},
+		{"origin/", "", ""},
+		{"main", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.upstream, func(t *testing.T) {
+			err := setUpstream("topic", tt.upstream)
+			if tt.remoteBranch == "" {
+				if err == nil {
+					t.Fatal("setUpstream succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := r.Config()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := cfg.Branches["topic"]
+			if b == nil {
+				t.Fatal("no branch config for topic")
+			}
+			if b.Remote != tt.remote || b.Merge != plumbing.NewBranchReferenceName(tt.remoteBranch) {
+				t.Errorf("upstream is %s %s, want %s %s", b.Remote, b.Merge, tt.remote, plumbing.NewBranchReferenceName(tt.remoteBranch))
+			}
+		})
+	}
+
+	if err := unsetUpstream("topic"); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Branches["topic"]; ok {
+		t.Error("unsetUpstream left the branch section behind")
+	}
+}
+
+func TestPullLocalUpstream(t *testing.T) {
+	r := newTestRepo(t)
+	base := commitTestFile(t, r, "a.txt", "one\n")
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// topic tracks master through the "." remote
+	topic := plumbing.NewBranchReferenceName("topic")
+	if err := w.Checkout(&git.CheckoutOptions{Branch: topic, Create: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := setUpstreamLocal(r, "topic", master); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Checkout(&git.CheckoutOptions{Branch: master}); err != nil {
+		t.Fatal(err)
+	}
+	ahead := commitTestFile(t, r, "a.txt", "two\n")
+	if err := w.Checkout(&git.CheckoutOptions{Branch: topic}); err != nil {
+		t.Fatal(err)
+	}
+	if got := refHash(t, r, plumbing.HEAD); got != base {
+		t.Fatalf("topic starts at %s, want %s", got, base)
+	}
+
+	if err := pullRemote(io.Discard); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	if got := refHash(t, r, plumbing.HEAD); got != ahead {
+		t.Fatalf("HEAD is %s after pull, want %s", got, ahead)
+	}
+	if data, err := os.ReadFile("a.txt"); err != nil || string(data) != "two\n" {
+		t.Fatalf("a.txt is %q after pull, %v", data, err)
+	}
+
+	if err := pullRemote(io.Discard); err != nil {
+		t.Fatalf("pull with nothing new: %v", err)
+	}
+
+	// diverged from master
+	commitTestFile(t, r, "b.txt", "topic\n")
+	if err := w.Checkout(&git.CheckoutOptions{Branch: master}); err != nil {
+		t.Fatal(err)
+	}
+	commitTestFile(t, r, "c.txt", "master\n")
+	if err := w.Checkout(&git.CheckoutOptions{Branch: topic}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pullRemote(io.Discard); !errors.Is(err, errNonFastForward) {
+		t.Fatalf("pull of a diverged local upstream returned %v, want %v", err, errNonFastForward)
+	}
+}
+
+// makes branch track another local branch, as git branch -u does
+func setUpstreamLocal(r *git.Repository, branch string, upstream plumbing.ReferenceName) error {
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Branches[branch] = &config.Branch{Name: branch, Remote: ".", Merge: upstream}
+	return r.SetConfig(cfg)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"gopkg.in/yaml.v3"
+)
+
+// names of the reset modes, as git reset takes them
+var resetModes = map[string]git.ResetMode{
+	"soft":  git.SoftReset,
+	"mixed": git.MixedReset,
+	"hard":  git.HardReset,
+}
+
+// the last reset of HEAD, kept so it can be undone
+type ResetUndo struct {
+	Branch string `yaml:"branch"` // empty for a detached HEAD
+	From   string `yaml:"from"`
+	To     string `yaml:"to"`
+	Mode   string `yaml:"mode"`
+}
+
+// what resetting HEAD to a revision would lose
+type ResetLoss struct {
+	Commits   int      // commits no longer reachable from HEAD
+	Changed   []string // tracked paths with uncommitted changes, hard only
+	Untracked []string // untracked files the target overwrites, hard only
+}
+
+// works out what resetting HEAD to rev in mode would lose
+func getResetLoss(rev, mode string) (*ResetLoss, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	target, err := resolveCommit(r, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	loss := &ResetLoss{}
+	if loss.Commits, _, err = aheadBehind(r, head.Hash(), target); err != nil {
+		return nil, err
+	}
+	if mode != "hard" {
+		return loss, nil
+	}
+
+	c, err := r.CommitObject(target)
+	if err != nil {
+		return nil, err
+	}
+	files, err := commitFiles(c)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+	for path, s := range status {
+		switch {
+		case s.Worktree == git.Untracked:
+			if _, ok := files[path]; ok {
+				loss.Untracked = append(loss.Untracked, path)
+			}
+		case s.Staging != git.Unmodified || s.Worktree != git.Unmodified:
+			loss.Changed = append(loss.Changed, path)
+		}
+	}
+	sort.Strings(loss.Changed)
+	sort.Strings(loss.Untracked)
+
+	return loss, nil
+}
+
+// moves HEAD to rev: soft keeps the index and worktree, mixed resets the
+// index, hard resets both; the previous HEAD is kept for undoReset
+func resetHead(rev, mode string) error {
+	r, w, err := openRepo()
+	if err != nil {
+		return err
+	}
+
+	if err := requireNoOperation(r); err != nil {
+		return err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	target, err := resolveCommit(r, rev)
+	if err != nil {
+		return err
+	}
+
+	if err := resetTo(r, w, target, mode); err != nil {
+		return err
+	}
+
+	undo := &ResetUndo{From: head.Hash().String(), To: target.String(), Mode: mode}
+	if head.Name().IsBranch() {
+		undo.Branch = head.Name().Short()
+	}
+	if err := r.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName("ORIG_HEAD"), head.Hash())); err != nil {
+		return err
+	}
+	return saveResetUndo(r, undo)
+}
+
+// puts HEAD back where the last reset found it, with the same mode
+func undoReset() (*ResetUndo, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	undo, err := loadResetUndo(r)
+	if err != nil {
+		return nil, err
+	}
+	if undo == nil {
+		return nil, fmt.Errorf("no reset to undo")
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	if head.Hash().String() != undo.To || (undo.Branch != "" && head.Name().Short() != undo.Branch) {
+		return nil, fmt.Errorf("HEAD has moved since the reset")
+	}
+	if undo.Mode == "hard" {
+		if err := requireCleanWorktree(w); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := resetTo(r, w, plumbing.NewHash(undo.From), undo.Mode); err != nil {
+		return nil, err
+	}
+	return undo, clearResetUndo(r)
+}
+
+// resets HEAD to a commit in the given mode
+func resetTo(r *git.Repository, w *git.Worktree, target plumbing.Hash, mode string) error {
+	resetMode, ok := resetModes[mode]
+	if !ok {
+		return fmt.Errorf("unknown reset mode %s", mode)
+	}
+
+	// go-git's hard reset deletes untracked files, resetHard does not
+	if resetMode == git.HardReset {
+		return resetHard(r, w, target)
+	}
+	return w.Reset(&git.ResetOptions{Commit: target, Mode: resetMode})
+}
+
+// path of the reset undo file
+func resetUndoPath(r *git.Repository) (string, error) {
+	dir, err := gitDir(r)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "got", "reset.yaml"), nil
+}
+
+// loads the last reset, or nil if there is nothing to undo
+func loadResetUndo(r *git.Repository) (*ResetUndo, error) {
+	path, err := resetUndoPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var undo ResetUndo
+	if err := yaml.Unmarshal(data, &undo); err != nil {
+		return nil, fmt.Errorf("failed to parse reset undo: %w", err)
+	}
+	return &undo, nil
+}
+
+func saveResetUndo(r *git.Repository, undo *ResetUndo) error {
+	path, err := resetUndoPath(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(undo)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func clearResetUndo(r *git.Repository) error {
+	path, err := resetUndoPath(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// returns the reset that can be undone, or nil
+func getResetUndo() (*ResetUndo, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+	return loadResetUndo(r)
+}
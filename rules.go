@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// per-repository settings file at the root of the worktree
+const repoConfigFile = ".got.yaml"
+
+// subject casing rules
+const (
+	subjectCaseAny   = ""
+	subjectCaseLower = "lower" // the subject starts with a lowercase letter
+	subjectCaseUpper = "upper" // the subject starts with an uppercase letter
+)
+
+// what the commit form offers and accepts; set in the got config and
+// overridden per repository by .got.yaml
+type CommitRules struct {
+	Types            []CommitType `yaml:"types,omitempty"`
+	Scopes           []string     `yaml:"scopes,omitempty"`
+	RestrictScopes   *bool        `yaml:"restrict_scopes,omitempty"` // only the listed scopes, not just suggestions
+	ScopeRequired    *bool        `yaml:"scope_required,omitempty"`
+	SubjectMaxLength int          `yaml:"subject_max_length,omitempty"`
+	SubjectCase      string       `yaml:"subject_case,omitempty"`
+	SignOff          *bool        `yaml:"sign_off,omitempty"` // sign-off checked by default
+}
+
+type CommitType struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+}
+
+// the repository's own settings
+type RepoConfig struct {
+	Commit CommitRules `yaml:"commit"`
+}
+
+// the conventional commit types offered when none are configured
+var defaultCommitTypes = []CommitType{
+	{"feat", "a new feature"},
+	{"fix", "a bug fix"},
+	{"docs", "documentation only changes"},
+	{"style", "changes that do not affect the meaning of the code"},
+	{"refactor", "a code change that neither fixes a bug nor adds a feature"},
+	{"test", "adding missing tests or correcting existing tests"},
+	{"chore", "changes to the build process or auxiliary tools"},
+	{"perf", "a code change that improves performance"},
+	{"ci", "changes to CI configuration files and scripts"},
+	{"build", "changes that affect the build system or external dependencies"},
+	{"revert", "reverts a previous commit"},
+}
+
+// the commit rules in effect: the defaults, then the got config, then the
+// repository's .got.yaml
+func loadCommitRules() (*CommitRules, error) {
+	rules := &CommitRules{Types: defaultCommitTypes, SubjectMaxLength: 72}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	rules.merge(cfg.Commit)
+
+	repo, err := loadRepoConfig()
+	if err != nil {
+		return nil, err
+	}
+	if repo != nil {
+		rules.merge(repo.Commit)
+	}
+
+	if err := rules.check(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// loads .got.yaml from the root of the worktree, nil if there is none
+func loadRepoConfig() (*RepoConfig, error) {
+	_, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(w.Filesystem.Root(), repoConfigFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var repo RepoConfig
+	if err := yaml.Unmarshal(data, &repo); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", repoConfigFile, err)
+	}
+	return &repo, nil
+}
+
+// overrides the rules with every setting the other rules make
+func (c *CommitRules) merge(other CommitRules) {
+	if len(other.Types) > 0 {
+		c.Types = other.Types
+	}
+	if len(other.Scopes) > 0 {
+		c.Scopes = other.Scopes
+	}
+	if other.RestrictScopes != nil {
+		c.RestrictScopes = other.RestrictScopes
+	}
+	if other.ScopeRequired != nil {
+		c.ScopeRequired = other.ScopeRequired
+	}
+	if other.SubjectMaxLength > 0 {
+		c.SubjectMaxLength = other.SubjectMaxLength
+	}
+	if other.SubjectCase != "" {
+		c.SubjectCase = other.SubjectCase
+	}
+	if other.SignOff != nil {
+		c.SignOff = other.SignOff
+	}
+}
+
+// rejects rules the form could not enforce
+func (c *CommitRules) check() error {
+	for _, t := range c.Types {
+		if t.Name == "" || strings.ContainsAny(t.Name, " ():") {
+			return fmt.Errorf("invalid commit type %q", t.Name)
+		}
+	}
+	switch c.SubjectCase {
+	case subjectCaseAny, subjectCaseLower, subjectCaseUpper:
+	default:
+		return fmt.Errorf("subject_case must be %s or %s", subjectCaseLower, subjectCaseUpper)
+	}
+	if c.scopesRestricted() && len(c.Scopes) == 0 {
+		return fmt.Errorf("restrict_scopes is set but no scopes are listed")
+	}
+	return nil
+}
+
+// true if only the listed scopes are accepted
+func (c *CommitRules) scopesRestricted() bool {
+	return c.RestrictScopes != nil && *c.RestrictScopes
+}
+
+func (c *CommitRules) scopeRequired() bool {
+	return c.ScopeRequired != nil && *c.ScopeRequired
+}
+
+func (c *CommitRules) signOff() bool {
+	return c.SignOff != nil && *c.SignOff
+}
+
+// true if name is one of the allowed types
+func (c *CommitRules) hasType(name string) bool {
+	return slices.ContainsFunc(c.Types, func(t CommitType) bool { return t.Name == name })
+}
+
+func (c *CommitRules) validateScope(scope string) error {
+	if scope == "" {
+		if c.scopeRequired() {
+			return fmt.Errorf("a scope is required")
+		}
+		return nil
+	}
+	if strings.ContainsAny(scope, "():") {
+		return fmt.Errorf("scope cannot contain parentheses or colons")
+	}
+	if c.scopesRestricted() && !slices.Contains(c.Scopes, scope) {
+		return fmt.Errorf("scope must be one of %s", strings.Join(c.Scopes, ", "))
+	}
+	return nil
+}
+
+func (c *CommitRules) validateSubject(subject string) error {
+	if subject == "" {
+		return fmt.Errorf("commit subject cannot be empty")
+	}
+	if utf8.RuneCountInString(subject) > c.SubjectMaxLength {
+		return fmt.Errorf("commit subject should be %d characters or less", c.SubjectMaxLength)
+	}
+
+	first, _ := utf8.DecodeRuneInString(subject)
+	switch {
+	case c.SubjectCase == subjectCaseLower && unicode.IsUpper(first):
+		return fmt.Errorf("commit subject should start with a lowercase letter")
+	case c.SubjectCase == subjectCaseUpper && unicode.IsLower(first):
+		return fmt.Errorf("commit subject should start with an uppercase letter")
+	}
+	return nil
+}
+
+// checks a message written outside the form, e.g. in the editor, against
+// the same rules the form enforces
+func (c *CommitRules) validateMessage(message string) error {
+	parsed := parseCommitMessage(message)
+	if parsed.Type == "" {
+		return fmt.Errorf("the first line should read type(scope): subject")
+	}
+	if !c.hasType(parsed.Type) {
+		names := make([]string, len(c.Types))
+		for i, t := range c.Types {
+			names[i] = t.Name
+		}
+		return fmt.Errorf("commit type must be one of %s", strings.Join(names, ", "))
+	}
+	if err := c.validateScope(parsed.Scope); err != nil {
+		return err
+	}
+	return c.validateSubject(parsed.Subject)
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// environment variable holding the passphrase of an encrypted signing key
+const signingPassphraseEnv = "GOT_SIGNING_PASSPHRASE"
+
+// signature states of a commit, as shown in the history
+const (
+	signatureNone       = ""
+	signatureVerified   = "verified"
+	signatureUnverified = "unverified"
+)
+
+const (
+	sshSignatureBegin = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureEnd   = "-----END SSH SIGNATURE-----"
+	pgpSignatureBegin = "-----BEGIN PGP SIGNATURE-----"
+)
+
+// true for the values git accepts as true
+func gitConfigBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
+// signer for new commits when commit.gpgsign is set, nil otherwise
+func commitSigner(r *git.Repository) (git.Signer, error) {
+	return configuredSigner(r, "commit")
+}
+
+// signer for new annotated tags when tag.gpgsign is set, nil otherwise
+func tagSigner(r *git.Repository) (git.Signer, error) {
+	return configuredSigner(r, "tag")
+}
+
+func configuredSigner(r *git.Repository, section string) (git.Signer, error) {
+	sign, err := gitConfigValue(r, section, "", "gpgsign")
+	if err != nil || !gitConfigBool(sign) {
+		return nil, err
+	}
+
+	format, err := gitConfigValue(r, "gpg", "", "format")
+	if err != nil {
+		return nil, err
+	}
+	key, err := gitConfigValue(r, "user", "", "signingkey")
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "", "openpgp":
+		return newGPGSigner(key)
+	case "ssh":
+		return newSSHSigner(key)
+	}
+	return nil, fmt.Errorf("gpg.format %s is not supported, use openpgp or ssh", format)
+}
+
+// signs with an OpenPGP key from the keyring file named in got's config
+type gpgSigner struct {
+	entity *openpgp.Entity
+}
+
+func newGPGSigner(key string) (*gpgSigner, error) {
+	keyring, err := loadGPGKeyring()
+	if err != nil {
+		return nil, err
+	}
+	if keyring == nil {
+		return nil, fmt.Errorf("commit signing needs gpg_keyring set in the got config")
+	}
+
+	var entity *openpgp.Entity
+	for _, e := range keyring {
+		if e.PrivateKey != nil && matchesGPGKey(e, key) {
+			entity = e
+			break
+		}
+	}
+	if entity == nil {
+		return nil, fmt.Errorf("no secret key for %q in the keyring", key)
+	}
+
+	if entity.PrivateKey.Encrypted {
+		passphrase := os.Getenv(signingPassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("the signing key is encrypted, set %s", signingPassphraseEnv)
+		}
+		if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt the signing key: %w", err)
+		}
+	}
+
+	return &gpgSigner{entity: entity}, nil
+}
+
+func (s *gpgSigner) Sign(message io.Reader) ([]byte, error) {
+	var b bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&b, s.entity, message, nil); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// true if key names the entity by key id, fingerprint or user id; an empty
+// key matches any entity
+func matchesGPGKey(e *openpgp.Entity, key string) bool {
+	key = strings.TrimSuffix(strings.TrimPrefix(key, "0x"), "!")
+	if key == "" {
+		return true
+	}
+
+	ids := []string{fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)}
+	for _, sub := range e.Subkeys {
+		ids = append(ids, fmt.Sprintf("%X", sub.PublicKey.Fingerprint))
+	}
+	for _, id := range ids {
+		if strings.HasSuffix(id, strings.ToUpper(key)) {
+			return true
+		}
+	}
+
+	for name := range e.Identities {
+		if strings.Contains(name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// reads the armored or binary keyring named by gpg_keyring in got's config,
+// nil if none is configured
+func loadGPGKeyring() (openpgp.EntityList, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.GPGKeyring == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(expandHome(cfg.GPGKeyring))
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the gpg keyring: %w", err)
+	}
+	return keyring, nil
+}
+
+// signs in git's ssh signature format, with a private key file or a key
+// held by the ssh agent
+type sshSigner struct {
+	signer   ssh.Signer
+	agentKey ssh.PublicKey // the key the ssh agent signs with, when signer is nil
+}
+
+// user.signingkey is a key file, the private key or its .pub, or a literal
+// "key::" public key held by the ssh agent
+func newSSHSigner(key string) (*sshSigner, error) {
+	if key == "" {
+		return nil, fmt.Errorf("ssh signing needs user.signingkey set")
+	}
+
+	var public ssh.PublicKey
+	if literal, ok := strings.CutPrefix(key, "key::"); ok {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(literal))
+		if err != nil {
+			return nil, fmt.Errorf("invalid user.signingkey: %w", err)
+		}
+		public = pub
+	} else {
+		file := expandHome(key)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey(data); err == nil {
+			public = pub
+			// prefer the private key next to the public one
+			file = strings.TrimSuffix(file, ".pub")
+			if data, err = os.ReadFile(file); err != nil {
+				data = nil
+			}
+		}
+		if data != nil {
+			if signer, err := parseSSHPrivateKey(data); err == nil {
+				return &sshSigner{signer: signer}, nil
+			} else if public == nil {
+				return nil, err
+			}
+		}
+	}
+
+	// the agent is asked again for each signature, this only checks it
+	// holds the key
+	_, conn, err := sshAgentSigner(public)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+	return &sshSigner{agentKey: public}, nil
+}
+
+// parses a private key, using the passphrase variable if it is encrypted
+func parseSSHPrivateKey(data []byte) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return signer, err
+	}
+
+	passphrase := os.Getenv(signingPassphraseEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("the signing key is encrypted, set %s or add it to the ssh agent", signingPassphraseEnv)
+	}
+	return ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+}
+
+// the ssh agent's signer for a public key, along with the connection to
+// the agent it signs over, which the caller closes when done
+func sshAgentSigner(public ssh.PublicKey) (ssh.Signer, io.Closer, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, fmt.Errorf("no private key for the signing key and no ssh agent running")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	for _, s := range signers {
+		if bytes.Equal(s.PublicKey().Marshal(), public.Marshal()) {
+			return s, conn, nil
+		}
+	}
+	conn.Close()
+	return nil, nil, fmt.Errorf("the ssh agent does not hold the signing key")
+}
+
+func (s *sshSigner) Sign(message io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := s.signer
+	if signer == nil {
+		agentSigner, conn, err := sshAgentSigner(s.agentKey)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		signer = agentSigner
+	}
+
+	digest := sha512.Sum512(data)
+	signed := sshSignedData("git", "sha512", digest[:])
+
+	var sig *ssh.Signature
+	if algSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// plain ssh-rsa signatures use sha1, which git rejects
+		sig, err = algSigner.SignWithAlgorithm(rand.Reader, signed, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var blob bytes.Buffer
+	blob.WriteString("SSHSIG")
+	binary.Write(&blob, binary.BigEndian, uint32(1))
+	writeSSHString(&blob, signer.PublicKey().Marshal())
+	writeSSHString(&blob, []byte("git"))
+	writeSSHString(&blob, nil)
+	writeSSHString(&blob, []byte("sha512"))
+	writeSSHString(&blob, ssh.Marshal(sig))
+
+	// armored like ssh-keygen -Y sign, 70 columns wide
+	encoded := base64.StdEncoding.EncodeToString(blob.Bytes())
+	var out strings.Builder
+	out.WriteString(sshSignatureBegin + "\n")
+	for len(encoded) > 70 {
+		out.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	out.WriteString(encoded + "\n" + sshSignatureEnd + "\n")
+	return []byte(out.String()), nil
+}
+
+// the data an ssh signature signs: the magic preamble, namespace, reserved
+// field, hash algorithm and message digest
+func sshSignedData(namespace, hashAlgorithm string, digest []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("SSHSIG")
+	writeSSHString(&b, []byte(namespace))
+	writeSSHString(&b, nil)
+	writeSSHString(&b, []byte(hashAlgorithm))
+	writeSSHString(&b, digest)
+	return b.Bytes()
+}
+
+func writeSSHString(b *bytes.Buffer, s []byte) {
+	binary.Write(b, binary.BigEndian, uint32(len(s)))
+	b.Write(s)
+}
+
+func readSSHString(b *bytes.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(b, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	if int(n) > b.Len() {
+		return nil, fmt.Errorf("truncated ssh signature")
+	}
+	s := make([]byte, n)
+	_, err := io.ReadFull(b, s)
+	return s, err
+}
+
+// checks commit signatures against the gpg keyring and the ssh allowed
+// signers file, loaded once for a whole page of history
+type signatureVerifier struct {
+	keyring openpgp.EntityList
+	signers []allowedSigner
+}
+
+// a line of gpg.ssh.allowedSignersFile
+type allowedSigner struct {
+	principals []string
+	key        ssh.PublicKey
+}
+
+// loads what is needed to verify signatures; missing keys make signatures
+// unverified rather than failing
+func newSignatureVerifier(r *git.Repository) *signatureVerifier {
+	v := &signatureVerifier{}
+	if keyring, err := loadGPGKeyring(); err == nil {
+		v.keyring = keyring
+	}
+	if file, err := gitConfigValue(r, "gpg", "ssh", "allowedSignersFile"); err == nil && file != "" {
+		if signers, err := loadAllowedSigners(expandHome(file)); err == nil {
+			v.signers = signers
+		}
+	}
+	return v
+}
+
+// returns whether a commit is unsigned, verified or signed but unverified
+func (v *signatureVerifier) check(c *object.Commit) string {
+	if c.PGPSignature == "" {
+		return signatureNone
+	}
+
+	encoded := &plumbing.MemoryObject{}
+	if err := c.EncodeWithoutSignature(encoded); err != nil {
+		return signatureUnverified
+	}
+	reader, err := encoded.Reader()
+	if err != nil {
+		return signatureUnverified
+	}
+	message, err := io.ReadAll(reader)
+	if err != nil {
+		return signatureUnverified
+	}
+
+	switch {
+	case strings.HasPrefix(c.PGPSignature, sshSignatureBegin):
+		if v.verifySSH(message, c.PGPSignature, c.Committer.Email) {
+			return signatureVerified
+		}
+	case strings.HasPrefix(c.PGPSignature, pgpSignatureBegin) && v.keyring != nil:
+		_, err := openpgp.CheckArmoredDetachedSignature(v.keyring, bytes.NewReader(message), strings.NewReader(c.PGPSignature), nil)
+		if err == nil {
+			return signatureVerified
+		}
+	}
+	return signatureUnverified
+}
+
+// verifies an armored ssh signature made in the git namespace by a key the
+// allowed signers file lists for the committer's email
+func (v *signatureVerifier) verifySSH(message []byte, armored, email string) bool {
+	body := strings.TrimSpace(armored)
+	body = strings.TrimPrefix(body, sshSignatureBegin)
+	body = strings.TrimSuffix(body, sshSignatureEnd)
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil || !bytes.HasPrefix(blob, []byte("SSHSIG")) {
+		return false
+	}
+
+	b := bytes.NewReader(blob[len("SSHSIG"):])
+	var version uint32
+	if err := binary.Read(b, binary.BigEndian, &version); err != nil || version != 1 {
+		return false
+	}
+	var fields [5][]byte
+	for i := range fields {
+		if fields[i], err = readSSHString(b); err != nil {
+			return false
+		}
+	}
+	publicBlob, namespace, hashAlgorithm, sigBlob := fields[0], string(fields[1]), string(fields[3]), fields[4]
+	if namespace != "git" {
+		return false
+	}
+
+	public, err := ssh.ParsePublicKey(publicBlob)
+	if err != nil || !v.allows(public, email) {
+		return false
+	}
+
+	var h hash.Hash
+	switch hashAlgorithm {
+	case "sha512":
+		h = sha512.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return false
+	}
+	h.Write(message)
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(sigBlob, &sig); err != nil {
+		return false
+	}
+	return public.Verify(sshSignedData(namespace, hashAlgorithm, h.Sum(nil)), &sig) == nil
+}
+
+// true if the allowed signers list the key for the email
+func (v *signatureVerifier) allows(key ssh.PublicKey, email string) bool {
+	for _, s := range v.signers {
+		if !bytes.Equal(s.key.Marshal(), key.Marshal()) {
+			continue
+		}
+		for _, principal := range s.principals {
+			if ok, _ := path.Match(principal, email); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parses an allowed signers file: principals, optional options and a
+// public key per line
+func loadAllowedSigners(file string) ([]allowedSigner, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var signers []allowedSigner
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		// the key follows the principals, or the options after them
+		for _, start := range []int{1, 2} {
+			if start >= len(fields) {
+				break
+			}
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[start:], " ")))
+			if err == nil {
+				signers = append(signers, allowedSigner{principals: strings.Split(fields[0], ","), key: key})
+				break
+			}
+		}
+	}
+	return signers, scanner.Err()
+}
+
+// expands a leading ~ to the home directory, as git does for paths
+func expandHome(p string) string {
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return p
+}
@@ -0,0 +1,727 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// stashes live in refs/stash and its reflog, the same way git keeps them,
+// so both tools see the same list
+const stashRef = plumbing.ReferenceName("refs/stash")
+
+var errNoLocalChanges = errors.New("no local changes to save")
+
+type StashEntry struct {
+	Index   int
+	Hash    plumbing.Hash
+	Message string
+	Branch  string
+	When    time.Time
+}
+
+// stash@{n} name of the entry, as git prints it
+func (s StashEntry) Name() string {
+	return fmt.Sprintf("stash@{%d}", s.Index)
+}
+
+// a line of the stash reflog
+type stashLogEntry struct {
+	old, new plumbing.Hash
+	who      string // "name <email> unix-time tz"
+	message  string
+}
+
+// returns the stashes, newest first
+func listStashes() ([]StashEntry, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	log, err := readStashLog(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var stashes []StashEntry
+	for i := len(log) - 1; i >= 0; i-- {
+		e := log[i]
+		stash := StashEntry{Index: len(log) - 1 - i, Hash: e.new, Message: e.message}
+
+		// "WIP on main: ..." or "On main: ..."
+		if rest, ok := strings.CutPrefix(e.message, "WIP on "); ok {
+			stash.Branch, _, _ = strings.Cut(rest, ":")
+		} else if rest, ok := strings.CutPrefix(e.message, "On "); ok {
+			stash.Branch, _, _ = strings.Cut(rest, ":")
+		}
+
+		if fields := strings.Fields(e.who); len(fields) >= 2 {
+			if sec, err := strconv.ParseInt(fields[len(fields)-2], 10, 64); err == nil {
+				stash.When = time.Unix(sec, 0)
+			}
+		}
+		stashes = append(stashes, stash)
+	}
+
+	return stashes, nil
+}
+
+// saves local changes as a new stash and reverts them: every tracked change,
+// plus untracked files when asked, or only the given paths
+func saveStash(message string, includeUntracked bool, paths []string) error {
+	r, w, err := openRepo()
+	if err != nil {
+		return err
+	}
+
+	head, err := r.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return fmt.Errorf("cannot stash before the first commit")
+	}
+	if err != nil {
+		return err
+	}
+	headCommit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	if unmerged, err := unmergedPaths(r); err != nil {
+		return err
+	} else if len(unmerged) > 0 {
+		return fmt.Errorf("resolve the conflicts first: %s", strings.Join(unmerged, ", "))
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return err
+	}
+
+	selected := map[string]bool{}
+	for _, path := range paths {
+		selected[path] = true
+	}
+
+	var tracked, untracked []string
+	for path, s := range status {
+		if len(paths) > 0 && !selected[path] {
+			continue
+		}
+		switch {
+		case s.Worktree == git.Untracked:
+			// explicitly selected untracked files are always taken
+			if includeUntracked || len(paths) > 0 {
+				untracked = append(untracked, path)
+			}
+		case s.Staging != git.Unmodified || s.Worktree != git.Unmodified:
+			tracked = append(tracked, path)
+		}
+	}
+	if len(tracked) == 0 && len(untracked) == 0 {
+		return errNoLocalChanges
+	}
+	sort.Strings(tracked)
+	sort.Strings(untracked)
+
+	headFiles, err := commitFiles(headCommit)
+	if err != nil {
+		return err
+	}
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	// the index commit holds the staged version of the stashed paths, the
+	// stash commit their worktree version
+	indexFiles := copyFiles(headFiles)
+	for _, path := range tracked {
+		delete(indexFiles, path)
+		if e, err := idx.Entry(path); err == nil {
+			indexFiles[path] = treeFile{Hash: e.Hash, Mode: e.Mode}
+		}
+	}
+	worktreeFiles := copyFiles(indexFiles)
+	for _, path := range tracked {
+		f, err := readWorktreeBlob(r, w, path)
+		if err != nil {
+			return err
+		}
+		delete(worktreeFiles, path)
+		if f != nil {
+			worktreeFiles[path] = *f
+		}
+	}
+
+	committer, err := committerSignature(r)
+	if err != nil {
+		return err
+	}
+	if committer == nil {
+		return fmt.Errorf("set user.name and user.email before stashing")
+	}
+
+	branch := "(no branch)"
+	if head.Name().IsBranch() {
+		branch = head.Name().Short()
+	}
+	subject := fmt.Sprintf("%s: %s %s", branch, head.Hash().String()[:7], newCommitInfo(headCommit).Subject)
+
+	indexCommit, err := writeStashCommit(r, indexFiles, "index on "+subject, committer, head.Hash())
+	if err != nil {
+		return err
+	}
+	parents := []plumbing.Hash{head.Hash(), indexCommit}
+
+	if len(untracked) > 0 {
+		untrackedFiles := map[string]treeFile{}
+		for _, path := range untracked {
+			f, err := readWorktreeBlob(r, w, path)
+			if err != nil {
+				return err
+			}
+			if f != nil {
+				untrackedFiles[path] = *f
+			}
+		}
+		untrackedCommit, err := writeStashCommit(r, untrackedFiles, "untracked files on "+subject, committer)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, untrackedCommit)
+	}
+
+	stashMessage := "WIP on " + subject
+	if message != "" {
+		stashMessage = "On " + branch + ": " + message
+	}
+	stash, err := writeStashCommit(r, worktreeFiles, stashMessage, committer, parents...)
+	if err != nil {
+		return err
+	}
+
+	if err := pushStash(r, stash, stashMessage, committer); err != nil {
+		return err
+	}
+
+	// the changes are safe in the stash, put the paths back as in HEAD
+	for _, path := range tracked {
+		removeIndexPath(idx, path)
+		f, ok := headFiles[path]
+		if !ok {
+			if err := w.Filesystem.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		content, err := readBlob(r, f.Hash)
+		if err != nil {
+			return err
+		}
+		if err := writeWorktreeFile(w, path, content, f.Mode); err != nil {
+			return err
+		}
+		e := idx.Add(path)
+		e.Hash = f.Hash
+		e.Mode = f.Mode
+		e.Size = uint32(len(content))
+	}
+	if err := r.Storer.SetIndex(idx); err != nil {
+		return err
+	}
+
+	for _, path := range untracked {
+		if err := w.Filesystem.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applies a stash on top of the current changes, keeping them unstaged like
+// git stash apply; a pop drops the stash unless it conflicted. returns the
+// conflicted paths
+func applyStash(index int, pop bool) ([]string, error) {
+	r, w, err := openRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := stashAt(r, index)
+	if err != nil {
+		return nil, err
+	}
+
+	stash, err := r.CommitObject(entry.new)
+	if err != nil {
+		return nil, err
+	}
+	if stash.NumParents() < 2 {
+		return nil, fmt.Errorf("%s is not a stash commit", entry.new.String()[:7])
+	}
+	base, err := stash.Parent(0)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	ours, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := commitChanges(stash)
+	if err != nil {
+		return nil, err
+	}
+	changed := map[string]bool{}
+	for _, change := range changes {
+		changed[change.From.Name] = true
+		changed[change.To.Name] = true
+	}
+	delete(changed, "")
+
+	// local changes survive as long as the stash does not touch them
+	dirty, err := dirtyPaths(w)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range dirty {
+		if changed[path] {
+			return nil, fmt.Errorf("your local changes to %s would be overwritten, commit or stash them first", path)
+		}
+	}
+
+	var untrackedFiles map[string]treeFile
+	if stash.NumParents() > 2 {
+		untrackedCommit, err := stash.Parent(2)
+		if err != nil {
+			return nil, err
+		}
+		if untrackedFiles, err = commitFiles(untrackedCommit); err != nil {
+			return nil, err
+		}
+		for path := range untrackedFiles {
+			if _, err := w.Filesystem.Lstat(path); err == nil {
+				return nil, fmt.Errorf("%s already exists, no checkout", path)
+			}
+		}
+	}
+
+	conflicts, err := mergeTrees(r, w, base, ours, stash, "Updated upstream", "Stashed changes")
+	if err != nil {
+		return nil, err
+	}
+
+	// the applied changes stay unstaged, except for new files
+	headFiles, err := commitFiles(ours)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	conflicted := map[string]bool{}
+	for _, path := range conflicts {
+		conflicted[path] = true
+	}
+	for path := range changed {
+		f, inHead := headFiles[path]
+		if conflicted[path] || !inHead {
+			continue
+		}
+		removeIndexPath(idx, path)
+		e := idx.Add(path)
+		e.Hash = f.Hash
+		e.Mode = f.Mode
+	}
+	if err := r.Storer.SetIndex(idx); err != nil {
+		return nil, err
+	}
+
+	for path, f := range untrackedFiles {
+		content, err := readBlob(r, f.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeWorktreeFile(w, path, content, f.Mode); err != nil {
+			return nil, err
+		}
+	}
+
+	if pop && len(conflicts) == 0 {
+		if err := dropStash(index); err != nil {
+			return nil, err
+		}
+	}
+	// a conflicted pop keeps the stash until the conflicts are resolved
+	if pop && len(conflicts) > 0 {
+		if err := writeStashPop(r, entry.new); err != nil {
+			return nil, err
+		}
+	}
+
+	return conflicts, nil
+}
+
+// the file naming the stash a conflicted pop will drop once resolved
+func stashPopPath(r *git.Repository) (string, error) {
+	dir, err := gitDir(r)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "got", "stash-pop"), nil
+}
+
+func writeStashPop(r *git.Repository, stash plumbing.Hash) error {
+	path, err := stashPopPath(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(stash.String()+"\n"), 0644)
+}
+
+// the stash of a conflicted pop, zero if there is none
+func readStashPop(r *git.Repository) (plumbing.Hash, error) {
+	path, err := stashPopPath(r)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return plumbing.NewHash(strings.TrimSpace(string(data))), nil
+}
+
+// finishes a pop that conflicted once every conflict is resolved, dropping
+// its stash; returns the name the stash had, "" if no pop was pending or
+// the stash is already gone
+func concludeStashPop() (string, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return "", err
+	}
+
+	stash, err := readStashPop(r)
+	if err != nil || stash.IsZero() {
+		return "", err
+	}
+	if unmerged, err := unmergedPaths(r); err != nil {
+		return "", err
+	} else if len(unmerged) > 0 {
+		return "", fmt.Errorf("resolve the remaining conflicts first: %s", strings.Join(unmerged, ", "))
+	}
+
+	log, err := readStashLog(r)
+	if err != nil {
+		return "", err
+	}
+	name := ""
+	for i, e := range log {
+		if e.new == stash {
+			index := len(log) - 1 - i
+			if err := dropStash(index); err != nil {
+				return "", err
+			}
+			name = fmt.Sprintf("stash@{%d}", index)
+			break
+		}
+	}
+
+	path, err := stashPopPath(r)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return name, nil
+}
+
+// removes a stash from the list
+func dropStash(index int) error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	log, err := readStashLog(r)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= len(log) {
+		return fmt.Errorf("stash@{%d} does not exist", index)
+	}
+
+	log = append(log[:len(log)-1-index], log[len(log)-index:]...)
+	if len(log) == 0 {
+		if err := r.Storer.RemoveReference(stashRef); err != nil {
+			return err
+		}
+		path, err := stashLogPath(r)
+		if err != nil {
+			return err
+		}
+		return os.Remove(path)
+	}
+
+	if err := r.Storer.SetReference(plumbing.NewHashReference(stashRef, log[len(log)-1].new)); err != nil {
+		return err
+	}
+	return writeStashLog(r, log)
+}
+
+// the diff of a stash against the commit it was made on, untracked files
+// included
+func getStashDiff(index int) ([]*FileDiff, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := stashAt(r, index)
+	if err != nil {
+		return nil, err
+	}
+	stash, err := r.CommitObject(entry.new)
+	if err != nil {
+		return nil, err
+	}
+
+	detail, err := getCommitDetail(stash.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []*FileDiff
+	for _, file := range detail.Files {
+		d, err := getCommitFileDiff(stash.Hash, file)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, d)
+	}
+
+	if stash.NumParents() > 2 {
+		untrackedCommit, err := stash.Parent(2)
+		if err != nil {
+			return nil, err
+		}
+		files, err := commitFiles(untrackedCommit)
+		if err != nil {
+			return nil, err
+		}
+		var paths []string
+		for path := range files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			content, err := readBlob(r, files[path].Hash)
+			if err != nil {
+				return nil, err
+			}
+			d := &FileDiff{Path: path, Status: "untracked"}
+			if isBinary(content) {
+				d.Binary = true
+			} else {
+				d.Hunks = computeHunks("", content, diffContext)
+			}
+			diffs = append(diffs, d)
+		}
+	}
+
+	return diffs, nil
+}
+
+// copies a flattened tree so it can be changed
+func copyFiles(files map[string]treeFile) map[string]treeFile {
+	out := make(map[string]treeFile, len(files))
+	for path, f := range files {
+		out[path] = f
+	}
+	return out
+}
+
+// stores a worktree file as a blob, nil if the file does not exist
+func readWorktreeBlob(r *git.Repository, w *git.Worktree, path string) (*treeFile, error) {
+	content, mode, ok, err := readWorktreeContent(w, path)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	hash, err := writeBlob(r, content)
+	if err != nil {
+		return nil, err
+	}
+	return &treeFile{Hash: hash, Mode: mode}, nil
+}
+
+// reads a worktree file with the mode git would record for it, a symlink
+// as its target; ok is false if the file does not exist
+func readWorktreeContent(w *git.Worktree, path string) (string, filemode.FileMode, bool, error) {
+	info, err := w.Filesystem.Lstat(path)
+	if os.IsNotExist(err) {
+		return "", 0, false, nil
+	}
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	var content string
+	mode := filemode.Regular
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		mode = filemode.Symlink
+		if content, err = w.Filesystem.Readlink(path); err != nil {
+			return "", 0, false, err
+		}
+	default:
+		if info.Mode()&0111 != 0 {
+			mode = filemode.Executable
+		}
+		if content, err = readWorktreeFile(w, path); err != nil {
+			return "", 0, false, err
+		}
+	}
+	return content, mode, true, nil
+}
+
+// writes the tree of files and a commit of it for a stash
+func writeStashCommit(r *git.Repository, files map[string]treeFile, message string, sig *object.Signature, parents ...plumbing.Hash) (plumbing.Hash, error) {
+	tree, err := buildTree(r, files)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return writeCommit(r, &object.Commit{
+		Author:       *sig,
+		Committer:    *sig,
+		Message:      message + "\n",
+		TreeHash:     tree,
+		ParentHashes: parents,
+	})
+}
+
+// makes a stash commit the newest stash
+func pushStash(r *git.Repository, stash plumbing.Hash, message string, sig *object.Signature) error {
+	log, err := readStashLog(r)
+	if err != nil {
+		return err
+	}
+
+	old := plumbing.ZeroHash
+	if len(log) > 0 {
+		old = log[len(log)-1].new
+	}
+	_, offset := sig.When.Zone()
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	who := fmt.Sprintf("%s <%s> %d %s%02d%02d", sig.Name, sig.Email, sig.When.Unix(), sign, offset/3600, offset%3600/60)
+	log = append(log, stashLogEntry{old: old, new: stash, who: who, message: message})
+
+	if err := r.Storer.SetReference(plumbing.NewHashReference(stashRef, stash)); err != nil {
+		return err
+	}
+	return writeStashLog(r, log)
+}
+
+// the reflog entry of stash@{index}
+func stashAt(r *git.Repository, index int) (stashLogEntry, error) {
+	log, err := readStashLog(r)
+	if err != nil {
+		return stashLogEntry{}, err
+	}
+	if index < 0 || index >= len(log) {
+		return stashLogEntry{}, fmt.Errorf("stash@{%d} does not exist", index)
+	}
+	return log[len(log)-1-index], nil
+}
+
+func stashLogPath(r *git.Repository) (string, error) {
+	dir, err := gitDir(r)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "logs", "refs", "stash"), nil
+}
+
+// reads the stash reflog, oldest first
+func readStashLog(r *git.Repository) ([]stashLogEntry, error) {
+	path, err := stashLogPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var log []stashLogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// "<old> <new> <name> <<email>> <time> <tz>\t<message>"
+		head, message, _ := strings.Cut(scanner.Text(), "\t")
+		fields := strings.SplitN(head, " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		log = append(log, stashLogEntry{
+			old:     plumbing.NewHash(fields[0]),
+			new:     plumbing.NewHash(fields[1]),
+			who:     fields[2],
+			message: message,
+		})
+	}
+	return log, scanner.Err()
+}
+
+// rewrites the stash reflog
+func writeStashLog(r *git.Repository, log []stashLogEntry) error {
+	path, err := stashLogPath(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for _, e := range log {
+		fmt.Fprintf(&b, "%s %s %s\t%s\n", e.old, e.new, e.who, e.message)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type TagInfo struct {
+	Name      string
+	Target    plumbing.Hash // the tagged commit
+	Subject   string        // subject of the tagged commit
+	Annotated bool
+	Tagger    string // annotated tags only
+	When      time.Time
+	Message   string
+}
+
+// abbreviated hash of the tagged commit
+func (t TagInfo) ShortHash() string {
+	return t.Target.String()[:7]
+}
+
+// returns every tag sorted by name, with the commit it points at
+func listTags() ([]TagInfo, error) {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []TagInfo
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		t := TagInfo{Name: ref.Name().Short(), Target: ref.Hash()}
+
+		// annotated tags point at a tag object, which points at the commit
+		if tag, err := r.TagObject(ref.Hash()); err == nil {
+			t.Annotated = true
+			t.Target = tag.Target
+			t.Tagger = tag.Tagger.Name
+			t.When = tag.Tagger.When
+			t.Message = strings.TrimSpace(tag.Message)
+		}
+
+		if c, err := r.CommitObject(t.Target); err == nil {
+			t.Subject = newCommitInfo(c).Subject
+			if !t.Annotated {
+				t.When = c.Committer.When
+			}
+		}
+
+		tags = append(tags, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+// tags a revision, lightweight when message is empty and annotated otherwise
+func createTag(name, rev, message string) error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	target, err := resolveCommit(r, rev)
+	if err != nil {
+		return err
+	}
+
+	refName := plumbing.NewTagReferenceName(name)
+	if err := refName.Validate(); err != nil || strings.HasSuffix(name, "/") {
+		return fmt.Errorf("invalid tag name %q", name)
+	}
+
+	var opts *git.CreateTagOptions
+	if strings.TrimSpace(message) != "" {
+		opts = &git.CreateTagOptions{Message: message}
+
+		// go-git signs tags only with an openpgp entity, so a signed tag
+		// object is written by hand
+		signer, err := tagSigner(r)
+		if err != nil {
+			return err
+		}
+		if signer != nil {
+			return createSignedTag(r, refName, target, message, signer)
+		}
+	}
+
+	_, err = r.CreateTag(name, target, opts)
+	if errors.Is(err, git.ErrTagExists) {
+		return fmt.Errorf("tag %s already exists", name)
+	}
+	return err
+}
+
+// writes an annotated tag object signed by signer and points the tag at it
+func createSignedTag(r *git.Repository, refName plumbing.ReferenceName, target plumbing.Hash, message string, signer git.Signer) error {
+	if _, err := r.Storer.Reference(refName); err == nil {
+		return fmt.Errorf("tag %s already exists", refName.Short())
+	}
+
+	tagger, err := committerSignature(r)
+	if err != nil {
+		return err
+	}
+	if tagger == nil {
+		return fmt.Errorf("set user.name and user.email to sign tags")
+	}
+
+	tag := &object.Tag{
+		Name:       refName.Short(),
+		Tagger:     *tagger,
+		Message:    strings.TrimRight(message, "\n") + "\n",
+		TargetType: plumbing.CommitObject,
+		Target:     target,
+	}
+
+	unsigned := r.Storer.NewEncodedObject()
+	if err := tag.EncodeWithoutSignature(unsigned); err != nil {
+		return err
+	}
+	reader, err := unsigned.Reader()
+	if err != nil {
+		return err
+	}
+	signature, err := signer.Sign(reader)
+	if err != nil {
+		return fmt.Errorf("failed to sign tag: %w", err)
+	}
+	tag.PGPSignature = string(signature)
+
+	obj := r.Storer.NewEncodedObject()
+	if err := tag.Encode(obj); err != nil {
+		return err
+	}
+	hash, err := r.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return err
+	}
+	return r.Storer.SetReference(plumbing.NewHashReference(refName, hash))
+}
+
+// deletes a local tag
+func deleteTag(name string) error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+	return r.DeleteTag(name)
+}
+
+// pushes one tag to the remote the current branch syncs with
+func pushTag(name string, progress io.Writer) error {
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	remoteName, _, err := pushTarget(r)
+	if err != nil {
+		return err
+	}
+
+	auth, err := remoteAuth(r, remoteName)
+	if err != nil {
+		return err
+	}
+
+	// go-git rejects a changed tag with an untyped error, so the remote's
+	// tag is checked first
+	local, err := r.Reference(plumbing.NewTagReferenceName(name), false)
+	if err != nil {
+		return err
+	}
+	remoteHash, err := remoteRefHash(r, remoteName, auth, local.Name())
+	if err != nil {
+		return err
+	}
+	if remoteHash == local.Hash() {
+		return nil
+	}
+	if !remoteHash.IsZero() {
+		return fmt.Errorf("%w: the remote already has a different tag %s", errNonFastForward, name)
+	}
+
+	ref := local.Name().String()
+	err = r.Push(&git.PushOptions{
+		RemoteName: remoteName,
+		RefSpecs:   []config.RefSpec{config.RefSpec(ref + ":" + ref)},
+		Progress:   progress,
+		Auth:       auth,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	return remoteError(err)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestPushTag(t *testing.T) {
+	url, remote := newBareRemote(t)
+
+	firstDir, first := cloneTestRemote(t, url)
+	commitTestFile(t, first, "a.txt", "one\n")
+	if err := pushRemote(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := createTag("v1", "HEAD", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := pushTag("v1", io.Discard); err != nil {
+		t.Fatalf("push tag: %v", err)
+	}
+	v1 := plumbing.NewTagReferenceName("v1")
+	if got, want := refHash(t, remote, v1), refHash(t, first, v1); got != want {
+		t.Fatalf("remote v1 is %s, want %s", got, want)
+	}
+	if err := pushTag("v1", io.Discard); err != nil {
+		t.Fatalf("pushing the same tag again: %v", err)
+	}
+
+	// the same tag name on another commit
+	_, second := cloneTestRemote(t, url)
+	commitTestFile(t, second, "b.txt", "two\n")
+	if err := second.DeleteTag("v1"); err != nil && !errors.Is(err, git.ErrTagNotFound) {
+		t.Fatal(err)
+	}
+	if err := createTag("v1", "HEAD", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := pushTag("v1", io.Discard); !errors.Is(err, errNonFastForward) {
+		t.Fatalf("pushing a different v1 returned %v, want %v", err, errNonFastForward)
+	}
+
+	t.Chdir(firstDir)
+	if got, want := refHash(t, remote, v1), refHash(t, first, v1); got != want {
+		t.Fatalf("rejected tag push moved remote v1 to %s", got)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// trailers the commit form has fields for
+const (
+	trailerBreaking  = "BREAKING CHANGE"
+	trailerCloses    = "Closes"
+	trailerRefs      = "Refs"
+	trailerCoAuthor  = "Co-authored-by"
+	trailerSignedOff = "Signed-off-by"
+)
+
+// an issue reference: #12, 12 or owner/repo#12
+var issueRefPattern = regexp.MustCompile(`^([\w.-]+/[\w.-]+)?#?(\d+)$`)
+
+// the footer of a commit message
+type Trailers struct {
+	Breaking  string   // description of a breaking change
+	Closes    []string // issues the commit closes, e.g. "#12"
+	Refs      []string // issues the commit relates to
+	CoAuthors []string // "Name <email>"
+	SignOff   string   // "Name <email>" certifying the change, "" for none
+	Other     []string // trailer lines the form has no field for, kept as is
+}
+
+// adds the trailers to a message, in the order git tools expect them with
+// the sign-off last; the breaking change gets its own paragraph before them,
+// as its key is not one git accepts in a trailer block
+func (t Trailers) apply(message string) string {
+	if t.Breaking != "" {
+		message = strings.TrimRight(message, "\n") + "\n\n" + trailerBreaking + ": " + t.Breaking
+	}
+
+	var lines []string
+	lines = append(lines, t.Other...)
+	for _, issue := range t.Closes {
+		lines = append(lines, trailerCloses+": "+issue)
+	}
+	for _, issue := range t.Refs {
+		lines = append(lines, trailerRefs+": "+issue)
+	}
+	for _, author := range t.CoAuthors {
+		lines = append(lines, trailerCoAuthor+": "+author)
+	}
+	if t.SignOff != "" {
+		lines = append(lines, trailerSignedOff+": "+t.SignOff)
+	}
+
+	for _, line := range lines {
+		message = appendTrailer(message, line)
+	}
+	return message
+}
+
+// splits the trailer block and breaking change note off the end of a
+// message body; signOff is the identity whose sign-off the form's checkbox
+// stands for
+func splitTrailers(body, signOff string) (string, Trailers) {
+	var t Trailers
+	paragraphs := strings.Split(strings.TrimRight(body, "\n"), "\n\n")
+
+	if last := paragraphs[len(paragraphs)-1]; isTrailerBlock(last) {
+		t = parseTrailerBlock(last, signOff)
+		paragraphs = paragraphs[:len(paragraphs)-1]
+	}
+
+	if n := len(paragraphs); n > 0 {
+		for _, key := range []string{trailerBreaking, "BREAKING-CHANGE"} {
+			if note, ok := strings.CutPrefix(paragraphs[n-1], key+": "); ok && t.Breaking == "" {
+				t.Breaking = note
+				paragraphs = paragraphs[:n-1]
+				break
+			}
+		}
+	}
+	return strings.Join(paragraphs, "\n\n"), t
+}
+
+// sorts the lines of a trailer block into the form's fields
+func parseTrailerBlock(block, signOff string) Trailers {
+	var t Trailers
+	for _, line := range strings.Split(block, "\n") {
+		key, value, _ := strings.Cut(line, ": ")
+		switch {
+		case key == "BREAKING-CHANGE":
+			t.Breaking = value
+		case strings.EqualFold(key, trailerCloses):
+			t.Closes = append(t.Closes, value)
+		case strings.EqualFold(key, trailerRefs):
+			t.Refs = append(t.Refs, value)
+		case strings.EqualFold(key, trailerCoAuthor):
+			t.CoAuthors = append(t.CoAuthors, value)
+		case strings.EqualFold(key, trailerSignedOff) && value == signOff:
+			t.SignOff = value
+		default:
+			t.Other = append(t.Other, line)
+		}
+	}
+	return t
+}
+
+// parses a comma or space separated list of issue references, writing
+// bare numbers as #N
+func parseIssueRefs(s string) ([]string, error) {
+	var refs []string
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		m := issueRefPattern.FindStringSubmatch(field)
+		if m == nil {
+			return nil, fmt.Errorf("%q is not an issue reference like #12 or owner/repo#12", field)
+		}
+		refs = append(refs, m[1]+"#"+m[2])
+	}
+	return refs, nil
+}
+
+// adds a line to the trailer block ending a message, starting one after a
+// blank line if the message has none
+func appendTrailer(message, trailer string) string {
+	message = strings.TrimRight(message, "\n")
+
+	paragraphs := strings.Split(message, "\n\n")
+	last := paragraphs[len(paragraphs)-1]
+	if len(paragraphs) > 1 && isTrailerBlock(last) {
+		return message + "\n" + trailer
+	}
+	return message + "\n\n" + trailer
+}
+
+// true if every line of a paragraph looks like "Key: value" or a
+// "(cherry picked from ...)" note
+func isTrailerBlock(paragraph string) bool {
+	for _, line := range strings.Split(paragraph, "\n") {
+		if strings.HasPrefix(line, "(cherry picked from commit ") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok || key == "" || strings.ContainsAny(key, " \t") || strings.TrimSpace(value) == "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testSignOff = "Test <test@example.com>"
+
+func TestParseTrailerBlock(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  Trailers
+	}{
+		{
+			name:  "every field",
+			block: "Closes: #1\nRefs: owner/repo#2\nCo-authored-by: A <a@example.com>\nSigned-off-by: " + testSignOff,
+			want: Trailers{
+				Closes:    []string{"#1"},
+				Refs:      []string{"owner/repo#2"},
+				CoAuthors: []string{"A <a@example.com>"},
+				SignOff:   testSignOff,
+			},
+		},
+		{
+			name:  "keys in any case",
+			block: "closes: #1\nREFS: #2\nco-authored-by: A <a@example.com>",
+			want: Trailers{
+				Closes:    []string{"#1"},
+				Refs:      []string{"#2"},
+				CoAuthors: []string{"A <a@example.com>"},
+			},
+		},
+		{
+			name:  "repeated keys",
+			block: "Closes: #1\nCloses: #2\nCo-authored-by: A <a@example.com>\nCo-authored-by: B <b@example.com>",
+			want: Trailers{
+				Closes:    []string{"#1", "#2"},
+				CoAuthors: []string{"A <a@example.com>", "B <b@example.com>"},
+			},
+		},
+		{
+			name:  "someone else's sign-off",
+			block: "Signed-off-by: Other <other@example.com>\nSigned-off-by: " + testSignOff,
+			want: Trailers{
+				SignOff: testSignOff,
+				Other:   []string{"Signed-off-by: Other <other@example.com>"},
+			},
+		},
+		{
+			name:  "trailers without a field",
+			block: "Reviewed-by: R <r@example.com>\n(cherry picked from commit abc)",
+			want:  Trailers{Other: []string{"Reviewed-by: R <r@example.com>", "(cherry picked from commit abc)"}},
+		},
+		{
+			name:  "a breaking change in trailer form",
+			block: "BREAKING-CHANGE: the api changed\nRefs: #3",
+			want:  Trailers{Breaking: "the api changed", Refs: []string{"#3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseTrailerBlock(tt.block, testSignOff); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTrailerBlock = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitTrailers(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantBody string
+		want     Trailers
+	}{
+		{
+			name:     "no trailers",
+			body:     "explains the change\n",
+			wantBody: "explains the change",
+		},
+		{
+			name:     "a trailer block",
+			body:     "explains the change\n\nRefs: #1\nSigned-off-by: " + testSignOff + "\n",
+			wantBody: "explains the change",
+			want:     Trailers{Refs: []string{"#1"}, SignOff: testSignOff},
+		},
+		{
+			name:     "only trailers",
+			body:     "Closes: #4",
+			wantBody: "",
+			want:     Trailers{Closes: []string{"#4"}},
+		},
+		{
+			name:     "a breaking change before the trailers",
+			body:     "explains the change\n\nBREAKING CHANGE: config moved\n\nCloses: #1",
+			wantBody: "explains the change",
+			want:     Trailers{Breaking: "config moved", Closes: []string{"#1"}},
+		},
+		{
+			name:     "a breaking change without trailers",
+			body:     "explains the change\n\nBREAKING CHANGE: config moved",
+			wantBody: "explains the change",
+			want:     Trailers{Breaking: "config moved"},
+		},
+		{
+			name:     "a colon in the last paragraph of prose",
+			body:     "first paragraph\n\nnote: this is prose\nthat goes on",
+			wantBody: "first paragraph\n\nnote: this is prose\nthat goes on",
+		},
+		{
+			name:     "trailers in an earlier paragraph",
+			body:     "Refs: #1\n\nexplains the change",
+			wantBody: "Refs: #1\n\nexplains the change",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, got := splitTrailers(tt.body, testSignOff)
+			if body != tt.wantBody {
+				t.Errorf("body is %q, want %q", body, tt.wantBody)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("trailers are %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// trailers written by apply are read back by splitTrailers
+func TestTrailersRoundTrip(t *testing.T) {
+	want := Trailers{
+		Breaking:  "the api changed",
+		Closes:    []string{"#1"},
+		Refs:      []string{"owner/repo#2"},
+		CoAuthors: []string{"A <a@example.com>"},
+		SignOff:   testSignOff,
+		Other:     []string{"Reviewed-by: R <r@example.com>"},
+	}
+	message := want.apply("explains the change")
+
+	body, got := splitTrailers(message, testSignOff)
+	if body != "explains the change" {
+		t.Errorf("body is %q in %q", body, message)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("trailers are %+v, want %+v, from %q", got, want, message)
+	}
+}
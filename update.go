@@ -1,7 +1,15 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
 type initCompleteMsg struct {
@@ -30,15 +38,72 @@ type createBranchErrorMsg struct {
 
 type switchBranchCompleteMsg struct {
 	branchName string
+	conflicts  []string // left by reapplying stashed changes
 }
 
 type switchBranchErrorMsg struct {
 	err error
 }
 
+type remoteProgressMsg struct {
+	line    string
+	replace bool
+}
+
+type remoteCompleteMsg struct {
+	op string
+}
+
+type remoteErrorMsg struct {
+	op  string
+	err error
+}
+
+type hookOutputMsg struct {
+	line    string
+	replace bool
+}
+
+type hookCompleteMsg struct {
+	op  string
+	err error
+}
+
+type editorFinishedMsg struct {
+	path string
+	err  error
+}
+
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		return m, nil
+
 	case tea.KeyMsg:
+		if m.showCommitDetail {
+			return m.updateCommitDetail(msg)
+		}
+		if m.showLog {
+			return m.updateLog(msg)
+		}
+		if m.showConflicts {
+			return m.updateConflicts(msg)
+		}
+		if m.showRebase {
+			return m.updateRebase(msg)
+		}
+		if m.showStash {
+			return m.updateStash(msg)
+		}
+		if m.showTags {
+			return m.updateTags(msg)
+		}
+		if m.showHooks {
+			return m.updateHooks(msg)
+		}
+
 		switch msg.String() {
 		case "1":
 			if m.showInitMenu {
@@ -64,14 +129,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				selectedBranch := m.branches[m.branchListCursor]
 				if selectedBranch != m.currentBranch {
 					m.showBranchList = false
-					m.switchingBranch = true
-					return m, tea.Cmd(func() tea.Msg {
-						err := switchBranch(selectedBranch)
-						if err != nil {
-							return switchBranchErrorMsg{err: err}
-						}
-						return switchBranchCompleteMsg{branchName: selectedBranch}
-					})
+					return m, m.switchTo(selectedBranch)
 				}
 				return m, nil
 			}
@@ -120,6 +178,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.showBranchList = false
 				return m, nil
 			}
+			if m.diffFocused {
+				m.diffFocused = false
+				return m, nil
+			}
 		case "ctrl+c", "q":
 			m.quitting = true
 			return m, tea.Quit
@@ -129,8 +191,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if m.branchListCursor > 0 {
 					m.branchListCursor--
 				}
+			} else if m.diffFocused && m.lineMode {
+				m.moveLineCursor(-1)
+			} else if m.diffFocused {
+				m.moveHunkCursor(-1)
 			} else if m.cursor > 0 {
 				m.cursor--
+				m.loadDiff()
 			}
 
 		case "down", "j":
@@ -138,25 +205,78 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if m.branchListCursor < len(m.branches)-1 {
 					m.branchListCursor++
 				}
+			} else if m.diffFocused && m.lineMode {
+				m.moveLineCursor(1)
+			} else if m.diffFocused {
+				m.moveHunkCursor(1)
 			} else if m.cursor < len(m.files)-1 {
 				m.cursor++
+				m.loadDiff()
+			}
+
+		case "ctrl+d":
+			m.scrollDiff(m.diffPaneHeight() / 2)
+
+		case "ctrl+u":
+			m.scrollDiff(-m.diffPaneHeight() / 2)
+
+		case "tab":
+			if !m.showInitMenu && !m.showBranchMenu && !m.showBranchList && len(m.files) > 0 {
+				m.diffFocused = !m.diffFocused
+			}
+
+		case "v":
+			if m.diffFocused {
+				m.lineMode = !m.lineMode
+				if m.lineMode {
+					m.lineCursor = -1
+					m.moveLineCursor(1)
+				} else {
+					m.moveHunkCursor(0)
+				}
 			}
 
 		case " ":
-			if m.cursor < len(m.files) {
+			if m.diffFocused {
+				m.toggleDiffSelection()
+			} else if m.cursor < len(m.files) {
 				m.files[m.cursor].Selected = !m.files[m.cursor].Selected
 			}
 
 		case "s":
-			m.stageSelectedFiles()
+			if m.diffFocused {
+				m.stageChosenHunks()
+			} else {
+				m.stageSelectedFiles()
+			}
 			m.refreshFiles()
 
 		case "u":
-			m.unstageSelectedFiles()
+			if m.showBranchList && m.branchListCursor < len(m.branches) {
+				m.setUpstreamForm(m.branches[m.branchListCursor])
+				return m, nil
+			}
+			if m.diffFocused {
+				m.unstageChosenHunks()
+			} else {
+				m.unstageSelectedFiles()
+			}
 			m.refreshFiles()
 
 		case "c":
-			m.commitChanges()
+			if cmd := m.commitChanges(nil); cmd != nil {
+				return m, cmd
+			}
+
+		case "C":
+			if !m.showInitMenu && !m.showGitHubAuth && !m.creatingRepo && !m.initingRepo && !m.showBranchMenu && !m.showBranchList {
+				return m, m.commitSelected()
+			}
+
+		case "a":
+			if !m.showInitMenu && !m.showGitHubAuth && !m.creatingRepo && !m.initingRepo && !m.showBranchMenu && !m.showBranchList {
+				return m, m.amendLastCommit()
+			}
 
 		case "b":
 			if !m.showInitMenu && !m.showGitHubAuth && !m.creatingRepo && !m.initingRepo {
@@ -164,6 +284,17 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 
+		case "f", "p", "P":
+			if !m.showInitMenu && !m.showGitHubAuth && !m.creatingRepo && !m.initingRepo && !m.showBranchMenu && !m.showBranchList && m.remoteOp == "" {
+				return m, m.startRemoteOp(msg.String())
+			}
+
+		case "l":
+			if !m.showInitMenu && !m.showGitHubAuth && !m.creatingRepo && !m.initingRepo && !m.showBranchMenu && !m.showBranchList {
+				m.openLog()
+				return m, nil
+			}
+
 		case "3":
 			if m.showBranchMenu {
 				m.showBranchMenu = false
@@ -174,6 +305,100 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				m.branches = branches
 				m.branchListCursor = 0
+				m.loadBranchUpstreams()
+				return m, nil
+			}
+
+		case "d":
+			if m.showBranchList && m.branchListCursor < len(m.branches) {
+				m.deleteBranchAtCursor()
+				return m, nil
+			}
+			if !m.showInitMenu && !m.showGitHubAuth && !m.creatingRepo && !m.initingRepo && !m.showBranchMenu && !m.showBranchList {
+				if m.diffFocused {
+					m.discardChosenHunks()
+				} else {
+					m.discardSelectedFiles()
+				}
+				m.refreshFiles()
+				return m, nil
+			}
+
+		case "r":
+			if m.showBranchList && m.branchListCursor < len(m.branches) {
+				m.renameBranchAtCursor()
+				return m, nil
+			}
+
+		case "R":
+			if m.showBranchList && m.branchListCursor < len(m.branches) {
+				m.resetBranchAtCursor()
+				return m, nil
+			}
+
+		case "m":
+			if m.showBranchList && m.branchListCursor < len(m.branches) {
+				m.mergeBranchAtCursor()
+				return m, nil
+			}
+
+		case "M":
+			if (m.merging || m.pick != nil || len(m.conflicts) > 0) && !m.showInitMenu && !m.showBranchMenu && !m.showBranchList {
+				m.openConflicts()
+				return m, nil
+			}
+
+		case "D":
+			if !m.showInitMenu && !m.showGitHubAuth && !m.creatingRepo && !m.initingRepo && !m.showBranchMenu && !m.showBranchList {
+				m.restoreLastDiscard()
+				return m, nil
+			}
+
+		case "z":
+			if !m.showInitMenu && !m.showGitHubAuth && !m.creatingRepo && !m.initingRepo && !m.showBranchMenu && !m.showBranchList {
+				m.openStashList()
+				return m, nil
+			}
+
+		case "U":
+			if m.showBranchList && m.branchListCursor < len(m.branches) {
+				m.unsetUpstreamOf(m.branches[m.branchListCursor])
+				return m, nil
+			}
+			if !m.showInitMenu && !m.showGitHubAuth && !m.creatingRepo && !m.initingRepo && !m.showBranchMenu && !m.showBranchList {
+				m.undoLastReset()
+				return m, nil
+			}
+
+		case "t":
+			if !m.showInitMenu && !m.showGitHubAuth && !m.creatingRepo && !m.initingRepo && !m.showBranchMenu && !m.showBranchList {
+				m.openTags()
+				return m, nil
+			}
+
+		case "I":
+			if !m.showInitMenu && !m.showGitHubAuth && !m.creatingRepo && !m.initingRepo && !m.showBranchMenu && !m.showBranchList {
+				m.switchProfile()
+				return m, nil
+			}
+
+		case "i":
+			if !m.showInitMenu && !m.showGitHubAuth && !m.creatingRepo && !m.initingRepo && !m.showBranchMenu && !m.showBranchList {
+				m.openRebase()
+				return m, nil
+			}
+
+		case "4":
+			if m.showBranchMenu {
+				m.showBranchMenu = false
+				m.setUpstreamForm(m.currentBranch)
+				return m, nil
+			}
+
+		case "5":
+			if m.showBranchMenu {
+				m.showBranchMenu = false
+				m.unsetUpstreamOf(m.currentBranch)
 				return m, nil
 			}
 		}
@@ -183,6 +408,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case initCompleteMsg:
 		m.initingRepo = false
 		m.files = msg.files
+		m.loadDiff()
 		currentBranch, err := getCurrentBranch()
 		if err == nil {
 			m.currentBranch = currentBranch
@@ -201,6 +427,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			files = []FileStatus{}
 		}
 		m.files = files
+		m.loadDiff()
 		// set current branch after repo creation
 		currentBranch, err := getCurrentBranch()
 		if err == nil {
@@ -221,6 +448,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.currentBranch = currentBranch
 		}
 		m.refreshFiles()
+		m.refreshUpstream()
 		return m, nil
 	case createBranchErrorMsg:
 		m.creatingBranch = false
@@ -234,96 +462,1997 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.currentBranch = msg.branchName
 		}
 		m.refreshFiles()
+		m.refreshUpstream()
+		if len(msg.conflicts) > 0 {
+			m.setError(fmt.Sprintf("switched to %s, reapplying your changes left %d conflict(s), resolve them and press c to drop stash@{0}", m.currentBranch, len(msg.conflicts)))
+			m.openConflicts()
+		} else {
+			m.setStatus("switched to " + m.currentBranch)
+		}
 		return m, nil
 	case switchBranchErrorMsg:
 		m.switchingBranch = false
-		// TODO: show error message
+		// a stash may have been made or the branch changed before the error
+		if currentBranch, err := getCurrentBranch(); err == nil {
+			m.currentBranch = currentBranch
+		}
+		m.refreshFiles()
+		m.refreshUpstream()
+		m.setError("switch failed: " + msg.err.Error())
+		return m, nil
+	case remoteProgressMsg:
+		if msg.replace && len(m.remoteProgress) > 0 {
+			m.remoteProgress[len(m.remoteProgress)-1] = msg.line
+		} else {
+			m.remoteProgress = append(m.remoteProgress, msg.line)
+		}
+		return m, waitForRemote(m.remoteEvents)
+	case remoteCompleteMsg:
+		m.remoteOp = ""
+		m.remoteEvents = nil
+		m.remoteProgress = nil
+		m.setStatus(msg.op + " complete")
+		if currentBranch, err := getCurrentBranch(); err == nil {
+			m.currentBranch = currentBranch
+		}
+		m.refreshFiles()
+		m.refreshUpstream()
+		return m, nil
+	case remoteErrorMsg:
+		m.remoteOp = ""
+		m.remoteEvents = nil
+		m.remoteProgress = nil
+		m.setError(msg.op + " failed: " + msg.err.Error())
+		return m, nil
+	case hookOutputMsg:
+		if msg.replace && len(m.hookOutput) > 0 {
+			m.hookOutput[len(m.hookOutput)-1] = msg.line
+		} else {
+			m.hookOutput = append(m.hookOutput, msg.line)
+		}
+		// follow the output while it streams
+		m.hookOffset = max(len(m.hookOutput)-m.diffPaneHeight()+1, 0)
+		return m, waitForRemote(m.hookEvents)
+	case hookCompleteMsg:
+		m.hookOp = ""
+		m.hookEvents = nil
+		if msg.err != nil {
+			m.setError(msg.op + " failed: " + msg.err.Error())
+		} else if msg.op == "amend" {
+			m.setStatus("amended the last commit")
+		} else {
+			m.setStatus("committed")
+		}
+		// the pane stays open only when there is output to read
+		if len(m.hookOutput) == 0 {
+			m.showHooks = false
+		}
+		m.refreshFiles()
+		m.refreshUpstream()
+		return m, nil
+	case editorFinishedMsg:
+		if m.editedCommit != nil && msg.path == m.editedCommit.Path {
+			return m, m.editedCommitMessage(msg.err)
+		}
+		if msg.err != nil {
+			m.setError("editor failed: " + msg.err.Error())
+			return m, nil
+		}
+		if m.showConflicts {
+			m.editedConflict(msg.path)
+		}
 		return m, nil
 	}
 
 	return m, nil
 }
 
-// stages all selected files
-func (m *Model) stageSelectedFiles() {
-	for _, file := range m.files {
-		if file.Selected && file.Status != "staged" {
-			stageFile(file.Path)
+// handles keys on the commit log screen
+func (m Model) updateLog(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "q":
+		m.quitting = true
+		return m, tea.Quit
+	case "esc":
+		m.showLog = false
+	case "up", "k":
+		if m.logCursor > 0 {
+			m.logCursor--
+		}
+	case "down", "j":
+		if m.logCursor < len(m.commits)-1 {
+			m.logCursor++
+		}
+		// fetch the next page once the last loaded commit is reached
+		if m.logCursor == len(m.commits)-1 && m.logHasMore {
+			m.loadMoreCommits()
+		}
+	case "enter":
+		if m.logCursor < len(m.commits) {
+			m.openCommitDetail(m.commits[m.logCursor].Hash)
+		}
+	case "a":
+		m.logAll = !m.logAll
+		m.openLog()
+	case "c":
+		if m.logCursor < len(m.commits) {
+			m.cherryPickAtCursor()
+		}
+	case "r":
+		if m.logCursor < len(m.commits) {
+			m.revertAtCursor()
+		}
+	case "R":
+		if m.logCursor < len(m.commits) {
+			m.resetHeadAtCursor()
+		}
+	case "U":
+		m.undoLastReset()
+	case "t":
+		if m.logCursor < len(m.commits) {
+			c := m.commits[m.logCursor]
+			if m.createTagForm(c.Hash.String(), c.ShortHash()) {
+				if labels, err := getRefLabels(); err == nil {
+					m.refLabels = labels
+				}
+			}
 		}
 	}
+	return m, nil
 }
 
-// unstages all selected files
-func (m *Model) unstageSelectedFiles() {
-	for _, file := range m.files {
-		if file.Selected && file.Status == "staged" {
-			unstageFile(file.Path)
+// handles keys on the commit detail screen
+func (m Model) updateCommitDetail(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "q":
+		m.quitting = true
+		return m, tea.Quit
+	case "esc":
+		m.showCommitDetail = false
+		m.commitDetail = nil
+		m.detailDiff = nil
+	case "up", "k":
+		if m.detailCursor > 0 {
+			m.detailCursor--
+			m.loadDetailDiff()
 		}
+	case "down", "j":
+		if m.commitDetail != nil && m.detailCursor < len(m.commitDetail.Files)-1 {
+			m.detailCursor++
+			m.loadDetailDiff()
+		}
+	case "ctrl+d":
+		m.scrollDetailDiff(m.diffPaneHeight() / 2)
+	case "ctrl+u":
+		m.scrollDetailDiff(-m.diffPaneHeight() / 2)
 	}
+	return m, nil
 }
 
-// reloads the file list and adjusts cursor position
-func (m *Model) refreshFiles() {
-	if files, err := getGitStatus(); err == nil {
-		m.files = files
-		// keep cursor in bounds
-		if m.cursor >= len(m.files) {
-			m.cursor = len(m.files) - 1
-		}
-		if m.cursor < 0 {
-			m.cursor = 0
-		}
+// cherry-picks the commit under the log cursor onto the current branch
+func (m *Model) cherryPickAtCursor() {
+	c := m.commits[m.logCursor]
+
+	confirmed, recordOrigin, err := showCherryPickForm(c.ShortHash(), c.Subject, m.currentBranch)
+	if err != nil || !confirmed {
+		return
 	}
+
+	result, err := cherryPick(c.Hash, recordOrigin)
+	m.handlePickResult(pickCherryPick, c, result, err)
 }
 
-// shows commit form and commits changes
-func (m *Model) commitChanges() {
-	if message, err := showCommitForm(); err == nil && message != "" {
-		if err := commit(message); err == nil {
-			m.refreshFiles()
-		}
+// commits the inverse of the commit under the log cursor
+func (m *Model) revertAtCursor() {
+	c := m.commits[m.logCursor]
+
+	confirmed, err := showConfirmForm("revert "+c.ShortHash()+"?", "a new commit on "+m.currentBranch+" will undo \""+c.Subject+"\"")
+	if err != nil || !confirmed {
+		return
 	}
+
+	result, err := revertCommit(c.Hash)
+	m.handlePickResult(pickRevert, c, result, err)
 }
 
-// shows create branch form and creates branch
-func (m *Model) createBranchForm() tea.Cmd {
-	branchName, err := showCreateBranchForm()
+// reports the outcome of a cherry-pick or revert, handing conflicts to the
+// conflict screen
+func (m *Model) handlePickResult(action string, c CommitInfo, result *PickResult, err error) {
+	m.refreshFiles()
+	switch {
+	case err != nil:
+		m.setError(action + " failed: " + err.Error())
+	case len(result.Conflicts) > 0:
+		m.setError(fmt.Sprintf("%s of %s stopped with %d conflict(s), resolve them and conclude", action, c.ShortHash(), len(result.Conflicts)))
+		m.showLog = false
+		m.openConflicts()
+	default:
+		m.setStatus(fmt.Sprintf("%s of %s created %s", action, c.ShortHash(), result.Commit.String()[:7]))
+		m.openLog()
+	}
+}
+
+// resets HEAD to the commit under the log cursor, asking for the mode and
+// showing what would be lost first
+func (m *Model) resetHeadAtCursor() {
+	c := m.commits[m.logCursor]
+
+	mode, err := showResetModeForm(c.ShortHash())
+	if err != nil || mode == "" {
+		return
+	}
+
+	loss, err := getResetLoss(c.Hash.String(), mode)
 	if err != nil {
-		// Handle error - could show error message here
-		return nil
+		m.setError("reset failed: " + err.Error())
+		return
 	}
-	if branchName != "" {
-		return tea.Cmd(func() tea.Msg {
-			err := createBranch(branchName)
-			if err != nil {
-				return createBranchErrorMsg{err: err}
-			}
-			return createBranchCompleteMsg{branchName: branchName}
-		})
+
+	description := "HEAD will point at " + c.ShortHash() + " " + c.Subject
+	if loss.Commits > 0 {
+		description += fmt.Sprintf("\n%d commit(s) will no longer be on HEAD, U undoes the reset", loss.Commits)
 	}
-	return nil
+	if len(loss.Changed) > 0 {
+		description += "\nuncommitted changes lost: " + strings.Join(loss.Changed, ", ")
+	}
+	if len(loss.Untracked) > 0 {
+		description += "\nuntracked files overwritten: " + strings.Join(loss.Untracked, ", ")
+	}
+	confirmed, err := showConfirmForm(mode+" reset to "+c.ShortHash()+"?", description)
+	if err != nil || !confirmed {
+		return
+	}
+
+	if err := resetHead(c.Hash.String(), mode); err != nil {
+		m.setError("reset failed: " + err.Error())
+		return
+	}
+	m.setStatus(fmt.Sprintf("HEAD is now at %s (%s reset), press U to undo", c.ShortHash(), mode))
+	m.refreshFiles()
+	m.refreshUpstream()
+	m.openLog()
 }
 
-// shows switch branch form and switches branch
-func (m *Model) switchBranchForm() tea.Cmd {
-	branches, err := listBranches()
+// moves HEAD back to where the last reset found it
+func (m *Model) undoLastReset() {
+	undo, err := getResetUndo()
 	if err != nil {
-		return nil
+		m.setError("undo reset failed: " + err.Error())
+		return
 	}
-	branchName, err := showBranchSelectionForm(branches)
+	if undo == nil {
+		m.setError("no reset to undo")
+		return
+	}
+
+	confirmed, err := showConfirmForm("undo the "+undo.Mode+" reset?", "HEAD will point at "+undo.From[:7]+" again")
+	if err != nil || !confirmed {
+		return
+	}
+
+	if _, err := undoReset(); err != nil {
+		m.setError("undo reset failed: " + err.Error())
+		return
+	}
+	m.setStatus("HEAD is back at " + undo.From[:7])
+	m.refreshFiles()
+	m.refreshUpstream()
+	if m.showLog {
+		m.openLog()
+	}
+}
+
+// abandons the cherry-pick or revert in progress after asking
+func (m *Model) abortPick() {
+	confirmed, err := showConfirmForm("abort the "+m.pick.Action+"?", "the index and worktree will be reset to HEAD, discarding any resolutions")
+	if err != nil || !confirmed {
+		return
+	}
+
+	if err := abortPick(); err != nil {
+		m.setError("abort failed: " + err.Error())
+		return
+	}
+	m.setStatus(m.pick.Action + " aborted")
+	m.showConflicts = false
+	m.conflictFile = nil
+	m.refreshFiles()
+}
+
+// opens the log screen with the first page of history
+func (m *Model) openLog() {
+	order, err := getLogOrder(m.logAll)
 	if err != nil {
-		return nil
+		order = nil
 	}
-	if branchName != "" {
-		return tea.Cmd(func() tea.Msg {
-			err := switchBranch(branchName)
+	labels, err := getRefLabels()
+	if err != nil {
+		labels = nil
+	}
+
+	m.commits = nil
+	m.graphRows = nil
+	m.logGraph = &commitGraph{}
+	m.logOrder = order
+	m.logHasMore = len(order) > 0
+	m.refLabels = labels
+	m.loadMoreCommits()
+	m.logCursor = 0
+	m.showLog = true
+}
+
+// appends the next page of history to the log, in the order worked out
+// when it was opened
+func (m *Model) loadMoreCommits() {
+	page := m.logOrder[len(m.commits):min(len(m.commits)+logPageSize, len(m.logOrder))]
+	commits, err := getCommitLog(page)
+	if err != nil {
+		return
+	}
+	m.appendCommits(commits)
+	m.logHasMore = len(m.commits) < len(m.logOrder)
+}
+
+// adds commits to the log, continuing the graph from the previous page
+func (m *Model) appendCommits(commits []CommitInfo) {
+	for _, c := range commits {
+		m.commits = append(m.commits, c)
+		m.graphRows = append(m.graphRows, m.logGraph.next(c))
+	}
+}
+
+// opens the detail view for a commit
+func (m *Model) openCommitDetail(hash plumbing.Hash) {
+	detail, err := getCommitDetail(hash)
+	if err != nil {
+		return
+	}
+	m.commitDetail = detail
+	m.detailCursor = 0
+	m.showCommitDetail = true
+	m.loadDetailDiff()
+}
+
+// recomputes the diff of the selected file in the commit detail view
+func (m *Model) loadDetailDiff() {
+	m.detailOffset = 0
+	m.detailDiff = nil
+	if m.commitDetail == nil || m.detailCursor >= len(m.commitDetail.Files) {
+		return
+	}
+
+	d, err := getCommitFileDiff(m.commitDetail.Hash, m.commitDetail.Files[m.detailCursor])
+	if err != nil {
+		return
+	}
+	m.detailDiff = d
+}
+
+// scrolls the commit detail diff, keeping the last line in view
+func (m *Model) scrollDetailDiff(delta int) {
+	if m.detailDiff == nil {
+		return
+	}
+
+	last := max(len(m.detailDiff.Lines())-m.detailPaneHeight()+1, 0)
+	m.detailOffset = min(max(m.detailOffset+delta, 0), last)
+}
+
+// starts a fetch (f), pull (p) or push (P) in the background
+func (m *Model) startRemoteOp(key string) tea.Cmd {
+	var op string
+	var run func(io.Writer) error
+	switch key {
+	case "f":
+		op = "fetch"
+		run = func(w io.Writer) error {
+			remoteName, _, err := currentRemote()
 			if err != nil {
-				return switchBranchErrorMsg{err: err}
+				return err
 			}
-			return switchBranchCompleteMsg{branchName: branchName}
-		})
+			return fetchRemote(remoteName, w)
+		}
+	case "p":
+		op, run = "pull", pullRemote
+	case "P":
+		op, run = "push", pushRemote
+	default:
+		return nil
 	}
-	return nil
+	return m.runRemoteOp(op, run)
+}
+
+// runs a remote operation in the background, streaming its progress
+func (m *Model) runRemoteOp(op string, run func(io.Writer) error) tea.Cmd {
+	events := make(chan tea.Msg)
+	m.remoteOp = op
+	m.remoteEvents = events
+	m.remoteProgress = nil
+	m.statusMessage = ""
+
+	go func() {
+		defer close(events)
+		progress := &progressWriter{send: func(line string, replace bool) {
+			events <- remoteProgressMsg{line: line, replace: replace}
+		}}
+		if err := run(progress); err != nil {
+			events <- remoteErrorMsg{op: op, err: err}
+			return
+		}
+		events <- remoteCompleteMsg{op: op}
+	}()
+
+	return waitForRemote(events)
+}
+
+// suspends the ui to edit a file in the editor git would use
+func openEditor(path string) tea.Cmd {
+	editor := gitEditor()
+	if strings.TrimSpace(editor) == "" {
+		return func() tea.Msg {
+			return editorFinishedMsg{path: path, err: fmt.Errorf("the configured editor is empty")}
+		}
+	}
+
+	// through the shell like git does, so the editor may carry arguments
+	// and quotes, e.g. "code --wait", and ":" edits nothing
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return editorFinishedMsg{path: path, err: err}
+	})
+}
+
+// waits for the next event of a running remote operation
+func waitForRemote(events chan tea.Msg) tea.Cmd {
+	if events == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		return <-events
+	}
+}
+
+// stages all selected files
+func (m *Model) stageSelectedFiles() {
+	for _, file := range m.files {
+		if file.Selected && file.Status != "staged" {
+			stageFile(file.Path)
+		}
+	}
+}
+
+// unstages all selected files
+func (m *Model) unstageSelectedFiles() {
+	for _, file := range m.files {
+		if file.Selected && file.Status == "staged" {
+			unstageFile(file.Path)
+		}
+	}
+}
+
+// throws away the changes of the selected files once confirmed, saving
+// them in the trash
+func (m *Model) discardSelectedFiles() {
+	var unstaged, staged, untracked []string
+	for _, file := range m.files {
+		if !file.Selected {
+			continue
+		}
+		switch file.Status {
+		case "unstaged":
+			unstaged = append(unstaged, file.Path)
+		case "staged":
+			staged = append(staged, file.Path)
+		case "untracked":
+			untracked = append(untracked, file.Path)
+		case "conflicted":
+			m.setError("discard failed: resolve the conflicts of " + file.Path + " in the merge view first")
+			return
+		}
+	}
+	// a staged file goes back to HEAD in the worktree too, which takes its
+	// unstaged changes with it
+	unstaged = slices.DeleteFunc(unstaged, func(path string) bool {
+		return slices.Contains(staged, path)
+	})
+
+	d := &Discard{Unstaged: unstaged, Staged: staged, Untracked: untracked}
+	count := len(d.Paths())
+	if count == 0 {
+		m.setError("discard failed: select files with space first")
+		return
+	}
+
+	var lines []string
+	if len(unstaged) > 0 {
+		lines = append(lines, "unstaged changes lost: "+strings.Join(unstaged, ", "))
+	}
+	if len(staged) > 0 {
+		lines = append(lines, "staged and unstaged changes lost: "+strings.Join(staged, ", "))
+	}
+	if len(untracked) > 0 {
+		lines = append(lines, "untracked files deleted: "+strings.Join(untracked, ", "))
+	}
+	lines = append(lines, "the discarded content is saved in .git/got/trash, D restores it")
+
+	confirmed, err := showConfirmForm(fmt.Sprintf("discard changes to %d file(s)?", count), strings.Join(lines, "\n"))
+	if err != nil || !confirmed {
+		return
+	}
+
+	dir, err := discardFiles(unstaged, staged, untracked)
+	if err != nil {
+		m.setError("discard failed: " + err.Error())
+		return
+	}
+	m.setStatus(fmt.Sprintf("discarded changes to %d file(s), saved in %s", count, dir))
+}
+
+// throws away the selected lines of the diff pane, or the ones under the
+// cursor, from the worktree once confirmed
+func (m *Model) discardChosenHunks() {
+	if m.diff == nil {
+		return
+	}
+	switch m.diff.Status {
+	case "staged":
+		m.setError("discard failed: unstage the lines first, the diff pane discards unstaged changes")
+		return
+	case "conflicted":
+		m.setError("discard failed: resolve the conflicts of " + m.diff.Path + " in the merge view first")
+		return
+	}
+
+	hunks := m.diff.chosenHunks(m.hunkCursor, m.lineCursor, m.lineMode)
+	if noneSelected(hunks) {
+		return
+	}
+
+	title := "discard the chosen lines?"
+	if allSelected(hunks) && m.diff.Status == "untracked" {
+		title = "delete the untracked file?"
+	}
+	confirmed, err := showConfirmForm(title, m.diff.Path+"\nthe file is saved in .git/got/trash first, D restores it")
+	if err != nil || !confirmed {
+		return
+	}
+
+	dir, err := discardHunks(m.diff.Path, m.diff.Status == "untracked", hunks)
+	if err != nil {
+		m.setError("discard failed: " + err.Error())
+		return
+	}
+	m.setStatus("discarded changes to " + m.diff.Path + ", saved in " + dir)
+}
+
+// puts back the latest discard once confirmed
+func (m *Model) restoreLastDiscard() {
+	d, err := getLastDiscard()
+	if err != nil {
+		m.setError("restore failed: " + err.Error())
+		return
+	}
+	if d == nil {
+		m.setError("nothing discarded to restore")
+		return
+	}
+
+	paths := d.Paths()
+	title := fmt.Sprintf("restore the changes to %d file(s) discarded %s?", len(paths), d.Time.Format("2006-01-02 15:04"))
+	confirmed, err := showConfirmForm(title, strings.Join(paths, ", "))
+	if err != nil || !confirmed {
+		return
+	}
+
+	if _, err := restoreDiscard(); err != nil {
+		m.setError("restore failed: " + err.Error())
+		return
+	}
+	m.setStatus(fmt.Sprintf("restored changes to %d file(s)", len(paths)))
+	m.refreshFiles()
+}
+
+// toggles the line under the cursor in line mode, or the whole hunk
+func (m *Model) toggleDiffSelection() {
+	if m.diff == nil || m.hunkCursor >= len(m.diff.Hunks) {
+		return
+	}
+
+	h := &m.diff.Hunks[m.hunkCursor]
+	if !m.lineMode {
+		h.setSelected(h.selectedCount() < h.changeCount())
+		return
+	}
+	if m.lineCursor >= 0 && m.lineCursor < len(h.Lines) && h.Lines[m.lineCursor].Kind != ' ' {
+		h.Lines[m.lineCursor].Selected = !h.Lines[m.lineCursor].Selected
+	}
+}
+
+// stages the selected lines of the diff pane, or the ones under the cursor
+func (m *Model) stageChosenHunks() {
+	if m.diff == nil || m.diff.Status == "staged" {
+		return
+	}
+	if m.diff.Status == "conflicted" {
+		m.setError("resolve the conflicts of " + m.diff.Path + " in the merge view first")
+		return
+	}
+	if err := stageHunks(m.diff.Path, m.diff.chosenHunks(m.hunkCursor, m.lineCursor, m.lineMode)); err != nil {
+		m.setError("stage failed: " + err.Error())
+	}
+}
+
+// unstages the selected lines of the diff pane, or the ones under the cursor
+func (m *Model) unstageChosenHunks() {
+	if m.diff == nil || m.diff.Status != "staged" {
+		return
+	}
+	if err := unstageHunks(m.diff.Path, m.diff.chosenHunks(m.hunkCursor, m.lineCursor, m.lineMode)); err != nil {
+		m.setError("unstage failed: " + err.Error())
+	}
+}
+
+// reloads the file list and adjusts cursor position
+func (m *Model) refreshFiles() {
+	if files, err := getGitStatus(); err == nil {
+		m.files = files
+		// keep cursor in bounds
+		if m.cursor >= len(m.files) {
+			m.cursor = len(m.files) - 1
+		}
+		if m.cursor < 0 {
+			m.cursor = 0
+		}
+	}
+	m.loadDiff()
+	m.refreshMergeState()
+}
+
+// recomputes the diff of the file under the cursor
+func (m *Model) loadDiff() {
+	m.diffOffset = 0
+	m.hunkCursor = 0
+	m.lineCursor = 0
+	m.lineMode = false
+	if m.cursor >= len(m.files) {
+		m.diff = nil
+		m.diffFocused = false
+		return
+	}
+
+	d, err := getFileDiff(m.files[m.cursor])
+	if err != nil {
+		m.diff = nil
+		return
+	}
+	m.diff = d
+}
+
+// moves to the next or previous hunk and scrolls it into view
+func (m *Model) moveHunkCursor(delta int) {
+	if m.diff == nil || len(m.diff.Hunks) == 0 {
+		return
+	}
+
+	m.hunkCursor = min(max(m.hunkCursor+delta, 0), len(m.diff.Hunks)-1)
+	m.diffOffset = 0
+	m.scrollDiff(m.diff.hunkRow(m.hunkCursor))
+}
+
+// moves to the next or previous added or removed line, across hunks
+func (m *Model) moveLineCursor(delta int) {
+	if m.diff == nil || len(m.diff.Hunks) == 0 {
+		return
+	}
+
+	h, l := m.hunkCursor, m.lineCursor
+	for {
+		l += delta
+		if l < 0 {
+			if h == 0 {
+				return
+			}
+			h--
+			l = len(m.diff.Hunks[h].Lines)
+			continue
+		}
+		if l >= len(m.diff.Hunks[h].Lines) {
+			if h == len(m.diff.Hunks)-1 {
+				return
+			}
+			h++
+			l = -1
+			continue
+		}
+		if m.diff.Hunks[h].Lines[l].Kind != ' ' {
+			break
+		}
+	}
+
+	m.hunkCursor, m.lineCursor = h, l
+	row := m.diff.hunkRow(h) + 1 + l
+	if row < m.diffOffset || row >= m.diffOffset+m.diffPaneHeight()-1 {
+		m.diffOffset = 0
+		m.scrollDiff(max(row-m.diffPaneHeight()/2, 0))
+	}
+}
+
+// scrolls the diff pane, keeping the last line in view
+func (m *Model) scrollDiff(delta int) {
+	if m.diff == nil {
+		return
+	}
+
+	last := max(len(m.diff.Lines())-m.diffPaneHeight()+1, 0)
+	m.diffOffset = min(max(m.diffOffset+delta, 0), last)
+}
+
+// shows commit form and commits changes, in the background with the hook
+// output pane when hooks are installed; with only, just those paths are
+// committed
+func (m *Model) commitChanges(only []string) tea.Cmd {
+	ctx, err := loadCommitFormContext()
+	if err != nil {
+		m.setError("commit failed: " + err.Error())
+		return nil
+	}
+	ctx.Only = only
+	if !ctx.Identity.Complete() {
+		m.setError("commit failed: set user.name and user.email in git config, or press I to apply an identity profile")
+		return nil
+	}
+
+	input, err := showCommitForm("", ctx)
+	if err != nil || input.Message == "" {
+		return nil
+	}
+	return m.finishCommit(input, false, ctx)
+}
+
+// commits the selected files as they are in the worktree, leaving other
+// staged changes staged, like git commit -- paths
+func (m *Model) commitSelected() tea.Cmd {
+	selected := m.selectedPaths()
+	if len(selected) == 0 {
+		m.setError("commit failed: select files with space first")
+		return nil
+	}
+	return m.commitChanges(selected)
+}
+
+// amends the last commit with the staged changes and an edited message,
+// warning first if it was already pushed
+func (m *Model) amendLastCommit() tea.Cmd {
+	previous, err := getHeadMessage()
+	if err != nil {
+		m.setError("amend failed: " + err.Error())
+		return nil
+	}
+
+	pushedTo, err := headPushedTo()
+	if err != nil {
+		m.setError("amend failed: " + err.Error())
+		return nil
+	}
+	if pushedTo != "" {
+		confirmed, err := showConfirmForm("amend a pushed commit?", "HEAD is already on "+pushedTo+", amending it rewrites history others may have and the next push needs force")
+		if err != nil || !confirmed {
+			return nil
+		}
+	}
+
+	ctx, err := loadCommitFormContext()
+	if err != nil {
+		m.setError("amend failed: " + err.Error())
+		return nil
+	}
+
+	input, err := showCommitForm(previous, ctx)
+	if err != nil || input.Message == "" {
+		return nil
+	}
+	return m.finishCommit(input, true, ctx)
+}
+
+// gathers the identity, rules, hooks and previous authors the commit form
+// offers
+func loadCommitFormContext() (*CommitFormContext, error) {
+	identity, _, err := getIdentity()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := loadCommitRules()
+	if err != nil {
+		return nil, err
+	}
+	hooks, err := hasCommitHooks()
+	if err != nil {
+		return nil, err
+	}
+	authors, err := listAuthors()
+	if err != nil {
+		return nil, err
+	}
+
+	// the committer is not offered as their own co-author
+	ctx := &CommitFormContext{Identity: identity, Rules: rules, Hooks: hooks}
+	for _, author := range authors {
+		if !strings.EqualFold(author, identity.String()) {
+			ctx.Authors = append(ctx.Authors, author)
+		}
+	}
+	return ctx, nil
+}
+
+// commits the message from the commit form, first opening it in the
+// editor if asked to
+func (m *Model) finishCommit(input *CommitInput, amend bool, ctx *CommitFormContext) tea.Cmd {
+	op := "commit"
+	if amend {
+		op = "amend"
+	}
+
+	if input.Editor {
+		path, err := writeCommitEditMessage(input.Message, ctx.Only)
+		if err != nil {
+			m.setError(op + " failed: " + err.Error())
+			return nil
+		}
+		if ctx.Hooks {
+			var out strings.Builder
+			if err := prepareCommitEditMessage(path, amend, &out); err != nil {
+				message := op + " failed: " + err.Error()
+				if output := strings.TrimSpace(out.String()); output != "" {
+					message += ": " + output
+				}
+				m.setError(message)
+				return nil
+			}
+		}
+		m.editedCommit = &EditedCommit{Path: path, Input: *input, Amend: amend, Context: ctx}
+		return openEditor(path)
+	}
+
+	if ctx.Hooks {
+		return m.runHooks(op, func(w io.Writer) error {
+			return commitWithHooks(input.Message, amend, !input.SkipHooks, input.Edited, ctx.Only, w)
+		})
+	}
+
+	err := withOnlyIndex(ctx.Only, func() error {
+		if amend {
+			return amendCommit(input.Message)
+		}
+		return commit(input.Message)
+	})
+	if err != nil {
+		m.setError(op + " failed: " + err.Error())
+		return nil
+	}
+	if amend {
+		m.setStatus("amended the last commit")
+	}
+	m.refreshFiles()
+	m.refreshUpstream()
+	return nil
+}
+
+// commits the message written in the editor once it is closed, checked
+// against the same rules as the form
+func (m *Model) editedCommitMessage(editErr error) tea.Cmd {
+	edited := m.editedCommit
+	m.editedCommit = nil
+
+	op := "commit"
+	if edited.Amend {
+		op = "amend"
+	}
+	if editErr != nil {
+		m.setError("editor failed: " + editErr.Error())
+		return nil
+	}
+
+	message, err := readCommitEditMessage(edited.Path)
+	if err != nil {
+		m.setError(op + " failed: " + err.Error())
+		return nil
+	}
+	if message == "" {
+		m.setStatus(op + " aborted, the message was empty")
+		return nil
+	}
+	if err := edited.Context.Rules.validateMessage(message); err != nil {
+		m.setError(op + " failed: " + err.Error() + ", the message is kept in " + edited.Path)
+		return nil
+	}
+
+	input := edited.Input
+	input.Message = message
+	input.Editor = false
+	input.Edited = true
+	return m.finishCommit(&input, edited.Amend, edited.Context)
+}
+
+// runs a commit with its hooks in the background, streaming the hook
+// output into the hook pane
+func (m *Model) runHooks(op string, run func(io.Writer) error) tea.Cmd {
+	events := make(chan tea.Msg)
+	m.showHooks = true
+	m.hookOp = op
+	m.hookEvents = events
+	m.hookOutput = nil
+	m.hookOffset = 0
+	m.statusMessage = ""
+
+	go func() {
+		defer close(events)
+		output := &progressWriter{send: func(line string, replace bool) {
+			events <- hookOutputMsg{line: line, replace: replace}
+		}}
+		events <- hookCompleteMsg{op: op, err: run(output)}
+	}()
+
+	return waitForRemote(events)
+}
+
+// hook output pane, scrollable once the hooks are done
+func (m Model) updateHooks(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		m.quitting = true
+		return m, tea.Quit
+	case "esc", "q":
+		if m.hookOp == "" {
+			m.showHooks = false
+		}
+	case "up", "k":
+		m.scrollHooks(-1)
+	case "down", "j":
+		m.scrollHooks(1)
+	case "ctrl+d":
+		m.scrollHooks(m.diffPaneHeight() / 2)
+	case "ctrl+u":
+		m.scrollHooks(-m.diffPaneHeight() / 2)
+	}
+	return m, nil
+}
+
+// scrolls the hook output, keeping the last line in view
+func (m *Model) scrollHooks(delta int) {
+	last := max(len(m.hookOutput)-m.diffPaneHeight()+1, 0)
+	m.hookOffset = min(max(m.hookOffset+delta, 0), last)
+}
+
+// picks an identity profile from the got config and applies it to this
+// repository
+func (m *Model) switchProfile() {
+	cfg, err := loadConfig()
+	if err != nil {
+		m.setError("identity: " + err.Error())
+		return
+	}
+	_, current, err := getIdentity()
+	if err != nil {
+		m.setError("identity: " + err.Error())
+		return
+	}
+
+	if len(cfg.Profiles) == 0 {
+		m.setError("identity: no profiles, add them under profiles in the got config")
+		return
+	}
+
+	name, err := showProfileForm(cfg.Profiles, current)
+	if err != nil || name == "" {
+		return
+	}
+	if err := applyProfile(name); err != nil {
+		m.setError("identity: " + err.Error())
+		return
+	}
+
+	identity, _, err := getIdentity()
+	if err != nil {
+		m.setError("identity: " + err.Error())
+		return
+	}
+	m.setStatus("committing as " + identity.String())
+}
+
+// deletes the branch under the branch list cursor, asking before deleting
+// one that is not merged
+func (m *Model) deleteBranchAtCursor() {
+	branch := m.branches[m.branchListCursor]
+
+	confirmed, err := showConfirmForm("delete branch "+branch+"?", "the branch ref and its config will be removed")
+	if err != nil || !confirmed {
+		return
+	}
+
+	err = deleteBranch(branch, false)
+	if errors.Is(err, errBranchNotMerged) {
+		confirmed, ferr := showConfirmForm(
+			"branch "+branch+" is not fully merged",
+			"its unmerged commits will only be reachable through the reflog. delete anyway?")
+		if ferr != nil || !confirmed {
+			return
+		}
+		err = deleteBranch(branch, true)
+	}
+	if err != nil {
+		m.setError("delete branch failed: " + err.Error())
+	} else {
+		m.setStatus("deleted branch " + branch)
+	}
+	m.reloadBranches()
+}
+
+// renames the branch under the branch list cursor
+func (m *Model) renameBranchAtCursor() {
+	branch := m.branches[m.branchListCursor]
+
+	newName, err := showRenameBranchForm(branch)
+	if err != nil || newName == "" || newName == branch {
+		return
+	}
+
+	if err := renameBranch(branch, newName); err != nil {
+		m.setError("rename branch failed: " + err.Error())
+	} else {
+		m.setStatus("renamed " + branch + " to " + newName)
+	}
+	if current, err := getCurrentBranch(); err == nil {
+		m.currentBranch = current
+	}
+	m.reloadBranches()
+	m.refreshUpstream()
+}
+
+// points the branch under the branch list cursor at another commit
+func (m *Model) resetBranchAtCursor() {
+	branch := m.branches[m.branchListCursor]
+	if branch == m.currentBranch {
+		m.setError("cannot force-reset the current branch, reset HEAD instead")
+		return
+	}
+
+	target, err := showResetBranchForm(branch)
+	if err != nil || target == "" {
+		return
+	}
+
+	lost, err := branchResetLoss(branch, target)
+	if err != nil {
+		m.setError("reset branch failed: " + err.Error())
+		return
+	}
+
+	description := "the branch will point at " + target
+	if lost > 0 {
+		description += fmt.Sprintf(", %d commit(s) will no longer be on %s", lost, branch)
+	}
+	confirmed, err := showConfirmForm("reset branch "+branch+"?", description)
+	if err != nil || !confirmed {
+		return
+	}
+
+	if err := resetBranch(branch, target); err != nil {
+		m.setError("reset branch failed: " + err.Error())
+	} else {
+		m.setStatus("reset " + branch + " to " + target)
+	}
+	m.reloadBranches()
+}
+
+// merges the branch under the branch list cursor into the current branch,
+// opening the conflict view if it stops on conflicts
+func (m *Model) mergeBranchAtCursor() {
+	branch := m.branches[m.branchListCursor]
+	if branch == m.currentBranch {
+		m.setError("cannot merge a branch into itself")
+		return
+	}
+
+	result, err := mergeBranch(branch)
+	switch {
+	case errors.Is(err, errNothingToMerge):
+		m.setStatus(branch + " is already merged into " + m.currentBranch)
+	case err != nil:
+		m.setError("merge failed: " + err.Error())
+	case len(result.Conflicts) > 0:
+		m.setError(fmt.Sprintf("merging %s stopped with %d conflict(s), resolve them and conclude the merge", branch, len(result.Conflicts)))
+		m.showBranchList = false
+		m.showConflicts = true
+	case result.FastForward:
+		m.setStatus("fast-forwarded " + m.currentBranch + " to " + branch)
+	default:
+		m.setStatus("merged " + branch + " into " + m.currentBranch)
+	}
+
+	m.refreshFiles()
+	m.refreshUpstream()
+	m.reloadBranches()
+	if m.showConflicts {
+		m.openConflicts()
+	}
+}
+
+// handles keys on the merge conflict screen
+func (m Model) updateConflicts(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "q":
+		m.quitting = true
+		return m, tea.Quit
+	case "esc":
+		if m.conflictFocused {
+			m.conflictFocused = false
+		} else {
+			m.showConflicts = false
+			m.conflictFile = nil
+		}
+	case "tab":
+		if m.conflictFile != nil && len(m.conflictFile.Hunks) > 0 {
+			m.conflictFocused = !m.conflictFocused
+		}
+	case "up", "k":
+		if m.conflictFocused {
+			m.moveConflictHunk(-1)
+		} else if m.conflictCursor > 0 {
+			m.conflictCursor--
+			m.loadConflictFile()
+		}
+	case "down", "j":
+		if m.conflictFocused {
+			m.moveConflictHunk(1)
+		} else if m.conflictCursor < len(m.conflicts)-1 {
+			m.conflictCursor++
+			m.loadConflictFile()
+		}
+	case "ctrl+d":
+		m.conflictOffset += m.diffPaneHeight() / 2
+	case "ctrl+u":
+		m.conflictOffset = max(m.conflictOffset-m.diffPaneHeight()/2, 0)
+	case "o":
+		m.resolveConflict(resolveOurs)
+	case "t":
+		m.resolveConflict(resolveTheirs)
+	case "B":
+		m.resolveConflict(resolveBoth)
+	case "e":
+		if m.conflictFile != nil {
+			return m, openEditor(m.conflictFile.Path)
+		}
+	case "s":
+		m.markConflictResolved()
+	case "c":
+		if !m.merging && m.rebase == nil && m.pick == nil {
+			// conflicts of a stash apply need no commit, a pop drops its
+			// stash once they are resolved
+			if len(m.conflicts) > 0 {
+				m.setError("resolve the remaining conflicts first, s marks a file resolved")
+				break
+			}
+			dropped, err := concludeStashPop()
+			if err != nil {
+				m.setError("dropping the stash failed: " + err.Error())
+				break
+			}
+			if dropped != "" {
+				m.setStatus("conflicts resolved, dropped " + dropped)
+				m.reloadStashes()
+			}
+			m.showConflicts = false
+			m.conflictFile = nil
+			m.refreshFiles()
+			break
+		}
+		m.showConflicts = false
+		m.conflictFile = nil
+		if m.rebase != nil {
+			m.handleRebaseResult(continueRebase())
+			break
+		}
+		if m.pick != nil {
+			action := m.pick.Action
+			if err := concludePick(""); err != nil {
+				m.setError(action + " failed: " + err.Error())
+				m.showConflicts = true
+				m.loadConflictFile()
+				break
+			}
+			m.setStatus(action + " concluded")
+			m.refreshFiles()
+			m.refreshUpstream()
+			break
+		}
+		if err := concludeMerge(); err != nil {
+			m.setError("conclude merge failed: " + err.Error())
+			m.showConflicts = true
+			m.loadConflictFile()
+			break
+		}
+		m.setStatus("merge concluded")
+		m.refreshFiles()
+		m.refreshUpstream()
+	case "A":
+		if m.rebase != nil {
+			m.abortRebase()
+			break
+		}
+		if m.pick != nil {
+			m.abortPick()
+			break
+		}
+		if !m.merging {
+			break
+		}
+		confirmed, err := showConfirmForm("abort the merge?", "the index and worktree will be reset to HEAD, discarding any resolutions")
+		if err != nil || !confirmed {
+			break
+		}
+		if err := abortMerge(); err != nil {
+			m.setError("abort merge failed: " + err.Error())
+			break
+		}
+		m.setStatus("merge aborted")
+		m.showConflicts = false
+		m.conflictFile = nil
+		m.refreshFiles()
+	}
+	return m, nil
+}
+
+// shows the conflict screen from the first conflicted file
+func (m *Model) openConflicts() {
+	m.refreshMergeState()
+	m.showConflicts = true
+	m.conflictCursor = 0
+	m.loadConflictFile()
+}
+
+// loads the conflict hunks of the file under the conflict cursor
+func (m *Model) loadConflictFile() {
+	m.conflictFocused = false
+	m.conflictHunk = 0
+	m.conflictOffset = 0
+	m.conflictFile = nil
+	if m.conflictCursor >= len(m.conflicts) {
+		return
+	}
+
+	f, err := getConflictFile(m.conflicts[m.conflictCursor])
+	if err != nil {
+		m.setError("loading conflicts failed: " + err.Error())
+		return
+	}
+	m.conflictFile = f
+}
+
+// moves to the next or previous conflict hunk and scrolls it into view
+func (m *Model) moveConflictHunk(delta int) {
+	if m.conflictFile == nil || len(m.conflictFile.Hunks) == 0 {
+		return
+	}
+	m.conflictHunk = min(max(m.conflictHunk+delta, 0), len(m.conflictFile.Hunks)-1)
+	m.conflictOffset = m.conflictHunkRow(m.conflictHunk)
+}
+
+// resolves the focused hunk, or the whole file, with the chosen side
+func (m *Model) resolveConflict(choice string) {
+	f := m.conflictFile
+	if f == nil {
+		return
+	}
+
+	if m.conflictFocused {
+		staged, err := resolveConflictHunk(f, m.conflictHunk, choice)
+		if err != nil {
+			m.setError("resolve failed: " + err.Error())
+			return
+		}
+		if staged {
+			m.setStatus("resolved " + f.Path)
+		}
+	} else {
+		if err := resolveConflictFile(f, choice); err != nil {
+			m.setError("resolve failed: " + err.Error())
+			return
+		}
+		m.setStatus("resolved " + f.Path + " with " + choice)
+	}
+
+	hunk := m.conflictHunk
+	m.reloadConflicts()
+	// stay on the same file and hunk position while hunks remain
+	if m.conflictFile != nil && m.conflictFile.Path == f.Path && len(m.conflictFile.Hunks) > 0 {
+		m.conflictFocused = true
+		m.conflictHunk = min(hunk, len(m.conflictFile.Hunks)-1)
+		m.conflictOffset = m.conflictHunkRow(m.conflictHunk)
+	}
+}
+
+// stages a conflicted file after editing it once no markers are left
+func (m *Model) editedConflict(path string) {
+	_, w, err := openRepo()
+	if err != nil {
+		m.setError(err.Error())
+		return
+	}
+	content, err := readWorktreeFile(w, path)
+	if err != nil {
+		m.setError(err.Error())
+		return
+	}
+
+	if hasConflictMarkers(content) {
+		m.setStatus(path + " still has conflict markers")
+	} else if err := markResolved(path); err != nil {
+		m.setError("marking resolved failed: " + err.Error())
+	} else {
+		m.setStatus("resolved " + path)
+	}
+	m.reloadConflicts()
+}
+
+// stages the file under the conflict cursor as resolved, asking first if it
+// still has conflict markers
+func (m *Model) markConflictResolved() {
+	f := m.conflictFile
+	if f == nil {
+		return
+	}
+
+	if len(f.Hunks) > 0 {
+		confirmed, err := showConfirmForm(f.Path+" still has conflict markers", "stage it with the markers anyway?")
+		if err != nil || !confirmed {
+			return
+		}
+	}
+
+	if err := markResolved(f.Path); err != nil {
+		m.setError("marking resolved failed: " + err.Error())
+		return
+	}
+	m.setStatus("resolved " + f.Path)
+	m.reloadConflicts()
+}
+
+// reloads the conflicted files after a resolution, keeping the cursor in
+// bounds
+func (m *Model) reloadConflicts() {
+	m.refreshFiles()
+	m.loadConflictFile()
+}
+
+// reloads whether a merge or rebase is in progress and its conflicted
+// paths
+func (m *Model) refreshMergeState() {
+	merging, conflicts, err := getMergeState()
+	if err != nil {
+		merging, conflicts = false, nil
+	}
+
+	rebase, err := getRebaseState()
+	if err != nil {
+		rebase = nil
+	}
+
+	pick, err := getPickState()
+	if err != nil {
+		pick = nil
+	}
+
+	m.merging = merging
+	m.rebase = rebase
+	m.pick = pick
+	m.conflicts = conflicts
+	if m.conflictCursor >= len(m.conflicts) {
+		m.conflictCursor = max(len(m.conflicts)-1, 0)
+	}
+}
+
+// shows the rebase in progress, or asks for an upstream and shows the todo
+// list of a new interactive rebase
+func (m *Model) openRebase() {
+	m.refreshMergeState()
+	if m.rebase != nil {
+		m.showRebase = true
+		return
+	}
+
+	branches, err := listBranches()
+	if err != nil {
+		m.setError("listing branches failed: " + err.Error())
+		return
+	}
+	remoteBranches, err := listRemoteBranches()
+	if err != nil {
+		m.setError("listing branches failed: " + err.Error())
+		return
+	}
+
+	var candidates []string
+	for _, branch := range append(branches, remoteBranches...) {
+		if branch != m.currentBranch {
+			candidates = append(candidates, branch)
+		}
+	}
+	if len(candidates) == 0 {
+		m.setError("no other branches to rebase onto")
+		return
+	}
+	current := ""
+	if m.upstream != nil && !m.upstream.Gone {
+		current = m.upstream.Name
+	}
+
+	upstream, err := showRebaseUpstreamForm(m.currentBranch, candidates, current)
+	if err != nil || upstream == "" {
+		return
+	}
+
+	steps, onto, err := getRebaseTodo(upstream)
+	if err != nil {
+		m.setError("rebase failed: " + err.Error())
+		return
+	}
+	if len(steps) == 0 {
+		m.setStatus(m.currentBranch + " has no commits missing from " + upstream)
+		return
+	}
+
+	m.showRebase = true
+	m.rebaseSteps = steps
+	m.rebaseCursor = 0
+	m.rebaseOnto = onto
+	m.rebaseUpstream = upstream
+}
+
+// handles keys on the interactive rebase screen
+func (m Model) updateRebase(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	key := msg.String()
+	switch key {
+	case "ctrl+c", "q":
+		m.quitting = true
+		return m, tea.Quit
+	case "esc":
+		m.showRebase = false
+		if m.rebase == nil {
+			m.rebaseSteps = nil
+		}
+		return m, nil
+	}
+
+	// a rebase in progress can only be continued, skipped or aborted
+	if m.rebase != nil {
+		switch key {
+		case "c":
+			m.handleRebaseResult(continueRebase())
+		case "S":
+			m.handleRebaseResult(skipRebase())
+		case "A":
+			m.abortRebase()
+		case "M":
+			if len(m.conflicts) > 0 {
+				m.showRebase = false
+				m.openConflicts()
+			}
+		}
+		return m, nil
+	}
+
+	switch key {
+	case "up", "k":
+		if m.rebaseCursor > 0 {
+			m.rebaseCursor--
+		}
+	case "down", "j":
+		if m.rebaseCursor < len(m.rebaseSteps)-1 {
+			m.rebaseCursor++
+		}
+	case "K":
+		if m.rebaseCursor > 0 {
+			m.rebaseSteps[m.rebaseCursor], m.rebaseSteps[m.rebaseCursor-1] = m.rebaseSteps[m.rebaseCursor-1], m.rebaseSteps[m.rebaseCursor]
+			m.rebaseCursor--
+		}
+	case "J":
+		if m.rebaseCursor < len(m.rebaseSteps)-1 {
+			m.rebaseSteps[m.rebaseCursor], m.rebaseSteps[m.rebaseCursor+1] = m.rebaseSteps[m.rebaseCursor+1], m.rebaseSteps[m.rebaseCursor]
+			m.rebaseCursor++
+		}
+	case "p", "e", "s", "f", "d":
+		for _, action := range rebaseActions {
+			if action[:1] == key {
+				m.rebaseSteps[m.rebaseCursor].Action = action
+			}
+		}
+	case "r":
+		m.rewordStep()
+	case "enter":
+		m.handleRebaseResult(startRebase(m.rebaseOnto, m.rebaseSteps))
+		if m.rebase == nil && m.statusIsError {
+			// the rebase did not start, keep the todo list for another try
+			m.showRebase = true
+		} else {
+			m.rebaseSteps = nil
+		}
+	}
+	return m, nil
+}
+
+// marks the step under the cursor as reword, asking for its new message
+func (m *Model) rewordStep() {
+	step := &m.rebaseSteps[m.rebaseCursor]
+
+	message := step.Message
+	if message == "" {
+		detail, err := getCommitDetail(plumbing.NewHash(step.Hash))
+		if err != nil {
+			m.setError(err.Error())
+			return
+		}
+		message = detail.Message
+	}
+
+	message, err := showRewordForm(message)
+	if err != nil {
+		return
+	}
+	step.Action = rebaseReword
+	step.Message = message
+}
+
+// reports where a rebase run ended and shows what needs attention next
+func (m *Model) handleRebaseResult(result *RebaseResult, err error) {
+	if current, cerr := getCurrentBranch(); cerr == nil {
+		m.currentBranch = current
+	}
+	m.refreshFiles()
+	m.refreshUpstream()
+
+	switch {
+	case err != nil:
+		m.setError("rebase failed: " + err.Error())
+		m.showRebase = m.rebase != nil
+	case result.Done:
+		m.setStatus("rebased " + m.currentBranch + " successfully")
+		m.showRebase = false
+	case result.Stopped == "conflict":
+		m.setError(fmt.Sprintf("rebase stopped at %s with %d conflict(s), resolve them and continue", result.Step.ShortHash(), len(result.Conflicts)))
+		m.showRebase = false
+		m.openConflicts()
+	case result.Stopped == "edit":
+		m.setStatus("stopped at " + result.Step.ShortHash() + " to edit, commit your changes and continue with i")
+		m.showRebase = false
+	}
+}
+
+// asks before abandoning the rebase in progress
+func (m *Model) abortRebase() {
+	confirmed, err := showConfirmForm("abort the rebase?", "the branch, index and worktree will be put back as before the rebase")
+	if err != nil || !confirmed {
+		return
+	}
+
+	if err := abortRebase(); err != nil {
+		m.setError("abort rebase failed: " + err.Error())
+		return
+	}
+	m.setStatus("rebase aborted")
+	m.showRebase = false
+	m.showConflicts = false
+	m.conflictFile = nil
+	if current, err := getCurrentBranch(); err == nil {
+		m.currentBranch = current
+	}
+	m.refreshFiles()
+	m.refreshUpstream()
+}
+
+// shows the tag list
+func (m *Model) openTags() {
+	m.showTags = true
+	m.tagCursor = 0
+	m.reloadTags()
+}
+
+// handles keys on the tag list screen
+func (m Model) updateTags(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "q":
+		m.quitting = true
+		return m, tea.Quit
+	case "esc":
+		m.showTags = false
+	case "up", "k":
+		if m.tagCursor > 0 {
+			m.tagCursor--
+		}
+	case "down", "j":
+		if m.tagCursor < len(m.tags)-1 {
+			m.tagCursor++
+		}
+	case "enter":
+		if m.tagCursor < len(m.tags) {
+			m.openCommitDetail(m.tags[m.tagCursor].Target)
+		}
+	case "n":
+		m.createTagForm("HEAD", "HEAD")
+	case "d":
+		if m.tagCursor < len(m.tags) {
+			m.deleteTagAtCursor()
+		}
+	case "P":
+		if m.tagCursor < len(m.tags) && m.remoteOp == "" {
+			name := m.tags[m.tagCursor].Name
+			return m, m.runRemoteOp("push tag "+name, func(w io.Writer) error {
+				return pushTag(name, w)
+			})
+		}
+	}
+	return m, nil
+}
+
+// asks for a tag name and message and tags rev; returns whether a tag was
+// created
+func (m *Model) createTagForm(rev, label string) bool {
+	name, message, err := showTagForm(label)
+	if err != nil || name == "" {
+		return false
+	}
+
+	if err := createTag(name, rev, message); err != nil {
+		m.setError("tag failed: " + err.Error())
+		return false
+	}
+	m.setStatus("created tag " + name + " at " + label)
+	if m.showTags {
+		m.reloadTags()
+		for i, t := range m.tags {
+			if t.Name == name {
+				m.tagCursor = i
+			}
+		}
+	}
+	return true
+}
+
+// deletes the tag under the cursor after asking
+func (m *Model) deleteTagAtCursor() {
+	tag := m.tags[m.tagCursor]
+
+	confirmed, err := showConfirmForm("delete tag "+tag.Name+"?", "it points at "+tag.ShortHash()+" "+tag.Subject)
+	if err != nil || !confirmed {
+		return
+	}
+
+	if err := deleteTag(tag.Name); err != nil {
+		m.setError("delete tag failed: " + err.Error())
+	} else {
+		m.setStatus("deleted tag " + tag.Name)
+	}
+	m.reloadTags()
+}
+
+// reloads the tag list, keeping the cursor in bounds
+func (m *Model) reloadTags() {
+	tags, err := listTags()
+	if err != nil {
+		m.setError("listing tags failed: " + err.Error())
+		tags = nil
+	}
+	m.tags = tags
+	if m.tagCursor >= len(m.tags) {
+		m.tagCursor = max(len(m.tags)-1, 0)
+	}
+}
+
+// shows the stash list with a preview of the first stash
+func (m *Model) openStashList() {
+	m.showStash = true
+	m.stashCursor = 0
+	m.reloadStashes()
+}
+
+// handles keys on the stash list screen
+func (m Model) updateStash(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "q":
+		m.quitting = true
+		return m, tea.Quit
+	case "esc":
+		m.showStash = false
+		m.stashDiff = nil
+	case "up", "k":
+		if m.stashCursor > 0 {
+			m.stashCursor--
+			m.loadStashDiff()
+		}
+	case "down", "j":
+		if m.stashCursor < len(m.stashes)-1 {
+			m.stashCursor++
+			m.loadStashDiff()
+		}
+	case "ctrl+d":
+		m.stashOffset += m.diffPaneHeight() / 2
+	case "ctrl+u":
+		m.stashOffset = max(m.stashOffset-m.diffPaneHeight()/2, 0)
+	case "n":
+		m.saveStashForm()
+	case "a", "p":
+		if m.stashCursor < len(m.stashes) {
+			m.applyStashAtCursor(msg.String() == "p")
+		}
+	case "d":
+		if m.stashCursor < len(m.stashes) {
+			m.dropStashAtCursor()
+		}
+	}
+	return m, nil
+}
+
+// the paths of the selected files, each once even if listed twice
+func (m *Model) selectedPaths() []string {
+	var selected []string
+	for _, file := range m.files {
+		if file.Selected && !slices.Contains(selected, file.Path) {
+			selected = append(selected, file.Path)
+		}
+	}
+	return selected
+}
+
+// asks what to stash and saves it
+func (m *Model) saveStashForm() {
+	selected := m.selectedPaths()
+
+	message, includeUntracked, onlySelected, err := showStashForm(len(selected))
+	if err != nil {
+		return
+	}
+	if !onlySelected {
+		selected = nil
+	}
+
+	if err := saveStash(message, includeUntracked, selected); err != nil {
+		m.setError("stash failed: " + err.Error())
+		return
+	}
+	m.setStatus("saved working directory changes to stash@{0}")
+	m.stashCursor = 0
+	m.refreshFiles()
+	m.reloadStashes()
+}
+
+// applies or pops the stash under the cursor
+func (m *Model) applyStashAtCursor(pop bool) {
+	stash := m.stashes[m.stashCursor]
+
+	conflicts, err := applyStash(stash.Index, pop)
+	m.refreshFiles()
+	m.reloadStashes()
+	switch {
+	case err != nil:
+		m.setError("applying " + stash.Name() + " failed: " + err.Error())
+	case len(conflicts) > 0:
+		kept := "the stash was kept"
+		if pop {
+			kept = "resolve them and press c to drop the stash"
+		}
+		m.setError(fmt.Sprintf("%s applied with %d conflict(s), %s", stash.Name(), len(conflicts), kept))
+		m.showStash = false
+		m.openConflicts()
+	case pop:
+		m.setStatus("popped " + stash.Name())
+	default:
+		m.setStatus("applied " + stash.Name())
+	}
+}
+
+// drops the stash under the cursor after asking
+func (m *Model) dropStashAtCursor() {
+	stash := m.stashes[m.stashCursor]
+
+	confirmed, err := showConfirmForm("drop "+stash.Name()+"?", stash.Message)
+	if err != nil || !confirmed {
+		return
+	}
+
+	if err := dropStash(stash.Index); err != nil {
+		m.setError("drop failed: " + err.Error())
+	} else {
+		m.setStatus("dropped " + stash.Name())
+	}
+	m.reloadStashes()
+}
+
+// reloads the stash list, keeping the cursor in bounds
+func (m *Model) reloadStashes() {
+	stashes, err := listStashes()
+	if err != nil {
+		m.setError("listing stashes failed: " + err.Error())
+		stashes = nil
+	}
+	m.stashes = stashes
+	if m.stashCursor >= len(m.stashes) {
+		m.stashCursor = max(len(m.stashes)-1, 0)
+	}
+	m.loadStashDiff()
+}
+
+// loads the preview of the stash under the cursor
+func (m *Model) loadStashDiff() {
+	m.stashOffset = 0
+	m.stashDiff = nil
+	if m.stashCursor >= len(m.stashes) {
+		return
+	}
+
+	diffs, err := getStashDiff(m.stashes[m.stashCursor].Index)
+	if err != nil {
+		m.setError("loading stash failed: " + err.Error())
+		return
+	}
+	m.stashDiff = diffs
+}
+
+// reloads the branch list after a branch was changed, keeping the cursor
+// in bounds
+func (m *Model) reloadBranches() {
+	branches, err := listBranches()
+	if err != nil {
+		branches = []string{}
+	}
+	m.branches = branches
+	if m.branchListCursor >= len(m.branches) {
+		m.branchListCursor = max(len(m.branches)-1, 0)
+	}
+	m.loadBranchUpstreams()
+}
+
+// reloads the tracking info of the current branch
+func (m *Model) refreshUpstream() {
+	upstream, err := getUpstream(m.currentBranch)
+	if err != nil {
+		upstream = nil
+	}
+	m.upstream = upstream
+}
+
+// loads the tracking info of every branch in the branch list
+func (m *Model) loadBranchUpstreams() {
+	m.branchUpstreams = map[string]*UpstreamInfo{}
+	for _, branch := range m.branches {
+		if upstream, err := getUpstream(branch); err == nil && upstream != nil {
+			m.branchUpstreams[branch] = upstream
+		}
+	}
+}
+
+// shows the upstream form and sets the upstream of a local branch
+func (m *Model) setUpstreamForm(branch string) {
+	remoteBranches, err := listRemoteBranches()
+	if err != nil {
+		m.setError("set upstream failed: " + err.Error())
+		return
+	}
+
+	// offer a branch of the same name on each remote, even before it exists
+	remotes, _ := listRemotes()
+	for _, remote := range remotes {
+		name := remote + "/" + branch
+		if !slices.Contains(remoteBranches, name) {
+			remoteBranches = append(remoteBranches, name)
+		}
+	}
+
+	upstream, err := showUpstreamForm(branch, remoteBranches)
+	if err != nil || upstream == "" {
+		return
+	}
+
+	if err := setUpstream(branch, upstream); err != nil {
+		m.setError("set upstream failed: " + err.Error())
+	} else {
+		m.setStatus(branch + " now tracks " + upstream)
+	}
+	m.refreshUpstream()
+	if m.showBranchList {
+		m.loadBranchUpstreams()
+	}
+}
+
+// removes the upstream of a local branch
+func (m *Model) unsetUpstreamOf(branch string) {
+	if err := unsetUpstream(branch); err != nil {
+		m.setError("unset upstream failed: " + err.Error())
+	} else {
+		m.setStatus("upstream of " + branch + " removed")
+	}
+	m.refreshUpstream()
+	if m.showBranchList {
+		m.loadBranchUpstreams()
+	}
+}
+
+// shows an informational message in the status line
+func (m *Model) setStatus(message string) {
+	m.statusMessage = message
+	m.statusIsError = false
+}
+
+// shows an error in the status line
+func (m *Model) setError(message string) {
+	m.statusMessage = message
+	m.statusIsError = true
+}
+
+// shows create branch form and creates branch
+func (m *Model) createBranchForm() tea.Cmd {
+	branchName, err := showCreateBranchForm()
+	if err != nil {
+		// Handle error - could show error message here
+		return nil
+	}
+	if branchName != "" {
+		return tea.Cmd(func() tea.Msg {
+			err := createBranch(branchName)
+			if err != nil {
+				return createBranchErrorMsg{err: err}
+			}
+			return createBranchCompleteMsg{branchName: branchName}
+		})
+	}
+	return nil
+}
+
+// shows switch branch form and switches branch
+func (m *Model) switchBranchForm() tea.Cmd {
+	branches, err := listBranches()
+	if err != nil {
+		return nil
+	}
+	branchName, err := showBranchSelectionForm(branches)
+	if err != nil {
+		return nil
+	}
+	if branchName != "" && branchName != m.currentBranch {
+		return m.switchTo(branchName)
+	}
+	return nil
+}
+
+// switches to a branch, first asking what to do with local changes
+func (m *Model) switchTo(branchName string) tea.Cmd {
+	check, err := checkSwitch(branchName)
+	if err != nil {
+		m.setError("cannot switch to " + branchName + ": " + err.Error())
+		return nil
+	}
+
+	mode := switchCarry
+	if check.NeedsChoice() {
+		mode, err = showSwitchChangesForm(branchName, check)
+		if err != nil || mode == "" {
+			return nil
+		}
+	}
+	if mode == switchDiscard {
+		paths := append(append([]string(nil), check.Dirty...), check.Untracked...)
+		confirmed, err := showConfirmForm("discard local changes?", "these files lose their changes: "+strings.Join(paths, ", ")+"\nthey are saved in .git/got/trash first, D restores them")
+		if err != nil || !confirmed {
+			return nil
+		}
+	}
+
+	m.switchingBranch = true
+	return tea.Cmd(func() tea.Msg {
+		conflicts, err := switchBranch(branchName, mode)
+		if err != nil {
+			return switchBranchErrorMsg{err: err}
+		}
+		return switchBranchCompleteMsg{branchName: branchName, conflicts: conflicts}
+	})
 }
 
 // shows local repo form and initializes repository
@@ -27,6 +27,8 @@ var (
 			return lipgloss.NewStyle().Foreground(lipgloss.Color("1")) // red
 		case "untracked":
 			return lipgloss.NewStyle().Foreground(lipgloss.Color("3")) // yellow
+		case "conflicted":
+			return lipgloss.NewStyle().Foreground(lipgloss.Color("5")) // magenta
 		default:
 			return lipgloss.NewStyle()
 		}
@@ -35,6 +37,71 @@ var (
 	helpStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("240")).
 			MarginTop(1)
+
+	diffAddStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("2"))
+
+	diffDelStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("1"))
+
+	diffHunkStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("6"))
+
+	hashStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("3"))
+
+	authorStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("4"))
+
+	progressStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("6"))
+
+	errorStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("1"))
+
+	signatureStyle = func(signature string) lipgloss.Style {
+		if signature == signatureVerified {
+			return lipgloss.NewStyle().Foreground(lipgloss.Color("2")) // green
+		}
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("3")) // yellow
+	}
+
+	upstreamStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("6"))
+
+	refStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("5"))
+
+	actionStyle = func(action string) lipgloss.Style {
+		switch action {
+		case "added":
+			return lipgloss.NewStyle().Foreground(lipgloss.Color("2")) // green
+		case "deleted":
+			return lipgloss.NewStyle().Foreground(lipgloss.Color("1")) // red
+		case "modified":
+			return lipgloss.NewStyle().Foreground(lipgloss.Color("3")) // yellow
+		default:
+			return lipgloss.NewStyle()
+		}
+	}
+
+	paneStyle = func(focused bool) lipgloss.Style {
+		color := lipgloss.Color("240")
+		if focused {
+			color = lipgloss.Color("39")
+		}
+		return lipgloss.NewStyle().
+			Border(lipgloss.NormalBorder(), false, false, false, true).
+			BorderForeground(color).
+			PaddingLeft(1)
+	}
+)
+
+// fallback terminal size until the first window size message arrives
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
 )
 
 // initial menu for repo setup
@@ -75,13 +142,19 @@ func (m Model) renderBranchMenu() string {
 
 	b.WriteString(titleStyle.Render("branch management"))
 	b.WriteString("\n\n")
-	b.WriteString("current branch: " + m.currentBranch + "\n\n")
+	b.WriteString("current branch: " + m.currentBranch + "\n")
+	if m.upstream != nil {
+		b.WriteString("upstream: " + formatUpstream(m.upstream) + "\n")
+	}
+	b.WriteString("\n")
 	b.WriteString("choose an option:\n\n")
 	b.WriteString("1. create new branch\n")
 	b.WriteString("2. switch branch\n")
 	b.WriteString("3. list all branches\n")
+	b.WriteString("4. set upstream of current branch\n")
+	b.WriteString("5. unset upstream of current branch\n")
 	b.WriteString("esc. back to main menu\n\n")
-	b.WriteString(helpStyle.Render("press 1, 2, 3, or esc"))
+	b.WriteString(helpStyle.Render("press 1-5, or esc"))
 
 	return b.String()
 }
@@ -107,6 +180,9 @@ func (m Model) renderBranchList() string {
 			if branch == m.currentBranch {
 				branchDisplay = selectedStyle.Render("* " + branch)
 			}
+			if upstream, ok := m.branchUpstreams[branch]; ok {
+				branchDisplay += " " + upstreamStyle.Render("["+formatUpstream(upstream)+"]")
+			}
 
 			line := fmt.Sprintf("%s %s", cursor, branchDisplay)
 			if m.branchListCursor == i {
@@ -119,7 +195,481 @@ func (m Model) renderBranchList() string {
 	}
 
 	b.WriteString("\n")
-	b.WriteString(helpStyle.Render("↑/↓ or j/k: navigate • enter: switch to branch • esc: back"))
+	for _, line := range m.statusLines() {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString(helpStyle.Render("↑/↓ or j/k: navigate • enter: switch to branch • m: merge into current • d: delete • r: rename • R: reset to commit • u/U: set/unset upstream • esc: back"))
+
+	return b.String()
+}
+
+// output of the hooks run around a commit
+func (m Model) renderHooks() string {
+	var b strings.Builder
+
+	title := m.hookOp + " hooks"
+	if m.hookOp == "" {
+		title = "hook output"
+	}
+	b.WriteString(titleStyle.Render(title))
+	b.WriteString("\n\n")
+
+	if m.hookOp != "" {
+		b.WriteString(progressStyle.Render("running hooks...") + "\n")
+	}
+	height := m.diffPaneHeight()
+	for i := m.hookOffset; i < len(m.hookOutput) && i-m.hookOffset < height; i++ {
+		b.WriteString(lipgloss.NewStyle().MaxWidth(m.viewWidth()).Render(m.hookOutput[i]))
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	for _, line := range m.statusLines() {
+		b.WriteString(lipgloss.NewStyle().MaxWidth(m.viewWidth()).Render(line))
+		b.WriteString("\n")
+	}
+	if m.hookOp == "" {
+		b.WriteString(helpStyle.Render("↑/↓ or j/k: scroll • ctrl+d/u: page • esc: back"))
+	} else {
+		b.WriteString(helpStyle.Render("↑/↓ or j/k: scroll • ctrl+d/u: page"))
+	}
+
+	return b.String()
+}
+
+// commit log screen
+func (m Model) renderLog() string {
+	var b strings.Builder
+
+	title := "history (" + m.currentBranch + ")"
+	if m.logAll {
+		title = "history (all refs)"
+	}
+	b.WriteString(titleStyle.Render(title))
+	b.WriteString("\n\n")
+
+	if len(m.commits) == 0 {
+		b.WriteString("no commits yet.\n")
+	} else {
+		height := m.diffPaneHeight()
+		offset := max(m.logCursor-height+1, 0)
+		for i := offset; i < len(m.commits) && i-offset < height; i++ {
+			c := m.commits[i]
+
+			cursor := " "
+			if m.logCursor == i {
+				cursor = cursorStyle.Render(">")
+			}
+
+			subject := c.Subject
+			if m.logCursor == i {
+				subject = cursorStyle.Render(subject)
+			}
+			if labels := m.refLabels[c.Hash]; len(labels) > 0 {
+				subject = refStyle.Render("("+strings.Join(labels, ", ")+")") + " " + subject
+			}
+			if c.Signature != signatureNone {
+				subject = signatureStyle(c.Signature).Render("["+c.Signature+"]") + " " + subject
+			}
+
+			line := fmt.Sprintf("%s %s%s %s %s %s",
+				cursor,
+				m.graphRows[i],
+				hashStyle.Render(c.ShortHash()),
+				c.When.Format("2006-01-02"),
+				authorStyle.Render(c.Author),
+				subject)
+			line = lipgloss.NewStyle().MaxWidth(m.viewWidth()).Render(line)
+
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+		if m.logHasMore {
+			b.WriteString(helpStyle.UnsetMarginTop().Render("  ..."))
+			b.WriteString("\n")
+		}
+	}
+
+	b.WriteString("\n")
+	for _, line := range m.statusLines() {
+		b.WriteString(lipgloss.NewStyle().MaxWidth(m.viewWidth()).Render(line))
+		b.WriteString("\n")
+	}
+	b.WriteString(helpStyle.Render("↑/↓ or j/k: navigate • enter: show commit • a: toggle all refs • t: tag commit • c: cherry-pick • r: revert • R: reset HEAD here • U: undo reset • esc: back"))
+
+	return b.String()
+}
+
+// commit detail screen with message, changed files and per-file diff
+func (m Model) renderCommitDetail() string {
+	var b strings.Builder
+
+	d := m.commitDetail
+	b.WriteString(titleStyle.Render("commit " + hashStyle.Render(d.ShortHash())))
+	b.WriteString("\n\n")
+	b.WriteString("commit " + d.Hash.String() + "\n")
+	b.WriteString("author: " + authorStyle.Render(d.Author) + " <" + d.Email + ">\n")
+	if committer := d.Author + " <" + d.Email + ">"; d.Committer != committer {
+		b.WriteString("committer: " + d.Committer + "\n")
+	}
+	if d.Signature != signatureNone {
+		b.WriteString("signature: " + signatureStyle(d.Signature).Render(d.Signature) + "\n")
+	}
+	b.WriteString("date: " + d.When.Format("Mon Jan 2 15:04:05 2006 -0700") + "\n\n")
+	for _, line := range strings.Split(d.Message, "\n") {
+		b.WriteString("    " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	var files strings.Builder
+	if len(d.Files) == 0 {
+		files.WriteString("no files changed.\n")
+	}
+	for i, f := range d.Files {
+		cursor := " "
+		if m.detailCursor == i {
+			cursor = cursorStyle.Render(">")
+		}
+		line := fmt.Sprintf("%s %s %s", cursor, actionStyle(f.Action).Render(fmt.Sprintf("[%s]", f.Action)), f.Path)
+		if m.detailCursor == i {
+			line = cursorStyle.Render(line)
+		}
+		files.WriteString(line)
+		files.WriteString("\n")
+	}
+
+	listWidth, diffWidth := m.paneWidths()
+	list := lipgloss.NewStyle().MaxWidth(listWidth).Render(files.String())
+	list = lipgloss.NewStyle().Width(listWidth).Render(list)
+
+	var pane string
+	if m.detailDiff == nil {
+		pane = renderPane("", nil, 0, diffWidth, m.detailPaneHeight(), false)
+	} else {
+		var rows []string
+		for _, line := range m.detailDiff.Lines() {
+			rows = append(rows, renderDiffLine(line))
+		}
+		title := actionStyle(m.detailDiff.Status).Render(m.detailDiff.Path)
+		pane = renderPane(title, rows, m.detailOffset, diffWidth, m.detailPaneHeight(), false)
+	}
+
+	b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, list, pane))
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("↑/↓ or j/k: select file • ctrl+d/ctrl+u: scroll diff • esc: back to log"))
+
+	return b.String()
+}
+
+// merge in progress screen with the conflicted files and the conflict hunks
+// of the one under the cursor, ours, base and theirs side by side
+func (m Model) renderConflicts() string {
+	var b strings.Builder
+
+	title := "merging into " + m.currentBranch
+	if !m.merging && m.rebase == nil && m.pick == nil {
+		title = "applying stashed changes onto " + m.currentBranch
+	} else if m.rebase != nil {
+		title = "rebasing " + m.rebase.Branch
+	} else if m.pick != nil {
+		title = m.pick.Action + " of " + m.pick.Commit.String()[:7] + " onto " + m.currentBranch
+	}
+	b.WriteString(titleStyle.Render(title))
+	b.WriteString("\n\n")
+
+	var files strings.Builder
+	if len(m.conflicts) == 0 {
+		files.WriteString("all conflicts resolved,\npress c to carry on.\n")
+	}
+	for i, c := range m.conflicts {
+		cursor := " "
+		if m.conflictCursor == i {
+			cursor = cursorStyle.Render(">")
+		}
+		line := fmt.Sprintf("%s %s %s", cursor, statusStyle("conflicted").Render(fmt.Sprintf("[%s]", c.Kind)), c.Path)
+		if m.conflictCursor == i {
+			line = cursorStyle.Render(line)
+		}
+		files.WriteString(line)
+		files.WriteString("\n")
+	}
+
+	// the three columns need more room than a diff
+	width := m.viewWidth()
+	listWidth := max(width/4, 24)
+	paneWidth := max(width-listWidth, 10)
+	list := lipgloss.NewStyle().MaxWidth(listWidth).Render(files.String())
+	list = lipgloss.NewStyle().Width(listWidth).Render(list)
+
+	var pane string
+	if m.conflictFile == nil {
+		pane = renderPane("", nil, 0, paneWidth, m.diffPaneHeight(), false)
+	} else {
+		title := statusStyle("conflicted").Render(m.conflictFile.Path) + " " + m.conflictFile.Kind
+		pane = renderPane(title, m.conflictRows(paneWidth-2), m.conflictOffset, paneWidth, m.diffPaneHeight(), m.conflictFocused)
+	}
+
+	b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, list, pane))
+	b.WriteString("\n")
+	for _, line := range m.statusLines() {
+		b.WriteString(lipgloss.NewStyle().MaxWidth(m.viewWidth()).Render(line))
+		b.WriteString("\n")
+	}
+	if m.conflictFocused {
+		b.WriteString(helpStyle.Render("↑/↓ or j/k: next/prev conflict • o/t/B: take ours/theirs/both • e: edit • ctrl+d/ctrl+u: scroll • esc: back to files"))
+	} else {
+		b.WriteString(helpStyle.Render("↑/↓ or j/k: navigate • tab: focus conflicts • o/t/B: whole file ours/theirs/both • e: edit • s: mark resolved • c: conclude/continue • A: abort • esc: back"))
+	}
+
+	return b.String()
+}
+
+// rows of the conflict pane, one block per conflict hunk with ours, base
+// and theirs in columns
+func (m Model) conflictRows(width int) []string {
+	f := m.conflictFile
+	switch {
+	case f.Binary:
+		return []string{"binary file, take ours or theirs as a whole"}
+	case len(f.Hunks) == 0:
+		return []string{"no conflict markers, take ours or theirs, or mark resolved"}
+	}
+
+	colWidth := max((width-6)/3, 4)
+	cell := func(text string) string {
+		text = strings.ReplaceAll(strings.TrimRight(text, "\r\n"), "\t", "    ")
+		text = lipgloss.NewStyle().Inline(true).MaxWidth(colWidth).Render(text)
+		return text + strings.Repeat(" ", max(colWidth-lipgloss.Width(text), 0))
+	}
+	sep := helpStyle.UnsetMarginTop().Render(" │ ")
+
+	rows := []string{"  " + authorStyle.Render(cell("ours")) + sep + authorStyle.Render(cell("base")) + sep + authorStyle.Render(cell("theirs"))}
+	for i, hunk := range f.Hunks {
+		region := f.Regions[hunk]
+
+		cursor := " "
+		if m.conflictFocused && m.conflictHunk == i {
+			cursor = cursorStyle.Render(">")
+		}
+		rows = append(rows, fmt.Sprintf("%s %s", cursor, diffHunkStyle.Render(fmt.Sprintf("conflict %d/%d", i+1, len(f.Hunks)))))
+
+		for j := 0; j < conflictHunkHeight(region)-1; j++ {
+			var ours, b, theirs string
+			if j < len(region.Ours) {
+				ours = region.Ours[j]
+			}
+			if j < len(region.Base) {
+				b = region.Base[j]
+			}
+			if j < len(region.Theirs) {
+				theirs = region.Theirs[j]
+			}
+			rows = append(rows, "  "+cell(ours)+sep+cell(b)+sep+cell(theirs))
+		}
+	}
+	return rows
+}
+
+// row of the conflict pane where a conflict hunk starts
+func (m Model) conflictHunkRow(hunk int) int {
+	row := 1 // column headings
+	for i := 0; i < hunk && i < len(m.conflictFile.Hunks); i++ {
+		row += conflictHunkHeight(m.conflictFile.Regions[m.conflictFile.Hunks[i]])
+	}
+	return row
+}
+
+// rows taken by a conflict hunk: its heading and its longest side
+func conflictHunkHeight(region mergeRegion) int {
+	return 1 + max(len(region.Ours), len(region.Base), len(region.Theirs))
+}
+
+// interactive rebase screen: the editable todo list before the rebase
+// starts, its progress while it is stopped
+func (m Model) renderRebase() string {
+	var b strings.Builder
+
+	if m.rebase != nil {
+		b.WriteString(titleStyle.Render("rebasing " + m.rebase.Branch + " onto " + m.rebase.Onto[:7]))
+		b.WriteString("\n\n")
+
+		for _, step := range m.rebase.Done {
+			b.WriteString(helpStyle.UnsetMarginTop().Render(fmt.Sprintf("  %-8s %-6s %s %s", "done", step.Action, step.ShortHash(), step.Subject)))
+			b.WriteString("\n")
+		}
+		if step := m.rebase.Current; step != nil {
+			line := fmt.Sprintf("> %-8s %-6s %s %s", m.rebase.Stopped, step.Action, hashStyle.Render(step.ShortHash()), step.Subject)
+			b.WriteString(cursorStyle.Render(line))
+			b.WriteString("\n")
+		}
+		for _, step := range m.rebase.Todo {
+			b.WriteString(fmt.Sprintf("  %-8s %-6s %s %s", "todo", step.Action, hashStyle.Render(step.ShortHash()), step.Subject))
+			b.WriteString("\n")
+		}
+
+		b.WriteString("\n")
+		for _, line := range m.statusLines() {
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+		if m.rebase.Stopped == "conflict" {
+			b.WriteString(helpStyle.Render("M: resolve conflicts • c: continue • S: skip commit • A: abort • esc: back"))
+		} else {
+			b.WriteString(helpStyle.Render("c: continue • A: abort • esc: back to commit or amend changes"))
+		}
+		return b.String()
+	}
+
+	b.WriteString(titleStyle.Render("rebase " + m.currentBranch + " onto " + m.rebaseUpstream))
+	b.WriteString("\n\n")
+
+	for i, step := range m.rebaseSteps {
+		cursor := " "
+		if m.rebaseCursor == i {
+			cursor = cursorStyle.Render(">")
+		}
+
+		action := fmt.Sprintf("%-6s", step.Action)
+		switch step.Action {
+		case rebaseDrop:
+			action = errorStyle.Render(action)
+		case rebasePick:
+		default:
+			action = selectedStyle.Render(action)
+		}
+
+		subject := step.Subject
+		if step.Action == rebaseReword {
+			subject, _, _ = strings.Cut(step.Message, "\n")
+		}
+		if m.rebaseCursor == i {
+			subject = cursorStyle.Render(subject)
+		}
+
+		b.WriteString(fmt.Sprintf("%s %s %s %s", cursor, action, hashStyle.Render(step.ShortHash()), subject))
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	for _, line := range m.statusLines() {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString(helpStyle.Render("↑/↓ or j/k: navigate • J/K: move down/up • p: pick • r: reword • e: edit • s: squash • f: fixup • d: drop • enter: start • esc: cancel"))
+
+	return b.String()
+}
+
+// tag list screen, with the message of the selected annotated tag
+func (m Model) renderTags() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("tags"))
+	b.WriteString("\n\n")
+
+	if len(m.tags) == 0 {
+		b.WriteString("no tags.\n")
+	}
+	for i, tag := range m.tags {
+		cursor := " "
+		if m.tagCursor == i {
+			cursor = cursorStyle.Render(">")
+		}
+
+		name := tag.Name
+		if m.tagCursor == i {
+			name = cursorStyle.Render(name)
+		}
+		kind := "lightweight"
+		if tag.Annotated {
+			kind = "annotated"
+		}
+
+		line := fmt.Sprintf("%s %s %s %s %s %s",
+			cursor,
+			refStyle.Render(name),
+			helpStyle.UnsetMarginTop().Render("["+kind+"]"),
+			hashStyle.Render(tag.ShortHash()),
+			tag.When.Format("2006-01-02"),
+			tag.Subject)
+		b.WriteString(lipgloss.NewStyle().MaxWidth(m.viewWidth()).Render(line))
+		b.WriteString("\n")
+	}
+
+	if m.tagCursor < len(m.tags) && m.tags[m.tagCursor].Annotated {
+		tag := m.tags[m.tagCursor]
+		b.WriteString("\n")
+		b.WriteString("tagger: " + authorStyle.Render(tag.Tagger) + "\n\n")
+		for _, line := range strings.Split(tag.Message, "\n") {
+			b.WriteString("    " + line + "\n")
+		}
+	}
+
+	b.WriteString("\n")
+	for _, line := range m.statusLines() {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString(helpStyle.Render("↑/↓ or j/k: navigate • enter: show commit • n: new tag at HEAD • d: delete • P: push to remote • esc: back"))
+
+	return b.String()
+}
+
+// stash list screen with the diff of the stash under the cursor
+func (m Model) renderStashList() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("stashes"))
+	b.WriteString("\n\n")
+
+	var list strings.Builder
+	if len(m.stashes) == 0 {
+		list.WriteString("no stashes.\n")
+	}
+	for i, stash := range m.stashes {
+		cursor := " "
+		if m.stashCursor == i {
+			cursor = cursorStyle.Render(">")
+		}
+
+		// the branch is shown on its own, so drop it from the message
+		message := stash.Message
+		if _, rest, ok := strings.Cut(message, ": "); ok {
+			message = rest
+		}
+		if m.stashCursor == i {
+			message = cursorStyle.Render(message)
+		}
+
+		list.WriteString(fmt.Sprintf("%s %s %s %s %s\n", cursor, hashStyle.Render(stash.Name()), stash.When.Format("2006-01-02"), authorStyle.Render(stash.Branch), message))
+	}
+
+	listWidth, diffWidth := m.paneWidths()
+	left := lipgloss.NewStyle().MaxWidth(listWidth).Render(list.String())
+	left = lipgloss.NewStyle().Width(listWidth).Render(left)
+
+	var pane string
+	if m.stashCursor < len(m.stashes) {
+		var rows []string
+		for _, d := range m.stashDiff {
+			rows = append(rows, actionStyle(d.Status).Render(fmt.Sprintf("[%s] %s", d.Status, d.Path)))
+			for _, line := range d.Lines() {
+				rows = append(rows, renderDiffLine(line))
+			}
+		}
+		pane = renderPane(m.stashes[m.stashCursor].Name(), rows, m.stashOffset, diffWidth, m.diffPaneHeight(), false)
+	} else {
+		pane = renderPane("", nil, 0, diffWidth, m.diffPaneHeight(), false)
+	}
+
+	b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, left, pane))
+	b.WriteString("\n")
+	for _, line := range m.statusLines() {
+		b.WriteString(lipgloss.NewStyle().MaxWidth(m.viewWidth()).Render(line))
+		b.WriteString("\n")
+	}
+	b.WriteString(helpStyle.Render("↑/↓ or j/k: navigate • n: new stash • a: apply • p: pop • d: drop • ctrl+d/ctrl+u: scroll • esc: back"))
 
 	return b.String()
 }
@@ -129,6 +679,34 @@ func (m Model) View() string {
 		return "goodbye!\n"
 	}
 
+	if m.showCommitDetail && m.commitDetail != nil {
+		return m.renderCommitDetail()
+	}
+
+	if m.showLog {
+		return m.renderLog()
+	}
+
+	if m.showConflicts {
+		return m.renderConflicts()
+	}
+
+	if m.showRebase {
+		return m.renderRebase()
+	}
+
+	if m.showStash {
+		return m.renderStashList()
+	}
+
+	if m.showTags {
+		return m.renderTags()
+	}
+
+	if m.showHooks {
+		return m.renderHooks()
+	}
+
 	if m.showInitMenu {
 		return m.renderInitMenu()
 	}
@@ -165,43 +743,247 @@ func (m Model) View() string {
 
 	title := "got"
 	if m.currentBranch != "" {
-		title += " (" + m.currentBranch + ")"
+		branch := m.currentBranch
+		if m.upstream != nil {
+			branch += " → " + formatUpstream(m.upstream)
+		}
+		title += " (" + branch + ")"
+	}
+	if m.merging {
+		title += " " + errorStyle.Render("[merging]")
+	}
+	if m.rebase != nil {
+		title += " " + errorStyle.Render("[rebasing "+m.rebase.Branch+"]")
+	}
+	if m.pick != nil {
+		title += " " + errorStyle.Render("["+m.pick.Action+" "+m.pick.Commit.String()[:7]+"]")
 	}
 	b.WriteString(titleStyle.Render(title))
 	b.WriteString("\n\n")
 
+	listWidth, diffWidth := m.paneWidths()
+	list := lipgloss.NewStyle().MaxWidth(listWidth).Render(m.renderFileList())
+	list = lipgloss.NewStyle().Width(listWidth).Render(list)
+	b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, list, m.renderDiffPane(diffWidth, m.diffPaneHeight())))
+	b.WriteString("\n")
+	for _, line := range m.statusLines() {
+		b.WriteString(lipgloss.NewStyle().MaxWidth(m.viewWidth()).Render(line))
+		b.WriteString("\n")
+	}
+
+	if m.rebase != nil && !m.diffFocused {
+		b.WriteString(helpStyle.Render("i: rebase progress • M: conflicts • s: stage • c: commit • q: quit"))
+	} else if (m.merging || m.pick != nil) && !m.diffFocused {
+		b.WriteString(helpStyle.Render("M: conflicts • s: stage resolved file • c: commit • q: quit"))
+	} else if len(m.files) == 0 {
+		b.WriteString(helpStyle.Render("b: branches • l: log • i: rebase • z: stash • t: tags • c: commit • C: commit selected • a: amend • I: identity • f/p/P: fetch/pull/push • q: quit"))
+	} else if m.diffFocused {
+		unit := "hunk"
+		if m.lineMode {
+			unit = "line"
+		}
+		b.WriteString(helpStyle.Render(fmt.Sprintf("↑/↓ or j/k: next/prev %[1]s • space: toggle %[1]s • v: hunk/line mode • s: stage • u: unstage • d: discard • ctrl+d/ctrl+u: scroll • tab/esc: back to files • q: quit", unit)))
+	} else {
+		b.WriteString(helpStyle.Render("↑/↓ or j/k: navigate • space: toggle selection • s: stage selected • u: unstage selected • d: discard selected • D: restore discard • tab: focus diff • b: branches • l: log • i: rebase • z: stash • t: tags • c: commit • C: commit selected • a: amend • I: identity • f/p/P: fetch/pull/push • q: quit"))
+	}
+
+	return b.String()
+}
+
+// file list on the left side of the main view
+func (m Model) renderFileList() string {
+	var b strings.Builder
+
 	if len(m.files) == 0 {
 		b.WriteString("no changes to stage.\n")
+		return b.String()
+	}
+
+	for i, file := range m.files {
+		cursor := " "
+		if m.cursor == i {
+			cursor = cursorStyle.Render(">")
+		}
+
+		checkbox := "[ ]"
+		if file.Selected {
+			checkbox = selectedStyle.Render("[x]")
+		}
+
+		status := statusStyle(file.Status).Render(fmt.Sprintf("[%s]", file.Status))
+		line := fmt.Sprintf("%s %s %s %s", cursor, checkbox, status, file.Path)
+
+		if m.cursor == i {
+			line = cursorStyle.Render(line)
+		}
+
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// diff of the file under the cursor on the right side of the main view
+func (m Model) renderDiffPane(width, height int) string {
+	if m.diff == nil {
+		return renderPane("", nil, 0, width, height, m.diffFocused)
+	}
+
+	title := statusStyle(m.diff.Status).Render(m.diff.Path)
+	return renderPane(title, m.diffRows(), m.diffOffset, width, height, m.diffFocused)
+}
+
+// bordered pane showing a title and a scrolled window of rows
+func renderPane(title string, rows []string, offset, width, height int, focused bool) string {
+	var b strings.Builder
+
+	if title == "" {
+		b.WriteString(helpStyle.UnsetMarginTop().Render("no diff to show"))
 	} else {
-		for i, file := range m.files {
+		b.WriteString(title)
+		b.WriteString("\n")
+
+		offset = min(offset, max(len(rows)-height+1, 0))
+		for i := offset; i < len(rows) && i-offset < height-1; i++ {
+			b.WriteString(rows[i])
+			b.WriteString("\n")
+		}
+	}
+
+	return paneStyle(focused).
+		MaxWidth(width).
+		Height(height).
+		MaxHeight(height).
+		Render(strings.TrimSuffix(b.String(), "\n"))
+}
+
+// styled rows of the diff pane, marking the cursor and selected lines
+func (m Model) diffRows() []string {
+	if m.diff.Binary {
+		return m.diff.Lines()
+	}
+
+	var rows []string
+	for i, h := range m.diff.Hunks {
+		cursor := " "
+		if m.diffFocused && !m.lineMode && m.hunkCursor == i {
+			cursor = cursorStyle.Render(">")
+		}
+		checkbox := "[ ]"
+		switch h.selectedCount() {
+		case 0:
+		case h.changeCount():
+			checkbox = selectedStyle.Render("[x]")
+		default:
+			checkbox = selectedStyle.Render("[~]")
+		}
+		rows = append(rows, fmt.Sprintf("%s %s %s", cursor, checkbox, diffHunkStyle.Render(h.Header())))
+
+		for j, l := range h.Lines {
 			cursor := " "
-			if m.cursor == i {
+			if m.diffFocused && m.lineMode && m.hunkCursor == i && m.lineCursor == j {
 				cursor = cursorStyle.Render(">")
 			}
-
-			checkbox := "[ ]"
-			if file.Selected {
-				checkbox = selectedStyle.Render("[x]")
+			mark := " "
+			if l.Selected {
+				mark = selectedStyle.Render("*")
 			}
+			rows = append(rows, fmt.Sprintf("%s %s %s", cursor, mark, renderDiffLine(string(l.Kind)+strings.TrimRight(l.Text, "\n"))))
+		}
+	}
+	return rows
+}
 
-			status := statusStyle(file.Status).Render(fmt.Sprintf("[%s]", file.Status))
-			line := fmt.Sprintf("%s %s %s %s", cursor, checkbox, status, file.Path)
+// colors a single unified diff line
+func renderDiffLine(line string) string {
+	switch {
+	case strings.HasPrefix(line, "@@"):
+		return diffHunkStyle.Render(line)
+	case strings.HasPrefix(line, "+"):
+		return diffAddStyle.Render(line)
+	case strings.HasPrefix(line, "-"):
+		return diffDelStyle.Render(line)
+	default:
+		return line
+	}
+}
 
-			if m.cursor == i {
-				line = cursorStyle.Render(line)
-			}
+// terminal width, or the fallback before it is known
+func (m Model) viewWidth() int {
+	if m.width == 0 {
+		return defaultWidth
+	}
+	return m.width
+}
 
-			b.WriteString(line)
-			b.WriteString("\n")
+// widths of the file list and diff pane
+func (m Model) paneWidths() (int, int) {
+	width := m.viewWidth()
+
+	listWidth := max(width*2/5, 30)
+	return listWidth, max(width-listWidth, 10)
+}
+
+// upstream name with ahead/behind counts, like git status -sb
+func formatUpstream(u *UpstreamInfo) string {
+	switch {
+	case u.Gone:
+		return u.Name + ": gone"
+	case u.Ahead > 0 && u.Behind > 0:
+		return fmt.Sprintf("%s ↑%d ↓%d", u.Name, u.Ahead, u.Behind)
+	case u.Ahead > 0:
+		return fmt.Sprintf("%s ↑%d", u.Name, u.Ahead)
+	case u.Behind > 0:
+		return fmt.Sprintf("%s ↓%d", u.Name, u.Behind)
+	}
+	return u.Name
+}
+
+// rows available to the commit detail diff, below the commit message
+func (m Model) detailPaneHeight() int {
+	if m.commitDetail == nil {
+		return m.diffPaneHeight()
+	}
+
+	// header lines plus the indented message
+	used := 6 + strings.Count(m.commitDetail.Message, "\n") + 1
+	if m.commitDetail.Committer != m.commitDetail.Author+" <"+m.commitDetail.Email+">" {
+		used++
+	}
+	if m.commitDetail.Signature != signatureNone {
+		used++
+	}
+	return max(m.diffPaneHeight()-used, 5)
+}
+
+// progress of a running remote operation or the result of the last one
+func (m Model) statusLines() []string {
+	if m.remoteOp != "" {
+		lines := []string{progressStyle.Render(m.remoteOp + "ing...")}
+		// only the most recent progress lines fit
+		start := max(len(m.remoteProgress)-3, 0)
+		for _, line := range m.remoteProgress[start:] {
+			lines = append(lines, progressStyle.Render("  "+line))
 		}
+		return lines
 	}
 
-	b.WriteString("\n")
-	if len(m.files) == 0 {
-		b.WriteString(helpStyle.Render("b: branches • c: commit • q: quit"))
-	} else {
-		b.WriteString(helpStyle.Render("↑/↓ or j/k: navigate • space: toggle selection • s: stage selected • u: unstage selected • b: branches • c: commit • q: quit"))
+	if m.statusMessage == "" {
+		return nil
+	}
+	if m.statusIsError {
+		return []string{errorStyle.Render(m.statusMessage)}
 	}
+	return []string{progressStyle.Render(m.statusMessage)}
+}
 
-	return b.String()
+// rows available to the diff pane, leaving room for the title and help
+func (m Model) diffPaneHeight() int {
+	height := m.height
+	if height == 0 {
+		height = defaultHeight
+	}
+
+	return max(height-6-len(m.statusLines()), 3)
 }